@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"docker-registry-manager/internal/storage"
+)
+
+// runExport implements "export", which writes repositories with their tags,
+// manifests, blobs and descriptions to a gzipped tar archive
+func runExport(cf *configFlags, args []string) int {
+	fs := newFlagSet(cf, "export", "export [-o FILE] [REPOSITORY...]")
+	output := fs.String("o", "-", "Archive to write, or - for standard output")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	_, store, ok := openStorage(cf)
+	if !ok {
+		return 1
+	}
+	defer closeStorage(store)
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create archive: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	stats, err := storage.Export(context.Background(), store, w, fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		if *output != "-" {
+			os.Remove(*output)
+		}
+		return 1
+	}
+
+	// Standard output may be the archive, so report on stderr
+	fmt.Fprintf(os.Stderr, "Exported %d repositories, %d manifests and %d blobs (%s)\n",
+		stats.Repositories, stats.Manifests, stats.Blobs, formatBytes(stats.Bytes))
+	return 0
+}
+
+// runImport implements "import", which loads an archive written by export.
+// Blobs already present are skipped and existing tags are overwritten.
+func runImport(cf *configFlags, args []string) int {
+	fs := newFlagSet(cf, "import", "import [-i FILE]")
+	input := fs.String("i", "-", "Archive to read, or - for standard input")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	_, store, ok := openStorage(cf)
+	if !ok {
+		return 1
+	}
+	defer closeStorage(store)
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open archive: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+
+	stats, err := storage.Import(context.Background(), store, r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed after %d repositories: %v\n", stats.Repositories, err)
+		return 1
+	}
+
+	fmt.Printf("Imported %d repositories, %d manifests and %d blobs (%s)\n",
+		stats.Repositories, stats.Manifests, stats.Blobs, formatBytes(stats.Bytes))
+	return 0
+}
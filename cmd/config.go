@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"docker-registry-manager/internal/config"
+	"docker-registry-manager/internal/index"
+	"docker-registry-manager/internal/storage"
+)
+
+// runConfig implements "config check", which validates the configuration
+// without starting the server
+func runConfig(cf *configFlags, args []string) int {
+	fs := newFlagSet(cf, "config", "config check")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "check" {
+		fs.Usage()
+		return 2
+	}
+
+	if _, err := config.LoadConfig(cf.filename(), storage.Drivers(), cf.overrides...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	source := cf.filename()
+	if source == "" {
+		source = "defaults and environment"
+	}
+	fmt.Printf("Configuration OK (%s)\n", source)
+	return 0
+}
+
+// loadConfig loads the configuration, reporting any problems on stderr
+func loadConfig(cf *configFlags) (*config.Config, bool) {
+	cfg, err := config.LoadConfig(cf.filename(), storage.Drivers(), cf.overrides...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return nil, false
+	}
+	return cfg, true
+}
+
+// openStorage loads the configuration and opens the configured storage for
+// an offline maintenance command
+func openStorage(cf *configFlags) (*config.Config, storage.Storage, bool) {
+	cfg, ok := loadConfig(cf)
+	if !ok {
+		return nil, nil, false
+	}
+	store, ok := openDriver(cfg)
+	if !ok {
+		return nil, nil, false
+	}
+	if !cfg.Index.Enabled {
+		return cfg, store, true
+	}
+
+	// Keep the metadata index current with changes made here. A stale index
+	// passes queries through and is rebuilt when the server next starts.
+	metadataIndex, err := index.Open(store, cfg.GetIndexPath())
+	if errors.Is(err, index.ErrLocked) {
+		fmt.Fprintln(os.Stderr, "Warning: the metadata index is in use by the server; changes made by this command "+
+			"are not listed until it is rebuilt with POST /api/admin/index/rebuild")
+		return cfg, store, true
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open metadata index: %v\n", err)
+		return nil, nil, false
+	}
+	return cfg, metadataIndex, true
+}
+
+// openDriver opens the configured storage driver for an offline command,
+// reporting any problem on stderr
+func openDriver(cfg *config.Config) (storage.Storage, bool) {
+	// A fresh memory store is empty; the server's lives in its own process
+	if cfg.Storage.Type == "memory" {
+		fmt.Fprintln(os.Stderr, "Memory storage can only be used by the server")
+		return nil, false
+	}
+
+	store, err := newStorage(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize storage: %v\n", err)
+		return nil, false
+	}
+	return store, true
+}
+
+// closeStorage releases what the storage holds open, such as the index
+func closeStorage(store storage.Storage) {
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to close storage: %v\n", err)
+		}
+	}
+}
+
+// newStorage opens the driver selected by storage.type
+func newStorage(cfg *config.Config) (storage.Storage, error) {
+	return storage.Open(context.Background(), cfg.Storage.Type, cfg.Storage.DriverParameters())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"docker-registry-manager/internal/index"
+	"docker-registry-manager/internal/storage"
+)
+
+// runFsck implements "fsck", which reports storage inconsistencies per
+// repository and exits non-zero if any remain unrepaired
+func runFsck(cf *configFlags, args []string) int {
+	fs := newFlagSet(cf, "fsck", "fsck [-repair]")
+	repair := fs.Bool("repair", false, "Quarantine corrupt blobs, delete dangling tags and rewrite missing metadata")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	_, store, ok := openStorage(cf)
+	if !ok {
+		return 1
+	}
+	defer closeStorage(store)
+
+	// Repairs go straight to the files, so the index is rebuilt afterwards
+	metadataIndex, indexed := store.(*index.Index)
+	if indexed {
+		store = metadataIndex.Storage
+	}
+	filesystem, ok := store.(*storage.FilesystemStorage)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "fsck is only supported for filesystem storage")
+		return 1
+	}
+
+	report, err := filesystem.Fsck(context.Background(), storage.FsckOptions{Repair: *repair})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Consistency check failed: %v\n", err)
+		return 1
+	}
+
+	for _, repo := range report.Repositories {
+		if len(repo.Problems) == 0 {
+			continue
+		}
+		fmt.Printf("%s (%d tags, %d manifests)\n", repo.Name, repo.Tags, repo.Manifests)
+		for _, problem := range repo.Problems {
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+	if len(report.Blobs) > 0 {
+		fmt.Printf("blobs (%d checked)\n", report.BlobsChecked)
+		for _, problem := range report.Blobs {
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+
+	problems, repaired := report.Count()
+	if indexed && repaired > 0 {
+		if err := metadataIndex.Rebuild(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rebuild metadata index: %v\n", err)
+			return 1
+		}
+	}
+	fmt.Printf("Checked %d repositories and %d blobs: %d problems, %d repaired\n",
+		len(report.Repositories), report.BlobsChecked, problems, repaired)
+	if problems > repaired {
+		return 1
+	}
+	return 0
+}
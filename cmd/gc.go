@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"docker-registry-manager/internal/storage"
+)
+
+// runGC implements "gc", which deletes unreferenced blobs. The server should
+// be stopped or not receiving pushes while it runs.
+func runGC(cf *configFlags, args []string) int {
+	fs := newFlagSet(cf, "gc", "gc [-dry-run] [-untagged]")
+	dryRun := fs.Bool("dry-run", false, "Report what would be deleted without deleting it")
+	untagged := fs.Bool("untagged", false, "Also delete manifests that no tag refers to")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	_, store, ok := openStorage(cf)
+	if !ok {
+		return 1
+	}
+	defer closeStorage(store)
+
+	result, err := storage.GarbageCollect(context.Background(), store, storage.GCOptions{
+		DryRun:         *dryRun,
+		RemoveUntagged: *untagged,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Garbage collection failed: %v\n", err)
+		return 1
+	}
+
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+	for _, ref := range result.Manifests {
+		fmt.Printf("%s manifest %s\n", verb, ref)
+	}
+	for _, digest := range result.Blobs {
+		fmt.Printf("%s blob %s\n", verb, digest)
+	}
+	fmt.Printf("%s %d manifests and %d blobs, %s\n", verb, len(result.Manifests), len(result.Blobs), formatBytes(result.Reclaimed))
+	return 0
+}
+
+// formatBytes returns n in binary units, e.g. "12.3 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
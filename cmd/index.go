@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"docker-registry-manager/internal/index"
+)
+
+// runIndex implements "index rebuild", which rebuilds the metadata index
+// from storage while the server is stopped
+func runIndex(cf *configFlags, args []string) int {
+	fs := newFlagSet(cf, "index", "index rebuild")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "rebuild" {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, ok := loadConfig(cf)
+	if !ok {
+		return 1
+	}
+	// The index is opened below to rebuild it, so not through openStorage
+	store, ok := openDriver(cfg)
+	if !ok {
+		return 1
+	}
+
+	metadataIndex, err := index.Open(store, cfg.GetIndexPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open metadata index: %v\n", err)
+		return 1
+	}
+	defer metadataIndex.Close()
+
+	ctx := context.Background()
+	if err := metadataIndex.Rebuild(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to rebuild metadata index: %v\n", err)
+		return 1
+	}
+	stats, err := metadataIndex.Stats(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read metadata index: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Indexed %d repositories and %d tags, %s (%s)\n",
+		stats.Repositories, stats.Tags, formatBytes(stats.Size), cfg.GetIndexPath())
+	return 0
+}
@@ -1,106 +1,142 @@
 package main
 
 import (
-	"context"
 	"flag"
-	"net/http"
+	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"strings"
+)
 
-	"docker-registry-manager/internal/api"
-	"docker-registry-manager/internal/config"
-	"docker-registry-manager/internal/storage"
+// command is a subcommand of the registry binary
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(cf *configFlags, args []string) int
+}
 
-	"github.com/gorilla/handlers"
-	"github.com/sirupsen/logrus"
-)
+var commands = []command{
+	{"serve", "serve", "Start the registry server (the default)", runServe},
+	{"config", "config check", "Validate the configuration and exit", runConfig},
+	{"gc", "gc [-dry-run] [-untagged]", "Delete blobs no manifest references", runGC},
+	{"fsck", "fsck", "Check storage consistency", runFsck},
+	{"index", "index rebuild", "Rebuild the metadata index from storage", runIndex},
+	{"user", "user list|add|remove|passwd ...", "Manage local users", runUser},
+	{"repo", "repo list|delete ...", "List or delete repositories", runRepo},
+	{"tag", "tag list|delete ...", "List or delete tags", runTag},
+	{"export", "export [-o FILE] [REPOSITORY...]", "Write repositories to an archive", runExport},
+	{"import", "import [-i FILE]", "Load repositories from an archive", runImport},
+}
 
 func main() {
-	var configFile = flag.String("config", "config.yaml", "Configuration file path")
-	flag.Parse()
-
-	// Load configuration
-	cfg, err := config.LoadConfig(*configFile)
-	if err != nil {
-		logrus.Fatalf("Failed to load configuration: %v", err)
+	// -config and -set may come before the command as well as after it
+	cf := &configFlags{file: "config.yaml"}
+	global := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	cf.register(global)
+	global.Usage = func() { usage(global) }
+	global.Parse(os.Args[1:])
+
+	// Without a command the server starts, as it always has
+	args := global.Args()
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
 
-	// Setup logging
-	setupLogging(cfg.Logging)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(cf, args))
+		}
+	}
 
-	logrus.Info("Starting Docker Registry Manager...")
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	usage(global)
+	os.Exit(2)
+}
 
-	// Initialize storage
-	storageBackend, err := storage.NewFilesystemStorage(cfg.Storage.Path)
-	if err != nil {
-		logrus.Fatalf("Failed to initialize storage: %v", err)
+// usage prints the global flags and the list of commands
+func usage(global *flag.FlagSet) {
+	out := global.Output()
+	fmt.Fprintf(out, "Usage: %s [-config FILE] [-set KEY=VALUE]... COMMAND [ARGS]\n\nCommands:\n", global.Name())
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-36s %s\n", cmd.usage, cmd.summary)
 	}
+	fmt.Fprintln(out, "\nFlags:")
+	global.PrintDefaults()
+	fmt.Fprintf(out, "\nRun '%s COMMAND -h' for the flags of a command.\n", global.Name())
+}
 
-	// Create API router
-	router := api.NewRouter(cfg, storageBackend)
-
-	// Setup CORS if enabled
-	var handler http.Handler = router
-	if cfg.CORS.Enabled {
-		corsHandler := handlers.CORS(
-			handlers.AllowedOrigins(cfg.CORS.AllowedOrigins),
-			handlers.AllowedMethods(cfg.CORS.AllowedMethods),
-			handlers.AllowedHeaders(cfg.CORS.AllowedHeaders),
-		)
-		handler = corsHandler(router)
-	}
+// configFlags holds the -config and -set flags shared by every command
+type configFlags struct {
+	file      string
+	passed    bool
+	overrides stringList
+}
 
-	// Create HTTP server
-	server := &http.Server{
-		Addr:         cfg.GetAddress(),
-		Handler:      handler,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-	}
+// register adds -config and -set to fs. The values accumulate across flag
+// sets, so they can be given before or after the command name.
+func (cf *configFlags) register(fs *flag.FlagSet) {
+	fs.Var((*configFileFlag)(cf), "config", "Configuration file path")
+	fs.Var(&cf.overrides, "set", "Override a configuration value, e.g. -set server.port=7000 (repeatable)")
+}
 
-	// Start server in a goroutine
-	go func() {
-		logrus.Infof("Server starting on %s", cfg.GetAddress())
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logrus.Fatalf("Server failed to start: %v", err)
+// filename returns the configuration file to load. The default file is
+// optional so containers can configure the registry through REGISTRY_*
+// environment variables alone.
+func (cf *configFlags) filename() string {
+	if !cf.passed {
+		if _, err := os.Stat(cf.file); os.IsNotExist(err) {
+			return ""
 		}
-	}()
+	}
+	return cf.file
+}
 
-	// Wait for interrupt signal to gracefully shutdown the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+// configFileFlag is the flag.Value of -config
+type configFileFlag configFlags
 
-	logrus.Info("Shutting down server...")
+func (f *configFileFlag) String() string {
+	return f.file
+}
 
-	// Create a deadline for shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+func (f *configFileFlag) Set(value string) error {
+	f.file = value
+	f.passed = true
+	return nil
+}
 
-	// Attempt graceful shutdown
-	if err := server.Shutdown(ctx); err != nil {
-		logrus.Errorf("Server forced to shutdown: %v", err)
-	}
+// stringList collects a repeatable string flag
+type stringList []string
 
-	logrus.Info("Server exited")
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
 }
 
-func setupLogging(cfg config.LoggingConfig) {
-	level, err := logrus.ParseLevel(cfg.Level)
-	if err != nil {
-		logrus.Warnf("Invalid log level '%s', using 'info'", cfg.Level)
-		level = logrus.InfoLevel
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// newFlagSet returns the flag set of a command, with -config and -set
+// registered
+func newFlagSet(cf *configFlags, name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cf.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n\nFlags:\n", os.Args[0], usage)
+		fs.PrintDefaults()
 	}
-	logrus.SetLevel(level)
-
-	switch cfg.Format {
-	case "json":
-		logrus.SetFormatter(&logrus.JSONFormatter{})
-	default:
-		logrus.SetFormatter(&logrus.TextFormatter{
-			FullTimestamp: true,
-		})
+	return fs
+}
+
+// parseFlags parses args into fs and reports whether to continue; it
+// returns the exit code to use otherwise
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0, false
+		}
+		return 2, false
 	}
+	return 0, true
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// runRepo implements "repo list" and "repo delete NAME"
+func runRepo(cf *configFlags, args []string) int {
+	const usage = "repo list | repo delete NAME"
+	fs, action, ok := subcommand(cf, "repo", usage, args, map[string]int{"list": 0, "delete": 1})
+	if !ok {
+		return 2
+	}
+
+	_, store, ok := openStorage(cf)
+	if !ok {
+		return 1
+	}
+	defer closeStorage(store)
+	ctx := context.Background()
+
+	switch action {
+	case "list":
+		repositories, err := store.ListRepositories(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list repositories: %v\n", err)
+			return 1
+		}
+		sort.Strings(repositories)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tTAGS\tMANIFESTS")
+		for _, repository := range repositories {
+			tags, _ := store.ListTags(ctx, repository)
+			manifests, _ := store.ListManifests(ctx, repository)
+			fmt.Fprintf(w, "%s\t%d\t%d\n", repository, len(tags), len(manifests))
+		}
+		w.Flush()
+
+	case "delete":
+		repository := fs.Arg(0)
+		if err := store.DeleteRepository(ctx, repository); err != nil {
+			if os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Repository %s not found\n", repository)
+			} else {
+				fmt.Fprintf(os.Stderr, "Failed to delete repository %s: %v\n", repository, err)
+			}
+			return 1
+		}
+		fmt.Printf("Deleted repository %s; run gc to reclaim its blobs\n", repository)
+	}
+	return 0
+}
+
+// runTag implements "tag list REPOSITORY" and "tag delete REPOSITORY TAG"
+func runTag(cf *configFlags, args []string) int {
+	const usage = "tag list REPOSITORY | tag delete REPOSITORY TAG"
+	fs, action, ok := subcommand(cf, "tag", usage, args, map[string]int{"list": 1, "delete": 2})
+	if !ok {
+		return 2
+	}
+
+	_, store, ok := openStorage(cf)
+	if !ok {
+		return 1
+	}
+	defer closeStorage(store)
+	ctx := context.Background()
+	repository := fs.Arg(0)
+
+	switch action {
+	case "list":
+		tags, err := store.ListTags(ctx, repository)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list tags of %s: %v\n", repository, err)
+			return 1
+		}
+		sort.Strings(tags)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TAG\tDIGEST")
+		for _, tag := range tags {
+			digest, err := store.GetTagDigest(ctx, repository, tag)
+			if err != nil {
+				digest = "error: " + err.Error()
+			}
+			fmt.Fprintf(w, "%s\t%s\n", tag, digest)
+		}
+		w.Flush()
+
+	case "delete":
+		tag := fs.Arg(1)
+		if err := store.DeleteTag(ctx, repository, tag); err != nil {
+			if os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Tag %s:%s not found\n", repository, tag)
+			} else {
+				fmt.Fprintf(os.Stderr, "Failed to delete tag %s:%s: %v\n", repository, tag, err)
+			}
+			return 1
+		}
+		fmt.Printf("Deleted tag %s:%s\n", repository, tag)
+	}
+	return 0
+}
+
+// subcommand parses "<action> [flags] ARGS..." where actions maps each
+// action to its number of positional arguments
+func subcommand(cf *configFlags, name, usage string, args []string, actions map[string]int) (*flag.FlagSet, string, bool) {
+	fs := newFlagSet(cf, name, usage)
+	if len(args) == 0 {
+		fs.Usage()
+		return nil, "", false
+	}
+	action := args[0]
+	nargs, known := actions[action]
+	if !known {
+		fs.Usage()
+		return nil, "", false
+	}
+	if _, ok := parseFlags(fs, args[1:]); !ok {
+		return nil, "", false
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return nil, "", false
+	}
+	return fs, action, true
+}
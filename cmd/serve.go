@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"docker-registry-manager/internal/api"
+	"docker-registry-manager/internal/audit"
+	"docker-registry-manager/internal/auth"
+	"docker-registry-manager/internal/config"
+	"docker-registry-manager/internal/health"
+	"docker-registry-manager/internal/index"
+	"docker-registry-manager/internal/metrics"
+	"docker-registry-manager/internal/notifications"
+	"docker-registry-manager/internal/scrub"
+	"docker-registry-manager/internal/storage"
+	"docker-registry-manager/internal/tlsutil"
+	"docker-registry-manager/internal/tracing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runServe starts the registry server and runs until SIGINT or SIGTERM
+func runServe(cf *configFlags, args []string) int {
+	fs := newFlagSet(cf, "serve", "serve")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	filename, overrides := cf.filename(), cf.overrides
+
+	// Load configuration; every problem is listed so they can be fixed at once
+	cfg, ok := loadConfig(cf)
+	if !ok {
+		return 1
+	}
+
+	// Setup logging
+	setupLogging(cfg.Logging)
+
+	logrus.Info("Starting Docker Registry Manager...")
+
+	// Export request and storage spans if configured
+	if cfg.Tracing.Enabled {
+		shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+		if err != nil {
+			logrus.Fatalf("Failed to initialize tracing: %v", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				logrus.Errorf("Failed to flush traces: %v", err)
+			}
+		}()
+		logrus.Info("Tracing enabled")
+	}
+
+	// Initialize storage
+	storageBackend, err := newStorage(cfg)
+	if err != nil {
+		logrus.Fatalf("Failed to initialize storage: %v", err)
+	}
+	logrus.Infof("Using %s storage", cfg.Storage.Type)
+
+	// Readiness requires writable storage with enough free space. The
+	// storage path also holds local state with remote storage types.
+	checker := health.New()
+	checker.Add("storage_writable", health.Writable(cfg.Storage.Path))
+	checker.Add("disk_space", health.DiskSpace(cfg.Storage.Path, cfg.GetHealthMinFreeBytes()))
+	if remote, ok := storageBackend.(interface{ CheckHealth(context.Context) error }); ok {
+		checker.Add("storage_reachable", remote.CheckHealth)
+	}
+
+	// Record pushes and deletes in the metadata index, which then answers
+	// listings and statistics; it is rebuilt if it may be behind the storage
+	var metadataIndex *index.Index
+	if cfg.Index.Enabled {
+		metadataIndex, err = index.Open(storageBackend, cfg.GetIndexPath())
+		if err != nil {
+			logrus.Fatalf("Failed to open metadata index: %v", err)
+		}
+		defer metadataIndex.Close()
+		if metadataIndex.Stale() {
+			logrus.Infof("Rebuilding metadata index %s...", cfg.GetIndexPath())
+			if err := metadataIndex.Rebuild(context.Background()); err != nil {
+				logrus.Fatalf("Failed to rebuild metadata index: %v", err)
+			}
+		}
+		storageBackend = metadataIndex
+		logrus.Infof("Metadata index enabled (%s)", cfg.GetIndexPath())
+	}
+
+	// Load robot accounts and access tokens
+	tokenStore, err := auth.NewTokenStore(cfg.GetTokenFile())
+	if err != nil {
+		logrus.Fatalf("Failed to load access tokens: %v", err)
+	}
+
+	// Local users are managed offline with the user command
+	userStore, err := auth.NewUserStore(cfg.GetUsersFile())
+	if err != nil {
+		logrus.Fatalf("Failed to load users: %v", err)
+	}
+
+	routerOpts := []api.Option{
+		api.WithTokenStore(tokenStore),
+		api.WithAuthenticators(userStore),
+		api.WithHealth(checker),
+	}
+	if metadataIndex != nil {
+		routerOpts = append(routerOpts, api.WithIndex(metadataIndex))
+	}
+
+	// Authenticate against the company directory if configured
+	if cfg.Auth.LDAP.Enabled {
+		ldapAuth, err := auth.NewLDAPAuthenticator(cfg.Auth.LDAP, nil)
+		if err != nil {
+			logrus.Fatalf("Failed to configure LDAP authentication: %v", err)
+		}
+		routerOpts = append(routerOpts, api.WithAuthenticators(ldapAuth))
+		logrus.Infof("LDAP authentication enabled (%s)", cfg.Auth.LDAP.URL)
+	}
+
+	// Single sign-on for the web UI
+	if cfg.Auth.OIDC.Enabled {
+		oidcAuth, err := auth.NewOIDCAuthenticator(cfg.Auth.OIDC)
+		if err != nil {
+			logrus.Fatalf("Failed to configure OIDC login: %v", err)
+		}
+		routerOpts = append(routerOpts, api.WithOIDC(oidcAuth))
+		logrus.Infof("OIDC login enabled (%s)", cfg.Auth.OIDC.Issuer)
+	}
+
+	// Authenticate build agents by TLS client certificate if configured
+	var clientCerts *auth.ClientCertAuthenticator
+	if cfg.Auth.ClientCert.Enabled {
+		if !cfg.Server.TLS.Enabled {
+			logrus.Fatal("Client certificate authentication requires server.tls")
+		}
+		clientCerts, err = auth.NewClientCertAuthenticator(cfg.Auth.ClientCert)
+		if err != nil {
+			logrus.Fatalf("Failed to configure client certificate authentication: %v", err)
+		}
+		routerOpts = append(routerOpts, api.WithClientCerts(clientCerts))
+		logrus.Infof("Client certificate authentication enabled (%s)", cfg.Auth.ClientCert.CAFile)
+	}
+
+	// Open the audit log
+	if cfg.Audit.Enabled {
+		maxSize := int64(cfg.Audit.MaxSizeMB) * 1024 * 1024
+		if maxSize <= 0 {
+			maxSize = 100 * 1024 * 1024
+		}
+		auditLog, err := audit.NewLogger(cfg.GetAuditPath(), maxSize, cfg.Audit.MaxBackups)
+		if err != nil {
+			logrus.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer auditLog.Close()
+		routerOpts = append(routerOpts, api.WithAuditLog(auditLog))
+		logrus.Infof("Audit log enabled (%s)", cfg.GetAuditPath())
+	}
+
+	// Start webhook delivery
+	if len(cfg.Notifications.Endpoints) > 0 {
+		notifier, err := notifications.NewNotifier(cfg.Notifications.Endpoints, cfg.GetNotificationQueuePath())
+		if err != nil {
+			logrus.Fatalf("Failed to configure notifications: %v", err)
+		}
+		defer notifier.Close()
+		routerOpts = append(routerOpts, api.WithNotifier(notifier))
+		checker.Add("notifications", notifier.CheckHealth)
+		logrus.Infof("Webhook notifications enabled (%d endpoints)", len(cfg.Notifications.Endpoints))
+	}
+
+	// Write Common/Combined access lines to a file if configured
+	if cfg.Logging.AccessLog != "" {
+		accessLog, err := os.OpenFile(cfg.Logging.AccessLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			logrus.Fatalf("Failed to open access log: %v", err)
+		}
+		defer accessLog.Close()
+		routerOpts = append(routerOpts, api.WithAccessLog(accessLog))
+	}
+
+	// Expose Prometheus metrics
+	var registryMetrics *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		registryMetrics = metrics.New(storageBackend, cfg.GetMetricsStorageInterval())
+		routerOpts = append(routerOpts, api.WithMetrics(registryMetrics))
+		logrus.Infof("Prometheus metrics enabled at %s", cfg.GetMetricsPath())
+	}
+
+	// Trace storage calls made while serving requests
+	var routerStorage storage.Storage = storageBackend
+	if cfg.Tracing.Enabled {
+		routerStorage = tracing.WrapStorage(routerStorage)
+	}
+
+	// Create API router
+	router := api.NewRouter(cfg, routerStorage, routerOpts...)
+
+	// Re-verify stored blobs in the background, quarantining corrupt ones
+	if cfg.Scrub.Enabled {
+		scrubber, err := scrub.New(storageBackend, scrub.Options{
+			Interval:       cfg.GetScrubInterval(),
+			BytesPerSecond: cfg.GetScrubRate(),
+			StatePath:      cfg.GetScrubStateFile(),
+			Metrics:        registryMetrics,
+			OnQuarantine:   router.BlobQuarantined,
+		})
+		if err != nil {
+			logrus.Fatalf("Failed to start blob scrubbing: %v", err)
+		}
+		defer scrubber.Close()
+		checker.Add("scrubber", scrubber.CheckHealth)
+		logrus.Infof("Blob scrubbing enabled (every %s, %s/s)", cfg.GetScrubInterval(), formatBytes(cfg.GetScrubRate()))
+	}
+
+	// Create HTTP server
+	server := &http.Server{
+		Addr:         cfg.GetAddress(),
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+
+	// Serve HTTPS directly if configured, with certificates reloaded on renewal
+	var redirectServer *http.Server
+	if cfg.Server.TLS.Enabled {
+		reloader, err := tlsutil.NewReloader(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile, cfg.GetTLSReloadInterval())
+		if err != nil {
+			logrus.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		defer reloader.Close()
+		checker.Add("tls_certificate", reloader.CheckHealth)
+
+		server.TLSConfig, err = tlsutil.ServerConfig(cfg.Server.TLS, reloader)
+		if err != nil {
+			logrus.Fatalf("Invalid TLS configuration: %v", err)
+		}
+		if clientCerts != nil {
+			clientCerts.ConfigureTLS(server.TLSConfig)
+		}
+
+		if cfg.Server.TLS.RedirectAddr != "" {
+			redirectServer = &http.Server{
+				Addr:         cfg.Server.TLS.RedirectAddr,
+				Handler:      tlsutil.RedirectHandler(cfg.Server.Port),
+				ReadTimeout:  cfg.Server.ReadTimeout,
+				WriteTimeout: cfg.Server.WriteTimeout,
+			}
+			go func() {
+				logrus.Infof("Redirecting HTTP on %s to HTTPS", cfg.Server.TLS.RedirectAddr)
+				if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logrus.Fatalf("Redirect listener failed to start: %v", err)
+				}
+			}()
+		}
+	}
+
+	// Start server in a goroutine
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			logrus.Infof("Server starting on %s (HTTPS)", cfg.GetAddress())
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			logrus.Infof("Server starting on %s", cfg.GetAddress())
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+
+	// Reload the configuration on SIGHUP until asked to shut down
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	// Wait for interrupt signal to gracefully shutdown the server
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	for waiting := true; waiting; {
+		select {
+		case <-reload:
+			cfg = reloadConfig(cfg, filename, overrides, router)
+		case <-quit:
+			waiting = false
+		}
+	}
+	signal.Stop(reload)
+
+	// Fail readiness first so load balancers stop sending new requests
+	checker.Drain()
+	logrus.Infof("Draining for %s before shutdown...", cfg.GetHealthDrainDelay())
+	select {
+	case <-time.After(cfg.GetHealthDrainDelay()):
+	case <-quit:
+	}
+
+	logrus.Info("Shutting down server...")
+
+	// Create a deadline for shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Attempt graceful shutdown
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.Errorf("Server forced to shutdown: %v", err)
+	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
+
+	logrus.Info("Server exited")
+	return 0
+}
+
+// reloadConfig re-reads and validates the configuration and applies the
+// settings that can change at runtime. Settings that need a restart are left
+// as they are and logged. It returns the configuration now in effect.
+func reloadConfig(current *config.Config, filename string, overrides []string, router *api.Router) *config.Config {
+	logrus.Info("Reloading configuration...")
+
+	next, err := config.LoadConfig(filename, storage.Drivers(), overrides...)
+	if err != nil {
+		logrus.Errorf("Configuration reload failed, keeping the current configuration: %v", err)
+		return current
+	}
+
+	applied, restartKeys := config.ApplyReloadable(current, next)
+	// The kept settings may conflict with the new ones, e.g. web still
+	// enabled while the new file disables auth
+	if err := applied.Validate(storage.Drivers()); err != nil {
+		logrus.Errorf("Configuration reload failed, keeping the current configuration: %v", err)
+		return current
+	}
+	if len(restartKeys) > 0 {
+		logrus.Warnf("Not applying changes that require a restart: %s", strings.Join(restartKeys, ", "))
+	}
+
+	setupLogging(applied.Logging)
+	router.Reload(applied)
+	logrus.Info("Configuration reloaded")
+	return applied
+}
+
+func setupLogging(cfg config.LoggingConfig) {
+	level, err := logrus.ParseLevel(cfg.Level)
+	if err != nil {
+		logrus.Warnf("Invalid log level '%s', using 'info'", cfg.Level)
+		level = logrus.InfoLevel
+	}
+	logrus.SetLevel(level)
+
+	switch cfg.Format {
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		logrus.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp: true,
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	"docker-registry-manager/internal/auth"
+)
+
+const userUsage = `user list
+       user add [-role ROLE] [-password-stdin] NAME
+       user remove NAME
+       user passwd [-password-stdin] NAME`
+
+// runUser implements "user", which manages the local users a running server
+// picks up without a restart
+func runUser(cf *configFlags, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s\n", os.Args[0], userUsage)
+		return 2
+	}
+	action, args := args[0], args[1:]
+
+	fs := newFlagSet(cf, "user "+action, userUsage)
+	role := "developer"
+	passwordStdin := false
+	switch action {
+	case "add":
+		fs.StringVar(&role, "role", role, "Role of the user: admin, developer or reader")
+		fs.BoolVar(&passwordStdin, "password-stdin", false, "Read the password from standard input")
+	case "passwd":
+		fs.BoolVar(&passwordStdin, "password-stdin", false, "Read the password from standard input")
+	case "list", "remove":
+	default:
+		fs.Usage()
+		return 2
+	}
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if (action == "list") != (fs.NArg() == 0) || fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	username := fs.Arg(0)
+
+	cfg, ok := loadConfig(cf)
+	if !ok {
+		return 1
+	}
+	users, err := auth.NewUserStore(cfg.GetUsersFile())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch action {
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tROLE\tUPDATED")
+		for _, u := range users.List() {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", u.Username, u.Role, u.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		w.Flush()
+		return 0
+
+	case "add":
+		password, err := readPassword(passwordStdin)
+		if err == nil {
+			err = users.Add(username, password, auth.Role(role))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add user %s: %v\n", username, err)
+			return 1
+		}
+		fmt.Printf("Added user %s (%s)\n", username, role)
+
+	case "remove":
+		if err := users.Remove(username); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove user %s: %v\n", username, err)
+			return 1
+		}
+		fmt.Printf("Removed user %s\n", username)
+
+	case "passwd":
+		if !userExists(users, username) {
+			fmt.Fprintf(os.Stderr, "Failed to change password of %s: %v\n", username, auth.ErrUserNotFound)
+			return 1
+		}
+		password, err := readPassword(passwordStdin)
+		if err == nil {
+			err = users.SetPassword(username, password)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to change password of %s: %v\n", username, err)
+			return 1
+		}
+		fmt.Printf("Changed password of %s\n", username)
+	}
+	return 0
+}
+
+// userExists reports whether username is a local user
+func userExists(users *auth.UserStore, username string) bool {
+	for _, u := range users.List() {
+		if u.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// readPassword reads a new password, prompting twice on a terminal. With
+// fromStdin, or when stdin is not a terminal, the first line of stdin is used.
+func readPassword(fromStdin bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if fromStdin || !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	repeated, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	if string(password) != string(repeated) {
+		return "", errors.New("passwords do not match")
+	}
+	return string(password), nil
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/auth"
+)
+
+// requestIDHeader carries the request ID to and from clients
+const requestIDHeader = "X-Request-ID"
+
+// Access log formats
+const (
+	AccessFormatCommon   = "common"
+	AccessFormatCombined = "combined"
+)
+
+// accessEntry collects per-request details that are only known after
+// routing and authentication, for the access log line
+type accessEntry struct {
+	requestID string
+	user      string
+}
+
+type accessEntryKey struct{}
+
+// requestID returns the ID assigned to req by the logging middleware
+func requestID(req *http.Request) string {
+	if entry, ok := req.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+		return entry.requestID
+	}
+	return ""
+}
+
+// setAccessUser records the authenticated user of req for the access log
+func setAccessUser(req *http.Request, identity *auth.Identity) {
+	if entry, ok := req.Context().Value(accessEntryKey{}).(*accessEntry); ok && identity != nil {
+		entry.user = identity.Username
+	}
+}
+
+// loggingMiddleware assigns each request an ID and logs it once the response
+// has been written, with status, latency and size
+func (r *Router) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+
+		entry := &accessEntry{requestID: req.Header.Get(requestIDHeader)}
+		if !validRequestID(entry.requestID) {
+			entry.requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, entry.requestID)
+		req = req.WithContext(context.WithValue(req.Context(), accessEntryKey{}, entry))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		user := entry.user
+		if user == "" {
+			if actor := r.auditActor(req); actor != "anonymous" {
+				user = actor
+			}
+		}
+
+		if r.accessLog != nil {
+			r.accessLog.Print(r.formatAccessLine(req, start, status, recorder.bytes, user))
+			return
+		}
+
+		fields := logrus.Fields{
+			"request_id": entry.requestID,
+			"method":     req.Method,
+			"path":       req.URL.Path,
+			"status":     status,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"bytes":      recorder.bytes,
+			"remote":     r.clientIP(req),
+		}
+		if user != "" {
+			fields["user"] = user
+		}
+		if name := mux.Vars(req)["name"]; name != "" {
+			fields["repository"] = name
+		}
+		logrus.WithFields(fields).Info("HTTP request")
+	})
+}
+
+// formatAccessLine renders a Common or Combined Log Format line
+func (r *Router) formatAccessLine(req *http.Request, start time.Time, status int, bytes int64, user string) string {
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		r.clientIP(req),
+		orDash(user),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method+" "+req.URL.RequestURI()+" "+req.Proto,
+		status,
+		size,
+	)
+
+	if r.cfg().Logging.AccessFormat == AccessFormatCombined {
+		line += fmt.Sprintf(" %q %q", orDash(req.Referer()), orDash(req.UserAgent()))
+	}
+	return line
+}
+
+// orDash returns s, or "-" for an empty field as the log formats expect
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// validRequestID accepts client-supplied IDs that are safe to echo and log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
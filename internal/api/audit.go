@@ -0,0 +1,228 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/audit"
+	"docker-registry-manager/internal/auth"
+)
+
+// auditAction names the audited action of a request, or "" to skip it
+type auditAction func(req *http.Request) string
+
+// auditAs returns an auditAction that always reports action
+func auditAs(action string) auditAction {
+	return func(*http.Request) string {
+		return action
+	}
+}
+
+// statusRecorder remembers the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers such as the event stream flush through the recorder
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// audited records the outcome of a mutating request in the audit log
+func (r *Router) audited(action auditAction, next http.Handler) http.Handler {
+	if r.auditLog == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := action(req)
+		if name == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+
+		vars := mux.Vars(req)
+		reference := vars["reference"]
+		if reference == "" {
+			reference = vars["tag"]
+		}
+
+		digest := w.Header().Get("Docker-Content-Digest")
+		if digest == "" {
+			digest = vars["digest"]
+		}
+		if digest == "" {
+			digest = req.URL.Query().Get("digest")
+		}
+
+		r.recordAudit(req, audit.Event{
+			Action:     name,
+			Repository: vars["name"],
+			Reference:  reference,
+			Digest:     digest,
+			Status:     recorder.status,
+		})
+	})
+}
+
+// recordAudit fills in actor, client and outcome and appends the event
+func (r *Router) recordAudit(req *http.Request, event audit.Event) {
+	if r.auditLog == nil {
+		return
+	}
+
+	if event.Actor == "" {
+		event.Actor = r.auditActor(req)
+	}
+	if event.ClientIP == "" {
+		event.ClientIP = r.clientIP(req)
+	}
+	if event.Outcome == "" {
+		event.Outcome = audit.OutcomeSuccess
+		if event.Status == 0 || event.Status >= http.StatusBadRequest {
+			event.Outcome = audit.OutcomeFailure
+		}
+	}
+
+	if err := r.auditLog.Log(event); err != nil {
+		logrus.Errorf("Failed to write audit event %s: %v", event.Action, err)
+	}
+}
+
+// auditActor names who made the request: a verified identity if the request
+// was authenticated, otherwise the username it claimed
+func (r *Router) auditActor(req *http.Request) string {
+	if identity := auth.IdentityFromContext(req.Context()); identity != nil {
+		return identity.Username
+	}
+	if identity := r.sessionIdentity(req); identity != nil {
+		return identity.Username
+	}
+	if username, _, ok := req.BasicAuth(); ok {
+		return username
+	}
+	return "anonymous"
+}
+
+// handleAuditQuery returns audit events matching the query parameters
+func (r *Router) handleAuditQuery(w http.ResponseWriter, req *http.Request) {
+	filter, err := parseAuditFilter(req)
+	if err != nil {
+		r.writeError(w, http.StatusBadRequest, ErrorCodeUnsupported, err.Error())
+		return
+	}
+
+	events, err := r.auditLog.Query(filter)
+	if err != nil {
+		logrus.Errorf("Failed to query audit log: %v", err)
+		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to query audit log")
+		return
+	}
+	if events == nil {
+		events = []audit.Event{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// handleWebAudit renders the audit log page
+func (r *Router) handleWebAudit(w http.ResponseWriter, req *http.Request) {
+	identity := r.sessionIdentity(req)
+	if identity == nil {
+		http.Redirect(w, req, "/login", http.StatusFound)
+		return
+	}
+	if !identity.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	filter, err := parseAuditFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := r.auditLog.Query(filter)
+	if err != nil {
+		logrus.Errorf("Failed to query audit log: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := r.newWebData(req)
+	data.AuditEvents = events
+	data.AuditFilter = filter
+
+	r.renderTemplate(w, "audit.html", data)
+}
+
+// parseAuditFilter reads actor, action, repository, outcome, since, until
+// (RFC 3339) and limit from the query string
+func parseAuditFilter(req *http.Request) (audit.Filter, error) {
+	query := req.URL.Query()
+	filter := audit.Filter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		Repository: query.Get("repository"),
+		Outcome:    query.Get("outcome"),
+		Limit:      200,
+	}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, err
+		}
+		filter.Since = t
+	}
+	if until := query.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, err
+		}
+		filter.Until = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+		filter.Limit = n
+	}
+
+	return filter, nil
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +9,10 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/events"
+	"docker-registry-manager/internal/metrics"
+	"docker-registry-manager/internal/notifications"
 )
 
 // handleBlobGet handles GET requests for blobs
@@ -28,8 +31,14 @@ func (r *Router) handleBlobGet(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Never serve content known to be corrupt; clients may push it again
+	if r.isQuarantined(req, digest) {
+		r.writeError(w, http.StatusNotFound, ErrorCodeBlobUnknown, "Blob is quarantined after failing an integrity check")
+		return
+	}
+
 	// Get blob reader
-	reader, size, err := r.storage.GetBlob(digest)
+	reader, size, err := r.storage.GetBlob(req.Context(), digest)
 	if err != nil {
 		logrus.Errorf("Failed to get blob %s: %v", digest, err)
 		r.writeError(w, http.StatusNotFound, ErrorCodeBlobUnknown, "Blob not found")
@@ -44,9 +53,14 @@ func (r *Router) handleBlobGet(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	// Stream blob data
-	if _, err := io.Copy(w, reader); err != nil {
+	n, err := io.Copy(w, reader)
+	r.metrics.BlobSent(n)
+	if err != nil {
 		logrus.Errorf("Failed to stream blob %s: %v", digest, err)
+		return
 	}
+
+	r.notify(req, notifications.ActionPull, blobTarget(req, name, digest, size))
 }
 
 // handleBlobHead handles HEAD requests for blobs
@@ -65,8 +79,14 @@ func (r *Router) handleBlobHead(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// A quarantined blob reads as missing so clients push it again
+	if r.isQuarantined(req, digest) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
 	// Check if blob exists
-	size, err := r.storage.GetBlobSize(digest)
+	size, err := r.storage.GetBlobSize(req.Context(), digest)
 	if err != nil {
 		logrus.Errorf("Failed to get blob size %s: %v", digest, err)
 		w.WriteHeader(http.StatusNotFound)
@@ -80,6 +100,20 @@ func (r *Router) handleBlobHead(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// isQuarantined reports whether a blob failed verification and must not be
+// served. Lookup errors are logged and treated as not quarantined.
+func (r *Router) isQuarantined(req *http.Request, digest string) bool {
+	quarantined, err := r.storage.IsBlobQuarantined(req.Context(), digest)
+	if err != nil {
+		logrus.Errorf("Failed to check quarantine of blob %s: %v", digest, err)
+		return false
+	}
+	if quarantined {
+		logrus.Warnf("Refusing to serve quarantined blob %s", digest)
+	}
+	return quarantined
+}
+
 // handleBlobDelete handles DELETE requests for blobs
 func (r *Router) handleBlobDelete(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
@@ -97,13 +131,20 @@ func (r *Router) handleBlobDelete(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Delete blob
-	if err := r.storage.DeleteBlob(digest); err != nil {
+	if err := r.storage.DeleteBlob(req.Context(), digest); err != nil {
 		logrus.Errorf("Failed to delete blob %s: %v", digest, err)
 		r.writeError(w, http.StatusNotFound, ErrorCodeBlobUnknown, "Blob not found")
 		return
 	}
 
 	w.WriteHeader(http.StatusAccepted)
+
+	r.notify(req, notifications.ActionDelete, notifications.Target{Repository: name, Digest: digest})
+	r.publishActivity(req, events.Event{
+		Type:       events.TypeBlobDelete,
+		Repository: name,
+		Digest:     digest,
+	})
 }
 
 // handleBlobUploadPost handles POST requests to initiate blob uploads
@@ -116,20 +157,31 @@ func (r *Router) handleBlobUploadPost(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	// Check for monolithic upload (digest parameter)
+	// Refuse uploads to a repository whose quota is full, or that the
+	// blob of a monolithic upload would overfill
 	digest := req.URL.Query().Get("digest")
+	size := req.ContentLength
+	if digest == "" || size < 0 {
+		size = 0
+	}
+	if !r.quotaAllows(w, name, r.quotas.CheckUpload(req.Context(), r.cfg().Quotas, name, digest, size)) {
+		return
+	}
+
+	// Check for monolithic upload (digest parameter)
 	if digest != "" {
 		r.handleMonolithicUpload(w, req, name, digest)
 		return
 	}
 
 	// Start chunked upload
-	uploadID, err := r.storage.StartBlobUpload()
+	uploadID, err := r.storage.StartBlobUpload(req.Context())
 	if err != nil {
 		logrus.Errorf("Failed to start blob upload: %v", err)
 		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to start upload")
 		return
 	}
+	r.metrics.UploadStarted()
 
 	// Set response headers
 	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, uploadID))
@@ -146,7 +198,7 @@ func (r *Router) handleMonolithicUpload(w http.ResponseWriter, req *http.Request
 	}
 
 	// Read the entire blob
-	data, err := io.ReadAll(req.Body)
+	data, err := readBody(req)
 	if err != nil {
 		logrus.Errorf("Failed to read blob data: %v", err)
 		r.writeError(w, http.StatusBadRequest, ErrorCodeBlobUploadInvalid, "Failed to read blob data")
@@ -154,24 +206,33 @@ func (r *Router) handleMonolithicUpload(w http.ResponseWriter, req *http.Request
 	}
 
 	// Verify digest
-	hash := sha256.Sum256(data)
-	calculatedDigest := fmt.Sprintf("sha256:%x", hash)
+	calculatedDigest := sha256Digest(req.Context(), data)
 	if calculatedDigest != digest {
 		r.writeError(w, http.StatusBadRequest, ErrorCodeDigestInvalid, "Digest mismatch")
 		return
 	}
 
 	// Store blob
-	if err := r.storage.PutBlob(digest, data); err != nil {
+	if err := r.storage.PutBlob(req.Context(), digest, data); err != nil {
 		logrus.Errorf("Failed to store blob %s: %v", digest, err)
 		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to store blob")
 		return
 	}
+	r.metrics.BlobReceived(int64(len(data)))
+	r.metrics.UploadCompleted(metrics.UploadMonolithic)
 
 	// Set response headers
 	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, digest))
 	w.Header().Set("Docker-Content-Digest", digest)
 	w.WriteHeader(http.StatusCreated)
+
+	r.notify(req, notifications.ActionPush, blobTarget(req, name, digest, int64(len(data))))
+	r.publishActivity(req, events.Event{
+		Type:       events.TypeBlobPush,
+		Repository: name,
+		Digest:     digest,
+		Size:       int64(len(data)),
+	})
 }
 
 // handleBlobUploadPatch handles PATCH requests for chunked uploads
@@ -186,7 +247,7 @@ func (r *Router) handleBlobUploadPatch(w http.ResponseWriter, req *http.Request)
 	}
 
 	// Read chunk data
-	data, err := io.ReadAll(req.Body)
+	data, err := readBody(req)
 	if err != nil {
 		logrus.Errorf("Failed to read chunk data: %v", err)
 		r.writeError(w, http.StatusBadRequest, ErrorCodeBlobUploadInvalid, "Failed to read chunk data")
@@ -194,18 +255,26 @@ func (r *Router) handleBlobUploadPatch(w http.ResponseWriter, req *http.Request)
 	}
 
 	// Append chunk to upload
-	offset, err := r.storage.AppendBlobUpload(uuid, data)
+	offset, err := r.storage.AppendBlobUpload(req.Context(), uuid, data)
 	if err != nil {
 		logrus.Errorf("Failed to append to blob upload %s: %v", uuid, err)
 		r.writeError(w, http.StatusNotFound, ErrorCodeBlobUploadUnknown, "Upload not found")
 		return
 	}
+	r.metrics.BlobReceived(int64(len(data)))
 
 	// Set response headers
 	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, uuid))
 	w.Header().Set("Range", fmt.Sprintf("0-%d", offset-1))
 	w.Header().Set("Docker-Upload-UUID", uuid)
 	w.WriteHeader(http.StatusAccepted)
+
+	r.publishActivity(req, events.Event{
+		Type:       events.TypeUploadProgress,
+		Repository: name,
+		Upload:     uuid,
+		Size:       offset,
+	})
 }
 
 // handleBlobUploadPut handles PUT requests to complete chunked uploads
@@ -227,7 +296,7 @@ func (r *Router) handleBlobUploadPut(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Read final chunk (if any)
-	data, err := io.ReadAll(req.Body)
+	data, err := readBody(req)
 	if err != nil {
 		logrus.Errorf("Failed to read final chunk data: %v", err)
 		r.writeError(w, http.StatusBadRequest, ErrorCodeBlobUploadInvalid, "Failed to read final chunk")
@@ -235,7 +304,7 @@ func (r *Router) handleBlobUploadPut(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Complete upload
-	if err := r.storage.CompleteBlobUpload(uuid, digest, data); err != nil {
+	if err := r.storage.CompleteBlobUpload(req.Context(), uuid, digest, data); err != nil {
 		logrus.Errorf("Failed to complete blob upload %s: %v", uuid, err)
 		if strings.Contains(err.Error(), "digest mismatch") {
 			r.writeError(w, http.StatusBadRequest, ErrorCodeDigestInvalid, "Digest mismatch")
@@ -244,11 +313,23 @@ func (r *Router) handleBlobUploadPut(w http.ResponseWriter, req *http.Request) {
 		}
 		return
 	}
+	r.metrics.BlobReceived(int64(len(data)))
+	r.metrics.UploadCompleted(metrics.UploadChunked)
 
 	// Set response headers
 	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, digest))
 	w.Header().Set("Docker-Content-Digest", digest)
 	w.WriteHeader(http.StatusCreated)
+
+	size, _ := r.storage.GetBlobSize(req.Context(), digest)
+	r.notify(req, notifications.ActionPush, blobTarget(req, name, digest, size))
+	r.publishActivity(req, events.Event{
+		Type:       events.TypeBlobPush,
+		Repository: name,
+		Digest:     digest,
+		Upload:     uuid,
+		Size:       size,
+	})
 }
 
 // handleBlobUploadGet handles GET requests for upload status
@@ -263,7 +344,7 @@ func (r *Router) handleBlobUploadGet(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Get upload status
-	offset, err := r.storage.GetBlobUploadStatus(uuid)
+	offset, err := r.storage.GetBlobUploadStatus(req.Context(), uuid)
 	if err != nil {
 		logrus.Errorf("Failed to get blob upload status %s: %v", uuid, err)
 		r.writeError(w, http.StatusNotFound, ErrorCodeBlobUploadUnknown, "Upload not found")
@@ -289,12 +370,23 @@ func (r *Router) handleBlobUploadDelete(w http.ResponseWriter, req *http.Request
 	}
 
 	// Cancel upload
-	if err := r.storage.CancelBlobUpload(uuid); err != nil {
+	if err := r.storage.CancelBlobUpload(req.Context(), uuid); err != nil {
 		logrus.Errorf("Failed to cancel blob upload %s: %v", uuid, err)
 		r.writeError(w, http.StatusNotFound, ErrorCodeBlobUploadUnknown, "Upload not found")
 		return
 	}
+	r.metrics.UploadCancelled()
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// blobTarget describes a blob for notification events
+func blobTarget(req *http.Request, name, digest string, size int64) notifications.Target {
+	return notifications.Target{
+		MediaType:  "application/octet-stream",
+		Size:       size,
+		Digest:     digest,
+		Repository: name,
+		URL:        eventURL(req, name, "blobs", digest),
+	}
+}
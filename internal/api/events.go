@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/auth"
+	"docker-registry-manager/internal/events"
+)
+
+// eventKeepAlive is how often an idle event stream sends a comment so
+// proxies do not close it
+const eventKeepAlive = 15 * time.Second
+
+// publishActivity broadcasts registry activity to live event streams
+func (r *Router) publishActivity(req *http.Request, event events.Event) {
+	if event.Actor == "" {
+		event.Actor = r.auditActor(req)
+	}
+	r.events.Publish(event)
+}
+
+// BlobQuarantined announces on the activity stream that a blob failed
+// background verification and was quarantined
+func (r *Router) BlobQuarantined(digest string) {
+	r.events.Publish(events.Event{
+		Type:   events.TypeBlobQuarantine,
+		Digest: digest,
+		Actor:  "scrubber",
+	})
+}
+
+// activityIdentity resolves who is watching the activity stream. ok is false
+// when the request must be rejected.
+func (r *Router) activityIdentity(w http.ResponseWriter, req *http.Request) (*auth.Identity, bool) {
+	if !r.cfg().Auth.Enabled {
+		return nil, true
+	}
+
+	identity := r.sessionIdentity(req)
+	if identity == nil {
+		var err error
+		identity, err = r.authenticate(req)
+		if err != nil {
+			var locked *lockedOutError
+			if errors.As(err, &locked) {
+				r.writeTooManyRequests(w, locked.retryAfter, "Too many failed authentication attempts")
+				return nil, false
+			}
+			r.writeAuthChallenge(w)
+			return nil, false
+		}
+	}
+
+	if identity == nil && r.cfg().Auth.RequirePullAuth {
+		r.writeAuthChallenge(w)
+		return nil, false
+	}
+	return identity, true
+}
+
+// visibleActivity filters events to the repositories identity may pull and
+// that match pattern
+func visibleActivity(identity *auth.Identity, pattern string, list []events.Event) []events.Event {
+	var visible []events.Event
+	for _, event := range list {
+		if canSeeActivity(identity, pattern, event) {
+			visible = append(visible, event)
+		}
+	}
+	return visible
+}
+
+// canSeeActivity reports whether event should be shown to identity
+func canSeeActivity(identity *auth.Identity, pattern string, event events.Event) bool {
+	if identity != nil && !identity.Can(event.Repository, auth.ActionPull) {
+		return false
+	}
+	if pattern != "" {
+		if matched, _ := path.Match(pattern, event.Repository); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// recentActivity returns remembered events the web user may see, newest first
+func (r *Router) recentActivity(req *http.Request) []events.Event {
+	identity := r.sessionIdentity(req)
+	if r.cfg().Auth.Enabled && identity == nil && r.cfg().Auth.RequirePullAuth {
+		return nil
+	}
+
+	recent := visibleActivity(identity, "", r.events.Recent(0))
+	for i, j := 0, len(recent)-1; i < j; i, j = i+1, j-1 {
+		recent[i], recent[j] = recent[j], recent[i]
+	}
+	return recent
+}
+
+// handleEvents streams registry activity as server-sent events. Clients
+// reconnecting with Last-Event-ID receive the events they missed, as far as
+// they are still remembered.
+func (r *Router) handleEvents(w http.ResponseWriter, req *http.Request) {
+	identity, ok := r.activityIdentity(w, req)
+	if !ok {
+		return
+	}
+
+	pattern := req.URL.Query().Get("repository")
+	if _, err := path.Match(pattern, ""); err != nil {
+		r.writeError(w, http.StatusBadRequest, ErrorCodeNameInvalid, "Invalid repository pattern")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Streaming unsupported")
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		logrus.Debugf("Failed to clear write deadline for event stream: %v", err)
+	}
+
+	subscription := r.events.Subscribe()
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	lastID := req.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = req.URL.Query().Get("last_event_id")
+	}
+	if lastID != "" {
+		afterID, _ := strconv.ParseUint(lastID, 10, 64)
+		for _, event := range visibleActivity(identity, pattern, r.events.Recent(afterID)) {
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		}
+	}
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, open := <-subscription.C:
+			if !open {
+				return
+			}
+			if !canSeeActivity(identity, pattern, event) {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes one server-sent event
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
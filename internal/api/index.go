@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/index"
+	"docker-registry-manager/internal/storage"
+)
+
+// handleIndexRebuild rebuilds the metadata index from storage and returns
+// the new totals
+func (r *Router) handleIndexRebuild(w http.ResponseWriter, req *http.Request) {
+	if err := r.index.Rebuild(req.Context()); err != nil {
+		logrus.Errorf("Failed to rebuild metadata index: %v", err)
+		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to rebuild index")
+		return
+	}
+	stats, err := r.index.Stats(req.Context())
+	if err != nil {
+		logrus.Errorf("Failed to read metadata index: %v", err)
+		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to read index")
+		return
+	}
+	logrus.Infof("Metadata index rebuilt: %d repositories, %d tags", stats.Repositories, stats.Tags)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// repositoryInfos lists the repositories with their tag counts, from the
+// index if there is one and otherwise by walking the storage
+func (r *Router) repositoryInfos(ctx context.Context) ([]storage.RepositoryInfo, error) {
+	if r.index != nil {
+		if infos, err := r.index.Repositories(ctx); !errors.Is(err, index.ErrStale) {
+			return infos, err
+		}
+	}
+
+	repositories, err := r.storage.ListRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []storage.RepositoryInfo
+	for _, repo := range repositories {
+		tags, err := r.storage.ListTags(ctx, repo)
+		if err != nil {
+			logrus.Errorf("Failed to list tags for %s: %v", repo, err)
+			continue
+		}
+		infos = append(infos, storage.RepositoryInfo{Name: repo, TagCount: len(tags)})
+	}
+	return infos, nil
+}
+
+// tagInfos lists the tags of a repository with their digests
+func (r *Router) tagInfos(ctx context.Context, repository string) ([]storage.TagInfo, error) {
+	if r.index != nil {
+		if infos, err := r.index.Tags(ctx, repository); !errors.Is(err, index.ErrStale) {
+			return infos, err
+		}
+	}
+
+	tags, err := r.storage.ListTags(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []storage.TagInfo
+	for _, tag := range tags {
+		digest, err := r.storage.GetTagDigest(ctx, repository, tag)
+		if err != nil {
+			logrus.Errorf("Failed to get digest for %s:%s: %v", repository, tag, err)
+			continue
+		}
+		infos = append(infos, storage.TagInfo{Name: tag, Digest: digest})
+	}
+	return infos, nil
+}
+
+// registryStats returns the overall statistics. Without the index they are
+// counted from infos, which are listed first if the caller has not already.
+func (r *Router) registryStats(ctx context.Context, infos []storage.RepositoryInfo) (*StatsData, error) {
+	if r.index != nil {
+		stats, err := r.index.Stats(ctx)
+		if err == nil {
+			return newStatsData(stats.Repositories, stats.Tags, stats.Size), nil
+		}
+		if !errors.Is(err, index.ErrStale) {
+			return nil, err
+		}
+	}
+
+	if infos == nil {
+		var err error
+		if infos, err = r.repositoryInfos(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var tags int
+	for _, info := range infos {
+		tags += info.TagCount
+	}
+
+	totalSize, err := r.storage.GetTotalStorageSize(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to get total storage size: %v", err)
+	}
+	return newStatsData(len(infos), tags, totalSize), nil
+}
+
+// newStatsData formats statistics for display
+func newStatsData(repositories, tags int, totalSize int64) *StatsData {
+	return &StatsData{
+		RepositoryCount: repositories,
+		TotalTags:       tags,
+		TotalSize:       storage.FormatMB(totalSize),
+	}
+}
+
+// repositoryData converts repository listings for display
+func repositoryData(infos []storage.RepositoryInfo) []RepositoryData {
+	var repoData []RepositoryData
+	for _, info := range infos {
+		repoData = append(repoData, RepositoryData{
+			Name:     info.Name,
+			TagCount: info.TagCount,
+		})
+	}
+	return repoData
+}
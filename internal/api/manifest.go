@@ -1,15 +1,18 @@
 package api
 
 import (
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"os"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/events"
+	"docker-registry-manager/internal/notifications"
+	"docker-registry-manager/internal/storage"
 )
 
 // Manifest represents a Docker manifest
@@ -45,7 +48,7 @@ func (r *Router) handleManifestGet(w http.ResponseWriter, req *http.Request) {
 		digest = reference
 	} else if r.isValidTag(reference) {
 		// Look up digest by tag
-		tagDigest, err := r.storage.GetTagDigest(name, reference)
+		tagDigest, err := r.storage.GetTagDigest(req.Context(), name, reference)
 		if err != nil {
 			logrus.Errorf("Failed to get digest for tag %s/%s: %v", name, reference, err)
 			r.writeError(w, http.StatusNotFound, ErrorCodeManifestUnknown, "Manifest not found")
@@ -58,7 +61,7 @@ func (r *Router) handleManifestGet(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Get manifest data
-	manifestData, mediaType, err := r.storage.GetManifest(name, digest)
+	manifestData, mediaType, err := r.storage.GetManifest(req.Context(), name, digest)
 	if err != nil {
 		logrus.Errorf("Failed to get manifest %s/%s: %v", name, digest, err)
 		r.writeError(w, http.StatusNotFound, ErrorCodeManifestUnknown, "Manifest not found")
@@ -71,6 +74,18 @@ func (r *Router) handleManifestGet(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Length", strconv.Itoa(len(manifestData)))
 	w.WriteHeader(http.StatusOK)
 	w.Write(manifestData)
+
+	target := notifications.Target{
+		MediaType:  mediaType,
+		Size:       int64(len(manifestData)),
+		Digest:     digest,
+		Repository: name,
+		URL:        eventURL(req, name, "manifests", digest),
+	}
+	if digest != reference {
+		target.Tag = reference
+	}
+	r.notify(req, notifications.ActionPull, target)
 }
 
 // handleManifestPut handles PUT requests for manifests
@@ -85,7 +100,7 @@ func (r *Router) handleManifestPut(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Read manifest data
-	manifestData, err := io.ReadAll(req.Body)
+	manifestData, err := readBody(req)
 	if err != nil {
 		logrus.Errorf("Failed to read manifest data: %v", err)
 		r.writeError(w, http.StatusBadRequest, ErrorCodeManifestInvalid, "Failed to read manifest")
@@ -101,8 +116,7 @@ func (r *Router) handleManifestPut(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Calculate digest
-	hash := sha256.Sum256(manifestData)
-	digest := fmt.Sprintf("sha256:%x", hash)
+	digest := sha256Digest(req.Context(), manifestData)
 
 	// Get content type
 	mediaType := req.Header.Get("Content-Type")
@@ -110,26 +124,83 @@ func (r *Router) handleManifestPut(w http.ResponseWriter, req *http.Request) {
 		mediaType = "application/vnd.docker.distribution.manifest.v2+json"
 	}
 
+	// The referenced blobs must be pushed first, so quotas count them.
+	// Foreign layers are fetched from their URLs and never pushed.
+	blobs, err := storage.PushedBlobs(manifestData)
+	if err != nil {
+		r.writeError(w, http.StatusBadRequest, ErrorCodeManifestInvalid, "Invalid manifest: "+err.Error())
+		return
+	}
+	for _, blob := range blobs {
+		if _, err := r.storage.GetBlobSize(req.Context(), blob); err != nil {
+			if os.IsNotExist(err) {
+				r.writeError(w, http.StatusBadRequest, ErrorCodeManifestBlobUnknown, "Blob unknown to registry: "+blob)
+			} else {
+				logrus.Errorf("Failed to check blob %s of manifest %s/%s: %v", blob, name, digest, err)
+				r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to check manifest blobs")
+			}
+			return
+		}
+	}
+
+	// Refuse pushes that would exceed a quota
+	var tag string
+	if !r.isValidDigest(reference) && r.isValidTag(reference) {
+		tag = reference
+	}
+	if !r.quotaAllows(w, name, r.quotas.CheckManifest(req.Context(), r.cfg().Quotas, name, tag, manifestData)) {
+		return
+	}
+
 	// Store manifest
-	if err := r.storage.PutManifest(name, digest, manifestData, mediaType); err != nil {
+	if err := r.storage.PutManifest(req.Context(), name, digest, manifestData, mediaType); err != nil {
 		logrus.Errorf("Failed to store manifest %s/%s: %v", name, digest, err)
 		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to store manifest")
 		return
 	}
 
+	target := notifications.Target{
+		MediaType:  mediaType,
+		Size:       int64(len(manifestData)),
+		Digest:     digest,
+		Repository: name,
+		URL:        eventURL(req, name, "manifests", digest),
+	}
+
 	// If reference is a tag, create tag mapping
+	var previousDigest string
 	if !r.isValidDigest(reference) && r.isValidTag(reference) {
-		if err := r.storage.PutTag(name, reference, digest); err != nil {
+		previousDigest, _ = r.storage.GetTagDigest(req.Context(), name, reference)
+		if err := r.storage.PutTag(req.Context(), name, reference, digest); err != nil {
 			logrus.Errorf("Failed to create tag %s/%s -> %s: %v", name, reference, digest, err)
 			r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to create tag")
 			return
 		}
+		target.Tag = reference
 	}
 
 	// Set response headers
 	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, digest))
 	w.Header().Set("Docker-Content-Digest", digest)
 	w.WriteHeader(http.StatusCreated)
+
+	r.notify(req, notifications.ActionPush, target)
+	r.publishActivity(req, events.Event{
+		Type:       events.TypeManifestPush,
+		Repository: name,
+		Tag:        target.Tag,
+		Digest:     digest,
+		Size:       int64(len(manifestData)),
+	})
+	if previousDigest != "" && previousDigest != digest {
+		r.publishActivity(req, events.Event{
+			Type:           events.TypeTagUpdate,
+			Repository:     name,
+			Tag:            reference,
+			Digest:         digest,
+			PreviousDigest: previousDigest,
+		})
+	}
 }
 
 // handleManifestHead handles HEAD requests for manifests
@@ -149,7 +220,7 @@ func (r *Router) handleManifestHead(w http.ResponseWriter, req *http.Request) {
 		digest = reference
 	} else if r.isValidTag(reference) {
 		// Look up digest by tag
-		tagDigest, err := r.storage.GetTagDigest(name, reference)
+		tagDigest, err := r.storage.GetTagDigest(req.Context(), name, reference)
 		if err != nil {
 			logrus.Errorf("Failed to get digest for tag %s/%s: %v", name, reference, err)
 			w.WriteHeader(http.StatusNotFound)
@@ -162,7 +233,7 @@ func (r *Router) handleManifestHead(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Check if manifest exists
-	size, mediaType, err := r.storage.GetManifestInfo(name, digest)
+	size, mediaType, err := r.storage.GetManifestInfo(req.Context(), name, digest)
 	if err != nil {
 		logrus.Errorf("Failed to get manifest info %s/%s: %v", name, digest, err)
 		w.WriteHeader(http.StatusNotFound)
@@ -187,17 +258,21 @@ func (r *Router) handleManifestDelete(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	target := notifications.Target{Repository: name}
+
 	// Check if reference is a tag or digest
 	if r.isValidDigest(reference) {
+		target.Digest = reference
 		// Delete manifest by digest
-		if err := r.storage.DeleteManifest(name, reference); err != nil {
+		if err := r.storage.DeleteManifest(req.Context(), name, reference); err != nil {
 			logrus.Errorf("Failed to delete manifest %s/%s: %v", name, reference, err)
 			r.writeError(w, http.StatusNotFound, ErrorCodeManifestUnknown, "Manifest not found")
 			return
 		}
 	} else if r.isValidTag(reference) {
+		target.Tag = reference
 		// Delete tag
-		if err := r.storage.DeleteTag(name, reference); err != nil {
+		if err := r.storage.DeleteTag(req.Context(), name, reference); err != nil {
 			logrus.Errorf("Failed to delete tag %s/%s: %v", name, reference, err)
 			r.writeError(w, http.StatusNotFound, ErrorCodeManifestUnknown, "Tag not found")
 			return
@@ -208,5 +283,18 @@ func (r *Router) handleManifestDelete(w http.ResponseWriter, req *http.Request)
 	}
 
 	w.WriteHeader(http.StatusAccepted)
+
+	r.notify(req, notifications.ActionDelete, target)
+
+	activity := events.Event{
+		Type:       events.TypeManifestDelete,
+		Repository: name,
+		Tag:        target.Tag,
+		Digest:     target.Digest,
+	}
+	if target.Tag != "" {
+		activity.Type = events.TypeTagDelete
+	}
+	r.publishActivity(req, activity)
 }
 
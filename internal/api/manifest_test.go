@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"docker-registry-manager/internal/config"
+	"docker-registry-manager/internal/storage"
+)
+
+// digestOf returns the sha256 digest of data
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// do sends a request to router and returns the recorded response
+func do(router http.Handler, method, target string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// errorCode returns the code of the first registry error in rec
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var response ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Errors) == 0 {
+		t.Fatalf("response %d %q is not a registry error", rec.Code, rec.Body.String())
+	}
+	return response.Errors[0].Code
+}
+
+func TestManifestPutRequiresBlobs(t *testing.T) {
+	cfg := &config.Config{Quotas: []config.QuotaConfig{{Namespace: "team", MaxSizeMB: 1}}}
+	store := storage.NewMemoryStorage()
+	router := NewRouter(cfg, store)
+
+	layer1 := bytes.Repeat([]byte("1"), 700*1024)
+	if rec := do(router, http.MethodPost, "/v2/team/app/blobs/uploads/?digest="+digestOf(layer1), layer1); rec.Code != http.StatusCreated {
+		t.Fatalf("first layer: %d %s", rec.Code, rec.Body)
+	}
+	image1 := []byte(fmt.Sprintf(`{"schemaVersion":2,"layers":[{"digest":%q}]}`, digestOf(layer1)))
+	if rec := do(router, http.MethodPut, "/v2/team/app/manifests/v1", image1); rec.Code != http.StatusCreated {
+		t.Fatalf("first manifest: %d %s", rec.Code, rec.Body)
+	}
+
+	// The second layer does not fit
+	layer2 := bytes.Repeat([]byte("2"), 700*1024)
+	rec := do(router, http.MethodPost, "/v2/team/app/blobs/uploads/?digest="+digestOf(layer2), layer2)
+	if rec.Code != http.StatusForbidden || errorCode(t, rec) != ErrorCodeDenied {
+		t.Fatalf("second layer: got %d %s, want 403 DENIED", rec.Code, rec.Body)
+	}
+
+	// Pushing a manifest for it first must not get it counted as held
+	image2 := []byte(fmt.Sprintf(`{"schemaVersion":2,"layers":[{"digest":%q}]}`, digestOf(layer2)))
+	rec = do(router, http.MethodPut, "/v2/team/app/manifests/"+digestOf(image2), image2)
+	if rec.Code != http.StatusBadRequest || errorCode(t, rec) != ErrorCodeManifestBlobUnknown {
+		t.Fatalf("manifest before its blob: got %d %s, want 400 MANIFEST_BLOB_UNKNOWN", rec.Code, rec.Body)
+	}
+	rec = do(router, http.MethodPost, "/v2/team/app/blobs/uploads/?digest="+digestOf(layer2), layer2)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("second layer after the manifest: got %d %s, want 403", rec.Code, rec.Body)
+	}
+}
+
+func TestManifestPutForeignLayers(t *testing.T) {
+	router := NewRouter(&config.Config{}, storage.NewMemoryStorage())
+
+	imageConfig := []byte(`{"os":"windows"}`)
+	if rec := do(router, http.MethodPost, "/v2/win/app/blobs/uploads/?digest="+digestOf(imageConfig), imageConfig); rec.Code != http.StatusCreated {
+		t.Fatalf("config: %d %s", rec.Code, rec.Body)
+	}
+
+	// Base layers fetched from their URLs or marked non-distributable are
+	// never pushed
+	image := []byte(fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":%q},"layers":[`+
+		`{"mediaType":"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip","digest":%q,"urls":["https://mcr.microsoft.com/layer"]},`+
+		`{"mediaType":"application/vnd.oci.image.layer.nondistributable.v1.tar+gzip","digest":%q},`+
+		`{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":%q,"urls":["https://example.com/layer"]}]}`,
+		digestOf(imageConfig), digestOf([]byte("base")), digestOf([]byte("patch")), digestOf([]byte("other"))))
+	if rec := do(router, http.MethodPut, "/v2/win/app/manifests/ltsc2022", image); rec.Code != http.StatusCreated {
+		t.Fatalf("manifest with foreign layers: got %d %s, want 201", rec.Code, rec.Body)
+	}
+
+	// A pushed layer is still required
+	image = []byte(fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":%q},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":%q}]}`,
+		digestOf(imageConfig), digestOf([]byte("missing"))))
+	rec := do(router, http.MethodPut, "/v2/win/app/manifests/missing", image)
+	if rec.Code != http.StatusBadRequest || errorCode(t, rec) != ErrorCodeManifestBlobUnknown {
+		t.Fatalf("missing layer: got %d %s, want 400 MANIFEST_BLOB_UNKNOWN", rec.Code, rec.Body)
+	}
+}
+
+func TestManifestPutUnreadableReferences(t *testing.T) {
+	router := NewRouter(&config.Config{}, storage.NewMemoryStorage())
+
+	rec := do(router, http.MethodPut, "/v2/team/app/manifests/v1", []byte(`{"schemaVersion":1,"fsLayers":"sha256:abc"}`))
+	if rec.Code != http.StatusBadRequest || errorCode(t, rec) != ErrorCodeManifestInvalid {
+		t.Fatalf("got %d %s, want 400 MANIFEST_INVALID", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// routeVarPattern matches the regular expression part of a mux route variable
+var routeVarPattern = regexp.MustCompile(`\{(\w+):[^}]*\}`)
+
+// metricsMiddleware records request count and latency by route template
+func (r *Router) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		r.metrics.RequestStarted()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		r.metrics.RequestFinished(req.Method, routeLabel(req), strconv.Itoa(status), time.Since(start))
+	})
+}
+
+// routeLabel returns the matched route template with variable patterns
+// removed, e.g. /v2/{name}/manifests/{reference}, so that metrics are not
+// labelled with unbounded raw paths
+func routeLabel(req *http.Request) string {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return "unmatched"
+	}
+
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "unmatched"
+	}
+	return routeVarPattern.ReplaceAllString(template, "{$1}")
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/notifications"
+)
+
+// notify publishes a registry event describing req to the configured
+// webhook endpoints
+func (r *Router) notify(req *http.Request, action string, target notifications.Target) {
+	if r.notifier == nil {
+		return
+	}
+
+	if target.Length == 0 {
+		target.Length = target.Size
+	}
+
+	r.notifier.Publish(notifications.Event{
+		Action: action,
+		Target: target,
+		Request: notifications.Request{
+			ID:        requestID(req),
+			Addr:      r.clientIP(req),
+			Host:      req.Host,
+			Method:    req.Method,
+			UserAgent: req.UserAgent(),
+		},
+		Actor: notifications.Actor{Name: r.auditActor(req)},
+	})
+}
+
+// eventURL returns the registry URL of a manifest or blob for event targets
+func eventURL(req *http.Request, name, kind, digest string) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, req.Host, name, kind, digest)
+}
+
+// handleNotificationStatus returns the delivery state of each webhook endpoint
+func (r *Router) handleNotificationStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(r.notifier.Status()); err != nil {
+		logrus.Errorf("Failed to encode notification status: %v", err)
+	}
+}
+
+// handleWebNotifications renders the webhook delivery status page
+func (r *Router) handleWebNotifications(w http.ResponseWriter, req *http.Request) {
+	identity := r.sessionIdentity(req)
+	if identity == nil {
+		http.Redirect(w, req, "/login", http.StatusFound)
+		return
+	}
+	if !identity.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	data := r.newWebData(req)
+	data.Notifications = r.notifier.Status()
+
+	r.renderTemplate(w, "notifications.html", data)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/audit"
+	"docker-registry-manager/internal/auth"
+)
+
+// handleOIDCLogin redirects the browser to the identity provider
+func (r *Router) handleOIDCLogin(w http.ResponseWriter, req *http.Request) {
+	url, err := r.oidc.AuthCodeURL(req.Context())
+	if err != nil {
+		logrus.Errorf("Failed to start OIDC login: %v", err)
+		http.Error(w, "Single sign-on is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, req, url, http.StatusFound)
+}
+
+// handleOIDCCallback finishes the authorization code flow and starts a session
+func (r *Router) handleOIDCCallback(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	if errCode := query.Get("error"); errCode != "" {
+		logrus.Warnf("OIDC provider returned error %s: %s", errCode, query.Get("error_description"))
+		http.Redirect(w, req, "/login", http.StatusFound)
+		return
+	}
+
+	identity, err := r.oidc.Exchange(req.Context(), query.Get("state"), query.Get("code"))
+	if err != nil {
+		r.recordAudit(req, audit.Event{
+			Action:  audit.ActionLogin,
+			Outcome: audit.OutcomeFailure,
+			Detail:  "oidc: " + err.Error(),
+		})
+
+		switch {
+		case errors.Is(err, auth.ErrUnknownLoginState):
+			http.Redirect(w, req, "/login", http.StatusFound)
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			http.Error(w, "Your account has no access to this registry", http.StatusForbidden)
+		default:
+			logrus.Errorf("OIDC login failed: %v", err)
+			http.Error(w, "Single sign-on failed", http.StatusBadGateway)
+		}
+		return
+	}
+
+	if err := r.startSession(w, req, identity); err != nil {
+		logrus.Errorf("Failed to create session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	r.recordAudit(req, audit.Event{
+		Actor:   identity.Username,
+		Action:  audit.ActionLogin,
+		Outcome: audit.OutcomeSuccess,
+		Detail:  "oidc",
+	})
+	logrus.Infof("User %s signed in via OIDC as %s", identity.Username, identity.Role)
+	http.Redirect(w, req, "/", http.StatusFound)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"docker-registry-manager/internal/auth"
+	"docker-registry-manager/internal/auth/oidctest"
+	"docker-registry-manager/internal/config"
+	"docker-registry-manager/internal/storage"
+)
+
+// newOIDCRouter returns a router signing web users in through provider
+func newOIDCRouter(t *testing.T, provider *oidctest.Provider) *Router {
+	t.Helper()
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			Enabled: true,
+			OIDC: config.OIDCConfig{
+				Enabled:     true,
+				Issuer:      provider.Issuer,
+				ClientID:    provider.ClientID,
+				RedirectURL: "http://registry.example.com/auth/oidc/callback",
+				RoleMapping: map[string]string{"platform": "admin"},
+				DefaultRole: "reader",
+			},
+		},
+		Web: config.WebConfig{Enabled: true},
+	}
+	oidc, err := auth.NewOIDCAuthenticator(cfg.Auth.OIDC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRouter(cfg, storage.NewMemoryStorage(), WithOIDC(oidc), WithTokenStore(tokens))
+}
+
+// sessionCookie returns the session cookie set by rec, if any
+func sessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionCookieName && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// oidcLogin runs the login redirect and the provider sign-in, returning the
+// callback request path with its query
+func oidcLogin(t *testing.T, router http.Handler, provider *oidctest.Provider) string {
+	t.Helper()
+	rec := do(router, http.MethodGet, "/auth/oidc/login", nil)
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), provider.Issuer+"/authorize?") {
+		t.Fatalf("login: got %d to %q, want a redirect to the provider", rec.Code, rec.Header().Get("Location"))
+	}
+	callback := provider.Authorize(t, rec.Header().Get("Location"))
+	if callback.Path != "/auth/oidc/callback" {
+		t.Fatalf("provider redirected to %s", callback)
+	}
+	return callback.RequestURI()
+}
+
+func TestOIDCLoginStartsSession(t *testing.T) {
+	provider := oidctest.NewProvider(t, "registry")
+	provider.Claims["preferred_username"] = "alice"
+	provider.Claims["groups"] = []string{"platform"}
+	router := newOIDCRouter(t, provider)
+
+	// Signed out, the admin API asks for credentials
+	if rec := do(router, http.MethodGet, "/api/admin/tokens", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("admin API without a session: got %d, want 401", rec.Code)
+	}
+
+	rec := do(router, http.MethodGet, oidcLogin(t, router, provider), nil)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Fatalf("callback: got %d to %q, want a redirect to /", rec.Code, rec.Header().Get("Location"))
+	}
+	cookie := sessionCookie(rec)
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("callback did not set an HttpOnly session cookie: %v", rec.Result().Cookies())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/tokens", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin API with the session: got %d %s, want 200", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackRejected(t *testing.T) {
+	provider := oidctest.NewProvider(t, "registry")
+	router := newOIDCRouter(t, provider)
+
+	// A callback for a login this registry did not start goes back to the
+	// login page without a session
+	callback := oidcLogin(t, router, provider)
+	forged := strings.Replace(callback, "state=", "state=forged", 1)
+	rec := do(router, http.MethodGet, forged, nil)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/login" || sessionCookie(rec) != nil {
+		t.Fatalf("forged state: got %d to %q, want a redirect to /login without a session", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// An ID token minted for another login is refused
+	provider.Nonce = "other-login"
+	rec = do(router, http.MethodGet, oidcLogin(t, router, provider), nil)
+	if rec.Code != http.StatusBadGateway || sessionCookie(rec) != nil {
+		t.Fatalf("nonce mismatch: got %d, want 502 without a session", rec.Code)
+	}
+}
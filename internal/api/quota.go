@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/quota"
+	"docker-registry-manager/internal/storage"
+)
+
+// QuotaData represents a quota and its usage for web display
+type QuotaData struct {
+	Scope    string
+	Size     string
+	Tags     string
+	Exceeded bool
+}
+
+// quotaAllows reports whether a quota check passed. Otherwise it answers
+// DENIED if the push would exceed a quota, or an internal error if usage
+// could not be measured.
+func (r *Router) quotaAllows(w http.ResponseWriter, name string, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		logrus.Warnf("Refused push to %s: %v", name, err)
+		r.writeError(w, http.StatusForbidden, ErrorCodeDenied, err.Error())
+		return false
+	}
+	logrus.Errorf("Failed to check quota for %s: %v", name, err)
+	r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to check quota")
+	return false
+}
+
+// handleGetRepositoryQuota returns the quotas applying to a repository with
+// their usage as JSON
+func (r *Router) handleGetRepositoryQuota(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+
+	statuses, err := r.quotas.Status(req.Context(), r.cfg().Quotas, name)
+	if err != nil {
+		logrus.Errorf("Failed to get quota usage for %s: %v", name, err)
+		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to get quota usage")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// quotaData converts quota usage for display, e.g. "12.00 MB / 100.00 MB"
+func quotaData(statuses []quota.Status) []QuotaData {
+	var data []QuotaData
+	for _, status := range statuses {
+		item := QuotaData{
+			Scope:    status.Scope,
+			Size:     storage.FormatMB(status.Size),
+			Tags:     fmt.Sprint(status.Tags),
+			Exceeded: status.Exceeded(),
+		}
+		if status.MaxSize > 0 {
+			item.Size += " / " + storage.FormatMB(status.MaxSize)
+		}
+		if status.MaxTags > 0 {
+			item.Tags += fmt.Sprintf(" / %d", status.MaxTags)
+		}
+		data = append(data, item)
+	}
+	return data
+}
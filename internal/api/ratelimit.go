@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/auth"
+	"docker-registry-manager/internal/metrics"
+)
+
+// lockedOutError is returned while a client IP or username is locked out
+type lockedOutError struct {
+	retryAfter time.Duration
+}
+
+func (e *lockedOutError) Error() string {
+	return fmt.Sprintf("too many failed attempts, retry in %s", e.retryAfter.Round(time.Second))
+}
+
+// checkCredentials authenticates username/password and counts failures
+func (r *Router) checkCredentials(req *http.Request, username, password string) (*auth.Identity, error) {
+	identity, err := r.checkCredentialsWithLockout(req, username, password)
+	if err != nil {
+		var locked *lockedOutError
+		switch {
+		case errors.As(err, &locked):
+			r.metrics.AuthFailure(metrics.AuthLockedOut)
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			r.metrics.AuthFailure(metrics.AuthInvalidCredentials)
+		default:
+			r.metrics.AuthFailure(metrics.AuthBackendError)
+		}
+	}
+	return identity, err
+}
+
+// checkCredentialsWithLockout authenticates username/password while
+// enforcing the failure lockout for both the client IP and the username
+func (r *Router) checkCredentialsWithLockout(req *http.Request, username, password string) (*auth.Identity, error) {
+	state := r.state.Load()
+	if state.lockout == nil {
+		return state.authenticator.Authenticate(username, password)
+	}
+
+	ipKey := "ip:" + r.clientIP(req)
+	userKey := "user:" + username
+
+	if wait := state.lockout.Check(ipKey); wait > 0 {
+		return nil, &lockedOutError{retryAfter: wait}
+	}
+	if wait := state.lockout.Check(userKey); wait > 0 {
+		return nil, &lockedOutError{retryAfter: wait}
+	}
+
+	identity, err := state.authenticator.Authenticate(username, password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			ipWait := state.lockout.Fail(ipKey)
+			userWait := state.lockout.Fail(userKey)
+			if ipWait > 0 || userWait > 0 {
+				logrus.Warnf("Locking out login attempts for user %q from %s after repeated failures", username, r.clientIP(req))
+			}
+		}
+		return nil, err
+	}
+
+	// The IP count is left to expire: clearing it would let anyone with one
+	// valid account reset it between guesses at other accounts
+	state.lockout.Succeed(userKey)
+	return identity, nil
+}
+
+// rateLimitMiddleware applies the per-client token bucket to API requests
+func (r *Router) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		state := r.state.Load()
+		if state.limiter == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		key := r.clientIP(req)
+		if state.config.Security.RateLimit.PerRepository {
+			if name := mux.Vars(req)["name"]; name != "" {
+				key += "|" + name
+			}
+		}
+
+		if ok, wait := state.limiter.Allow(key); !ok {
+			r.writeTooManyRequests(w, wait, "Rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// writeTooManyRequests writes a 429 response with a Retry-After header
+func (r *Router) writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	r.writeError(w, http.StatusTooManyRequests, ErrorCodeTooManyRequests, message)
+}
+
+// defaultTrustedProxies are trusted when proxy headers are enabled without
+// listing the proxies: loopback and private addresses
+var defaultTrustedProxies = []string{
+	"127.0.0.0/8", "::1/128",
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+}
+
+// parseProxies parses the trusted proxy addresses and ranges, which config
+// validation has already checked
+func parseProxies(proxies []string) []*net.IPNet {
+	if len(proxies) == 0 {
+		proxies = defaultTrustedProxies
+	}
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		if _, n, err := net.ParseCIDR(proxy); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// trustedProxy reports whether addr is a proxy whose headers are honoured
+func (s *routerState) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range s.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client. Proxy headers are honoured
+// only when the peer is a trusted proxy, and X-Forwarded-For is read from
+// the right: entries left of the first untrusted address were supplied by
+// the client and could be anything.
+func (r *Router) clientIP(req *http.Request) string {
+	peer, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		peer = req.RemoteAddr
+	}
+
+	state := r.state.Load()
+	if !state.trustedProxy(peer) {
+		return peer
+	}
+	if forwarded := req.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop != "" && (i == 0 || !state.trustedProxy(hop)) {
+				return hop
+			}
+		}
+	}
+	if realIP := req.Header.Get("X-Real-IP"); realIP != "" {
+		return strings.TrimSpace(realIP)
+	}
+	return peer
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"docker-registry-manager/internal/auth"
+	"docker-registry-manager/internal/config"
+	"docker-registry-manager/internal/storage"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		trust     bool
+		proxies   []string
+		peer      string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"headers not trusted", false, nil, "203.0.113.7:5000", []string{"198.51.100.1"}, "", "203.0.113.7"},
+		{"peer is not a proxy", true, nil, "203.0.113.7:5000", []string{"198.51.100.1"}, "", "203.0.113.7"},
+		{"private proxy", true, nil, "10.0.0.1:5000", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"forged entry ignored", true, nil, "10.0.0.1:5000", []string{"198.51.100.1, 203.0.113.7"}, "", "203.0.113.7"},
+		{"chain of proxies", true, nil, "127.0.0.1:5000", []string{"198.51.100.1, 203.0.113.7, 10.0.0.2"}, "", "203.0.113.7"},
+		{"repeated headers", true, nil, "10.0.0.1:5000", []string{"198.51.100.1", "203.0.113.7"}, "", "203.0.113.7"},
+		{"only proxies", true, nil, "10.0.0.1:5000", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"real IP header", true, nil, "10.0.0.1:5000", nil, "203.0.113.7", "203.0.113.7"},
+		{"listed proxy", true, []string{"192.0.2.10"}, "192.0.2.10:5000", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"unlisted private peer", true, []string{"192.0.2.0/24"}, "10.0.0.1:5000", []string{"203.0.113.7"}, "", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Security: config.SecurityConfig{TrustProxyHeaders: tt.trust, TrustedProxies: tt.proxies}}
+			router := NewRouter(cfg, storage.NewMemoryStorage())
+
+			req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+			req.RemoteAddr = tt.peer
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := router.clientIP(req); got != tt.want {
+				t.Fatalf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginSuccessKeepsIPFailures(t *testing.T) {
+	cfg := &config.Config{
+		Auth: config.AuthConfig{Enabled: true, Username: "admin", Password: "admin-secret"},
+		Security: config.SecurityConfig{Login: config.LoginProtectionConfig{
+			MaxFailures: 3,
+			Lockout:     time.Minute,
+		}},
+	}
+	router := NewRouter(cfg, storage.NewMemoryStorage())
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+
+	login := func(username, password string) error {
+		_, err := router.checkCredentials(req, username, password)
+		return err
+	}
+
+	// Guesses at other accounts, interleaved with logins to the attacker's own
+	for _, username := range []string{"alice", "bob"} {
+		if err := login(username, "guess"); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("guess at %s: got %v", username, err)
+		}
+		if err := login("admin", "admin-secret"); err != nil {
+			t.Fatalf("valid login: %v", err)
+		}
+	}
+	if err := login("carol", "guess"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Fatalf("guess at carol: got %v", err)
+	}
+
+	var locked *lockedOutError
+	if err := login("dave", "guess"); !errors.As(err, &locked) {
+		t.Fatalf("fourth guess from the same IP: got %v, want a lockout", err)
+	}
+}
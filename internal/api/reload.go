@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/handlers"
+
+	"docker-registry-manager/internal/auth"
+	"docker-registry-manager/internal/config"
+	"docker-registry-manager/internal/ratelimit"
+)
+
+// routerState is everything the router derives from the configuration. It
+// is replaced as a whole by Reload, so a request sees either the old or the
+// new settings, never a mix.
+type routerState struct {
+	config        *config.Config
+	authenticator auth.Authenticator
+	lockout       *ratelimit.Lockout
+	limiter       *ratelimit.Limiter
+	// trustedProxies are the peers whose forwarding headers are honoured
+	trustedProxies []*net.IPNet
+	// handler is the mux router wrapped in CORS handling if enabled
+	handler http.Handler
+}
+
+// ServeHTTP implements http.Handler
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.state.Load().handler.ServeHTTP(w, req)
+}
+
+// Reload applies a new configuration to a running router. Callers should
+// only change settings that can take effect at runtime (see
+// config.ApplyReloadable); routes, storage and listeners are not rebuilt.
+func (r *Router) Reload(cfg *config.Config) {
+	r.state.Store(r.newState(cfg, r.state.Load()))
+}
+
+// cfg returns the current configuration
+func (r *Router) cfg() *config.Config {
+	return r.state.Load().config
+}
+
+// newState derives the router state from cfg. Lockout and rate limit
+// buckets are kept from prev when their settings are unchanged, so a reload
+// does not forgive clients that are currently locked out.
+func (r *Router) newState(cfg *config.Config, prev *routerState) *routerState {
+	s := &routerState{config: cfg}
+
+	chain := auth.Chain{&auth.StaticAuthenticator{
+		Username: cfg.Auth.Username,
+		Password: cfg.Auth.Password,
+	}}
+	if r.tokens != nil {
+		chain = append(chain, r.tokens)
+	}
+	chain = append(chain, r.backends...)
+	s.authenticator = chain
+
+	if login := cfg.GetLoginProtection(); login.MaxFailures > 0 {
+		if prev != nil && reflect.DeepEqual(prev.config.GetLoginProtection(), login) {
+			s.lockout = prev.lockout
+		} else {
+			s.lockout = ratelimit.NewLockout(login.MaxFailures, login.Lockout, login.MaxLockout, login.ResetAfter)
+		}
+	}
+
+	if rl := cfg.Security.RateLimit; rl.Enabled && rl.RequestsPerSecond > 0 {
+		if prev != nil && prev.config.Security.RateLimit == rl {
+			s.limiter = prev.limiter
+		} else {
+			burst := rl.Burst
+			if burst < 1 {
+				burst = int(rl.RequestsPerSecond)
+			}
+			if burst < 1 {
+				burst = 1
+			}
+			s.limiter = ratelimit.NewLimiter(rl.RequestsPerSecond, burst)
+		}
+	}
+
+	if cfg.Security.TrustProxyHeaders {
+		s.trustedProxies = parseProxies(cfg.Security.TrustedProxies)
+	}
+
+	s.handler = r.router
+	if cfg.CORS.Enabled {
+		s.handler = handlers.CORS(
+			handlers.AllowedOrigins(cfg.CORS.AllowedOrigins),
+			handlers.AllowedMethods(cfg.CORS.AllowedMethods),
+			handlers.AllowedHeaders(cfg.CORS.AllowedHeaders),
+		)(r.router)
+	}
+
+	return s
+}
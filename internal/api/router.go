@@ -1,9 +1,22 @@
 package api
 
 import (
+	"docker-registry-manager/internal/audit"
+	"docker-registry-manager/internal/auth"
 	"docker-registry-manager/internal/config"
+	"docker-registry-manager/internal/events"
+	"docker-registry-manager/internal/health"
+	"docker-registry-manager/internal/index"
+	"docker-registry-manager/internal/metrics"
+	"docker-registry-manager/internal/notifications"
+	"docker-registry-manager/internal/quota"
 	"docker-registry-manager/internal/storage"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"sync/atomic"
 
 	"docker-registry-manager/web"
 	"io/fs"
@@ -14,90 +27,313 @@ import (
 
 // Router handles HTTP routing for the registry
 type Router struct {
-	config  *config.Config
-	storage storage.Storage
-	router  *mux.Router
+	state       atomic.Pointer[routerState]
+	storage     storage.Storage
+	router      *mux.Router
+	tokens      *auth.TokenStore
+	backends    []auth.Authenticator
+	sessions    *auth.SessionStore
+	oidc        *auth.OIDCAuthenticator
+	clientCerts *auth.ClientCertAuthenticator
+	auditLog    *audit.Logger
+	notifier    *notifications.Notifier
+	events      *events.Broker
+	metrics     *metrics.Metrics
+	accessLog   *log.Logger
+	health      *health.Checker
+	index       *index.Index
+	quotas      *quota.Checker
+}
+
+// recentActivity is how many events are kept for the index page and for
+// event stream clients that reconnect
+const recentActivity = 50
+
+// Option configures optional Router dependencies
+type Option func(*Router)
+
+// WithTokenStore enables robot accounts and personal access tokens
+func WithTokenStore(tokens *auth.TokenStore) Option {
+	return func(r *Router) {
+		r.tokens = tokens
+	}
+}
+
+// WithAuthenticators adds external credential backends such as LDAP. They are
+// consulted after the configured user and access tokens.
+func WithAuthenticators(backends ...auth.Authenticator) Option {
+	return func(r *Router) {
+		r.backends = append(r.backends, backends...)
+	}
+}
+
+// WithOIDC enables single sign-on to the web UI through an OpenID Connect provider
+func WithOIDC(oidc *auth.OIDCAuthenticator) Option {
+	return func(r *Router) {
+		r.oidc = oidc
+	}
+}
+
+// WithClientCerts authenticates API clients by their TLS client certificate
+// when they send no Basic credentials
+func WithClientCerts(clientCerts *auth.ClientCertAuthenticator) Option {
+	return func(r *Router) {
+		r.clientCerts = clientCerts
+	}
+}
+
+// WithAuditLog records registry mutations and logins in the audit log
+func WithAuditLog(auditLog *audit.Logger) Option {
+	return func(r *Router) {
+		r.auditLog = auditLog
+	}
+}
+
+// WithNotifier publishes push, pull and delete events to webhook endpoints
+func WithNotifier(notifier *notifications.Notifier) Option {
+	return func(r *Router) {
+		r.notifier = notifier
+	}
+}
+
+// WithMetrics records Prometheus metrics and serves them at the configured path
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(r *Router) {
+		r.metrics = m
+	}
+}
+
+// WithAccessLog writes Common or Combined Log Format lines to w instead of
+// stdout when logging.access_format selects one of them
+func WithAccessLog(w io.Writer) Option {
+	return func(r *Router) {
+		r.accessLog = log.New(w, "", 0)
+	}
+}
+
+// WithHealth serves the readiness checks and drain state of checker at /readyz
+func WithHealth(checker *health.Checker) Option {
+	return func(r *Router) {
+		r.health = checker
+	}
+}
+
+// WithIndex answers repository listings and statistics from the metadata
+// index. The router's storage should be the index, or wrap it, so that
+// pushes and deletes keep it current.
+func WithIndex(idx *index.Index) Option {
+	return func(r *Router) {
+		r.index = idx
+	}
 }
 
 // NewRouter creates a new router instance
-func NewRouter(cfg *config.Config, storage storage.Storage) *mux.Router {
+func NewRouter(cfg *config.Config, storage storage.Storage, opts ...Option) *Router {
 	r := &Router{
-		config:  cfg,
-		storage: storage,
-		router:  mux.NewRouter(),
+		storage:  storage,
+		router:   mux.NewRouter(),
+		sessions: auth.NewSessionStore(cfg.GetSessionTTL()),
+		events:   events.NewBroker(recentActivity),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.health == nil {
+		r.health = health.New()
 	}
+	r.quotas = quota.New(storage, r.index)
 
+	switch cfg.Logging.AccessFormat {
+	case AccessFormatCommon, AccessFormatCombined:
+		if r.accessLog == nil {
+			r.accessLog = log.New(os.Stdout, "", 0)
+		}
+	default:
+		r.accessLog = nil
+	}
+
+	r.state.Store(r.newState(cfg, nil))
 	r.setupRoutes()
-	return r.router
+	return r
 }
 
-// 添加认证中间件
-func (r *Router) authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if !r.config.Auth.Enabled {
-			next.ServeHTTP(w, req)
-			return
+// authenticate resolves the identity behind the request's Basic credentials,
+// or failing that its TLS client certificate. It returns a nil identity and
+// no error when no credentials were sent.
+func (r *Router) authenticate(req *http.Request) (*auth.Identity, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		if r.clientCerts == nil {
+			return nil, nil
 		}
-
-		username, password, ok := req.BasicAuth()
-		if !ok || username != r.config.Auth.Username || password != r.config.Auth.Password {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Docker Registry Manager"`)
-			r.writeError(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Unauthorized access")
-			return
+		identity, err := r.clientCerts.Identify(req.TLS)
+		if err != nil {
+			r.metrics.AuthFailure(metrics.AuthInvalidClientCert)
 		}
+		return identity, err
+	}
+
+	return r.checkCredentials(req, username, password)
+}
+
+// requireAccess returns middleware that authorizes action on the {name}
+// repository. Routes without a repository only require authentication.
+func (r *Router) requireAccess(action auth.Action) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !r.cfg().Auth.Enabled {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			identity, err := r.authenticate(req)
+			if err != nil {
+				var locked *lockedOutError
+				if errors.As(err, &locked) {
+					r.writeTooManyRequests(w, locked.retryAfter, "Too many failed authentication attempts")
+					return
+				}
+				if !errors.Is(err, auth.ErrInvalidCredentials) {
+					logrus.Errorf("Authentication backend error: %v", err)
+				}
+				r.writeAuthChallenge(w)
+				return
+			}
+
+			if identity == nil {
+				if action == auth.ActionPull && !r.cfg().Auth.RequirePullAuth {
+					next.ServeHTTP(w, req)
+					return
+				}
+				r.writeAuthChallenge(w)
+				return
+			}
+
+			setAccessUser(req, identity)
+			if name := mux.Vars(req)["name"]; name != "" && !identity.Can(name, action) {
+				r.metrics.AuthFailure(metrics.AuthDenied)
+				r.writeError(w, http.StatusForbidden, ErrorCodeDenied, "Requested access to the resource is denied")
+				return
+			}
+
+			next.ServeHTTP(w, req.WithContext(auth.WithIdentity(req.Context(), identity)))
+		})
+	}
+}
+
+// 添加认证中间件
+func (r *Router) authMiddleware(next http.Handler) http.Handler {
+	return r.requireAccess(auth.ActionPush)(next)
+}
 
-		next.ServeHTTP(w, req)
-	})
+// writeAuthChallenge writes a 401 response asking for Basic credentials
+func (r *Router) writeAuthChallenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Docker Registry Manager"`)
+	r.writeError(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Unauthorized access")
 }
 
 // 修改setupRoutes方法，为上传路由添加认证中间件
 func (r *Router) setupRoutes() {
 	// Docker Registry API v2 routes
 	v2 := r.router.PathPrefix("/v2").Subrouter()
+	v2.Use(r.rateLimitMiddleware)
+	pull := r.requireAccess(auth.ActionPull)
+	push := r.requireAccess(auth.ActionPush)
 
 	// Base endpoint - returns 200 OK to indicate v2 support
-	v2.HandleFunc("/", r.handleV2Base).Methods("GET")
+	v2.Handle("/", pull(http.HandlerFunc(r.handleV2Base))).Methods("GET")
 
 	// Manifest routes
-	v2.HandleFunc("/{name:.+}/manifests/{reference}", r.handleManifestGet).Methods("GET")
-	v2.HandleFunc("/{name:.+}/manifests/{reference}", r.handleManifestPut).Methods("PUT")
-	v2.HandleFunc("/{name:.+}/manifests/{reference}", r.handleManifestHead).Methods("HEAD")
-	v2.HandleFunc("/{name:.+}/manifests/{reference}", r.handleManifestDelete).Methods("DELETE")
+	v2.Handle("/{name:.+}/manifests/{reference}", pull(http.HandlerFunc(r.handleManifestGet))).Methods("GET")
+	v2.Handle("/{name:.+}/manifests/{reference}", push(r.audited(auditAs(audit.ActionPushManifest), http.HandlerFunc(r.handleManifestPut)))).Methods("PUT")
+	v2.Handle("/{name:.+}/manifests/{reference}", pull(http.HandlerFunc(r.handleManifestHead))).Methods("HEAD")
+	v2.Handle("/{name:.+}/manifests/{reference}", push(r.audited(r.manifestDeleteAction, http.HandlerFunc(r.handleManifestDelete)))).Methods("DELETE")
 
 	// Blob routes
-	v2.HandleFunc("/{name:.+}/blobs/{digest}", r.handleBlobGet).Methods("GET")
-	v2.HandleFunc("/{name:.+}/blobs/{digest}", r.handleBlobHead).Methods("HEAD")
-	v2.HandleFunc("/{name:.+}/blobs/{digest}", r.handleBlobDelete).Methods("DELETE")
+	v2.Handle("/{name:.+}/blobs/{digest}", pull(http.HandlerFunc(r.handleBlobGet))).Methods("GET")
+	v2.Handle("/{name:.+}/blobs/{digest}", pull(http.HandlerFunc(r.handleBlobHead))).Methods("HEAD")
+	v2.Handle("/{name:.+}/blobs/{digest}", push(r.audited(auditAs(audit.ActionDeleteBlob), http.HandlerFunc(r.handleBlobDelete)))).Methods("DELETE")
 
 	// Blob upload routes - 添加认证保护
 	uploadRouter := v2.PathPrefix("/{name:.+}/blobs/uploads/").Subrouter()
 	uploadRouter.Use(r.authMiddleware) // 应用认证中间件
-	uploadRouter.HandleFunc("/", r.handleBlobUploadPost).Methods("POST")
+	uploadRouter.Handle("/", r.audited(monolithicUploadAction, http.HandlerFunc(r.handleBlobUploadPost))).Methods("POST")
 	uploadRouter.HandleFunc("/{uuid}", r.handleBlobUploadPatch).Methods("PATCH")
-	uploadRouter.HandleFunc("/{uuid}", r.handleBlobUploadPut).Methods("PUT")
+	uploadRouter.Handle("/{uuid}", r.audited(auditAs(audit.ActionPushBlob), http.HandlerFunc(r.handleBlobUploadPut))).Methods("PUT")
 	uploadRouter.HandleFunc("/{uuid}", r.handleBlobUploadGet).Methods("GET")
 	uploadRouter.HandleFunc("/{uuid}", r.handleBlobUploadDelete).Methods("DELETE")
 
 	// Catalog and tags routes
-	v2.HandleFunc("/_catalog", r.handleCatalog).Methods("GET")
-	v2.HandleFunc("/{name:.+}/tags/list", r.handleTagsList).Methods("GET")
+	v2.Handle("/_catalog", pull(http.HandlerFunc(r.handleCatalog))).Methods("GET")
+	v2.Handle("/{name:.+}/tags/list", pull(http.HandlerFunc(r.handleTagsList))).Methods("GET")
+
+	// Robot account and access token administration
+	if r.tokens != nil {
+		admin := r.router.PathPrefix("/api/admin").Subrouter()
+		admin.HandleFunc("/tokens", r.requireAdmin(r.handleListTokens)).Methods("GET")
+		admin.HandleFunc("/tokens", r.requireAdmin(r.handleCreateToken)).Methods("POST")
+		admin.HandleFunc("/tokens/{id}", r.requireAdmin(r.handleRevokeToken)).Methods("DELETE")
+	}
+
+	// Audit log queries
+	if r.auditLog != nil {
+		r.router.HandleFunc("/api/admin/audit", r.requireAdmin(r.handleAuditQuery)).Methods("GET")
+	}
+
+	// Webhook delivery status
+	if r.notifier != nil {
+		r.router.HandleFunc("/api/admin/notifications", r.requireAdmin(r.handleNotificationStatus)).Methods("GET")
+	}
+
+	// Metadata index rebuild
+	if r.index != nil {
+		r.router.HandleFunc("/api/admin/index/rebuild", r.requireAdmin(r.handleIndexRebuild)).Methods("POST")
+	}
+
+	// Prometheus metrics
+	if r.metrics != nil {
+		r.router.Handle(r.cfg().GetMetricsPath(), r.metrics.Handler()).Methods("GET")
+	}
+
+	// Liveness and readiness probes, unauthenticated for the orchestrator
+	r.router.HandleFunc("/healthz", r.health.LiveHandler).Methods("GET", "HEAD")
+	r.router.HandleFunc("/readyz", r.health.ReadyHandler).Methods("GET", "HEAD")
 
 	// Web interface routes (if enabled)
-	if r.config.Web.Enabled {
+	if r.cfg().Web.Enabled {
 		r.router.HandleFunc("/", r.handleWebIndex).Methods("GET")
 		r.router.HandleFunc("/repositories", r.handleWebRepositories).Methods("GET")
 		r.router.HandleFunc("/repositories/{name:.+}", r.handleWebRepository).Methods("GET")
+		if r.tokens != nil {
+			r.router.HandleFunc("/admin/tokens", r.handleWebTokens).Methods("GET")
+		}
+		if r.auditLog != nil {
+			r.router.HandleFunc("/admin/audit", r.handleWebAudit).Methods("GET")
+		}
+		if r.notifier != nil {
+			r.router.HandleFunc("/admin/notifications", r.handleWebNotifications).Methods("GET")
+		}
+		if r.oidc != nil {
+			r.router.HandleFunc("/auth/oidc/login", r.handleOIDCLogin).Methods("GET")
+			r.router.HandleFunc("/auth/oidc/callback", r.handleOIDCCallback).Methods("GET")
+		}
 
 		// API endpoints for AJAX
 		api := r.router.PathPrefix("/api").Subrouter()
 		api.HandleFunc("/repositories", r.handleAPIRepositories).Methods("GET")
 		api.HandleFunc("/stats", r.handleAPIStats).Methods("GET")
+		api.HandleFunc("/events", r.handleEvents).Methods("GET")
 		api.HandleFunc("/login", r.handleLogin).Methods("POST")
 		api.HandleFunc("/logout", r.handleLogout).Methods("POST")
 
 		// Repository description API endpoints
 		api.HandleFunc("/repositories/{name}/description", r.handleGetRepositoryDescription).Methods("GET")
-		api.HandleFunc("/repositories/{name}/description", r.handlePutRepositoryDescription).Methods("PUT")
+		api.Handle("/repositories/{name}/description", r.audited(auditAs(audit.ActionEditDescription), http.HandlerFunc(r.handlePutRepositoryDescription))).Methods("PUT")
+		api.Handle("/repositories/{name:.+}/tags/{tag}", r.audited(auditAs(audit.ActionDeleteTag), http.HandlerFunc(r.handleDeleteTag))).Methods("DELETE")
+
+		// Quota usage of a repository
+		api.HandleFunc("/repositories/{name:.+}/quota", r.handleGetRepositoryQuota).Methods("GET")
 
 		// Static files
 		// 静态文件服务 - 使用嵌入的文件系统
@@ -114,15 +350,34 @@ func (r *Router) setupRoutes() {
 
 	// Add logging middleware
 	r.router.Use(r.loggingMiddleware)
+	notFound := http.NotFoundHandler()
+	if r.metrics != nil {
+		notFound = r.metricsMiddleware(notFound)
+	}
+	if r.cfg().Tracing.Enabled {
+		r.router.Use(r.tracingMiddleware)
+		notFound = r.tracingMiddleware(notFound)
+	}
+	if r.metrics != nil {
+		r.router.Use(r.metricsMiddleware)
+	}
+	r.router.NotFoundHandler = r.loggingMiddleware(notFound)
 }
 
-func (r *Router) loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		logrus.WithFields(logrus.Fields{
-			"method": req.Method,
-			"path":   req.URL.Path,
-			"remote": req.RemoteAddr,
-		}).Info("HTTP request")
-		next.ServeHTTP(w, req)
-	})
+// manifestDeleteAction distinguishes deleting a manifest by digest from
+// deleting a tag, which share a route
+func (r *Router) manifestDeleteAction(req *http.Request) string {
+	if r.isValidDigest(mux.Vars(req)["reference"]) {
+		return audit.ActionDeleteManifest
+	}
+	return audit.ActionDeleteTag
+}
+
+// monolithicUploadAction audits a POST upload only when it carries the whole
+// blob; starting a chunked upload is not a mutation
+func monolithicUploadAction(req *http.Request) string {
+	if req.URL.Query().Get("digest") == "" {
+		return ""
+	}
+	return audit.ActionPushBlob
 }
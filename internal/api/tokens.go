@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/audit"
+	"docker-registry-manager/internal/auth"
+	"docker-registry-manager/internal/metrics"
+)
+
+// TokenResponse is the API representation of a token; the hash is never exposed
+type TokenResponse struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Robot       bool         `json:"robot"`
+	Username    string       `json:"username"`
+	Owner       string       `json:"owner"`
+	Role        auth.Role    `json:"role"`
+	Scopes      []auth.Scope `json:"scopes"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time   `json:"last_used_at,omitempty"`
+	Secret      string       `json:"secret,omitempty"`
+}
+
+// CreateTokenRequest is the body of POST /api/admin/tokens
+type CreateTokenRequest struct {
+	Name          string       `json:"name"`
+	Description   string       `json:"description"`
+	Robot         bool         `json:"robot"`
+	Scopes        []auth.Scope `json:"scopes"`
+	ExpiresInDays int          `json:"expires_in_days"`
+}
+
+// TokenData represents a token for web display
+type TokenData struct {
+	ID         string
+	Name       string
+	Robot      bool
+	Username   string
+	Scopes     []auth.Scope
+	CreatedAt  string
+	ExpiresAt  string
+	LastUsedAt string
+	Expired    bool
+}
+
+// requireAdmin rejects requests that do not come from an administrator,
+// identified either by web session or Basic credentials
+func (r *Router) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		identity := r.sessionIdentity(req)
+		if identity == nil {
+			var err error
+			identity, err = r.authenticate(req)
+			var locked *lockedOutError
+			if errors.As(err, &locked) {
+				r.writeTooManyRequests(w, locked.retryAfter, "Too many failed authentication attempts")
+				return
+			}
+			if err != nil || identity == nil {
+				r.writeAuthChallenge(w)
+				return
+			}
+		}
+		setAccessUser(req, identity)
+		if !identity.IsAdmin() {
+			r.metrics.AuthFailure(metrics.AuthDenied)
+			r.writeError(w, http.StatusForbidden, ErrorCodeDenied, "Administrator access required")
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(auth.WithIdentity(req.Context(), identity)))
+	}
+}
+
+// handleListTokens returns all robot accounts and personal access tokens
+func (r *Router) handleListTokens(w http.ResponseWriter, req *http.Request) {
+	tokens := r.tokens.List()
+
+	response := make([]TokenResponse, 0, len(tokens))
+	for _, t := range tokens {
+		response = append(response, newTokenResponse(t, ""))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleCreateToken creates a robot account or personal access token
+func (r *Router) handleCreateToken(w http.ResponseWriter, req *http.Request) {
+	var body CreateTokenRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		r.writeError(w, http.StatusBadRequest, ErrorCodeUnsupported, "Invalid request body")
+		return
+	}
+
+	if body.ExpiresInDays < 0 {
+		r.writeError(w, http.StatusBadRequest, ErrorCodeUnsupported, "expires_in_days must not be negative")
+		return
+	}
+
+	owner := auth.IdentityFromContext(req.Context())
+	tokenReq := auth.TokenRequest{
+		Name:        body.Name,
+		Description: body.Description,
+		Robot:       body.Robot,
+		Owner:       owner.Username,
+		Role:        owner.Role,
+		Scopes:      body.Scopes,
+	}
+
+	// Robot accounts only ever get what their scopes grant
+	if body.Robot {
+		tokenReq.Role = auth.RoleDeveloper
+		if tokenReq.Scopes == nil {
+			tokenReq.Scopes = []auth.Scope{}
+		}
+	}
+
+	if body.ExpiresInDays > 0 {
+		expiresAt := time.Now().UTC().AddDate(0, 0, body.ExpiresInDays)
+		tokenReq.ExpiresAt = &expiresAt
+	}
+
+	token, secret, err := r.tokens.Create(tokenReq)
+	if err != nil {
+		r.recordAudit(req, audit.Event{
+			Action:    audit.ActionCreateToken,
+			Reference: body.Name,
+			Status:    http.StatusBadRequest,
+			Detail:    err.Error(),
+		})
+		logrus.Errorf("Failed to create token %s: %v", body.Name, err)
+		r.writeError(w, http.StatusBadRequest, ErrorCodeUnsupported, err.Error())
+		return
+	}
+
+	r.recordAudit(req, audit.Event{
+		Action:    audit.ActionCreateToken,
+		Reference: token.Username(),
+		Status:    http.StatusCreated,
+		Detail:    token.ID,
+	})
+	logrus.Infof("Token %s (%s) created by %s", token.Name, token.ID, owner.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newTokenResponse(token, secret))
+}
+
+// handleRevokeToken deletes a token so it can no longer be used
+func (r *Router) handleRevokeToken(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	err := r.tokens.Revoke(id)
+	status := http.StatusNoContent
+	if err != nil {
+		status = http.StatusInternalServerError
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			status = http.StatusNotFound
+		}
+	}
+	r.recordAudit(req, audit.Event{
+		Action: audit.ActionRevokeToken,
+		Status: status,
+		Detail: id,
+	})
+
+	if err != nil {
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			r.writeError(w, http.StatusNotFound, ErrorCodeUnknown, "Token not found")
+			return
+		}
+		logrus.Errorf("Failed to revoke token %s: %v", id, err)
+		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to revoke token")
+		return
+	}
+
+	logrus.Infof("Token %s revoked", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWebTokens renders the token management page
+func (r *Router) handleWebTokens(w http.ResponseWriter, req *http.Request) {
+	identity := r.sessionIdentity(req)
+	if identity == nil {
+		http.Redirect(w, req, "/login", http.StatusFound)
+		return
+	}
+	if !identity.IsAdmin() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	var tokenData []TokenData
+	for _, t := range r.tokens.List() {
+		data := TokenData{
+			ID:         t.ID,
+			Name:       t.Name,
+			Robot:      t.Robot,
+			Username:   t.Username(),
+			Scopes:     t.Scopes,
+			CreatedAt:  t.CreatedAt.Local().Format("2006-01-02 15:04"),
+			ExpiresAt:  "永不过期",
+			LastUsedAt: "从未使用",
+			Expired:    t.Expired(now),
+		}
+		if t.ExpiresAt != nil {
+			data.ExpiresAt = t.ExpiresAt.Local().Format("2006-01-02 15:04")
+		}
+		if t.LastUsedAt != nil {
+			data.LastUsedAt = t.LastUsedAt.Local().Format("2006-01-02 15:04")
+		}
+		tokenData = append(tokenData, data)
+	}
+
+	data := r.newWebData(req)
+	data.Tokens = tokenData
+
+	r.renderTemplate(w, "tokens.html", data)
+}
+
+// newTokenResponse converts a token to its API representation
+func newTokenResponse(t auth.Token, secret string) TokenResponse {
+	return TokenResponse{
+		ID:          t.ID,
+		Name:        t.Name,
+		Description: t.Description,
+		Robot:       t.Robot,
+		Username:    t.Username(),
+		Owner:       t.Owner,
+		Role:        t.Role,
+		Scopes:      t.Scopes,
+		CreatedAt:   t.CreatedAt,
+		ExpiresAt:   t.ExpiresAt,
+		LastUsedAt:  t.LastUsedAt,
+		Secret:      secret,
+	}
+}
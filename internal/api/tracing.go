@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/gorilla/mux"
+
+	"docker-registry-manager/internal/tracing"
+)
+
+// tracingMiddleware starts a server span for each request, continuing any
+// trace passed in traceparent, and makes it the parent of handler and
+// storage spans through the request context
+func (r *Router) tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		route := routeLabel(req)
+		ctx, span := tracing.Tracer().Start(ctx, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(req.Method),
+				semconv.HTTPRoute(route),
+				attribute.String("http.request_id", requestID(req)),
+			),
+		)
+		defer span.End()
+
+		if name := mux.Vars(req)["name"]; name != "" {
+			span.SetAttributes(attribute.String("registry.repository", name))
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req.WithContext(ctx))
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// startSpan starts a child span of the request's span for a step of a
+// handler, such as reading or hashing a request body
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// readBody reads the request body in a child span recording its size
+func readBody(req *http.Request) ([]byte, error) {
+	_, span := startSpan(req.Context(), "read body")
+	defer span.End()
+
+	data, err := io.ReadAll(req.Body)
+	span.SetAttributes(attribute.Int("registry.size", len(data)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return data, err
+}
+
+// sha256Digest computes the digest of data in a child span
+func sha256Digest(ctx context.Context, data []byte) string {
+	_, span := startSpan(ctx, "sha256", attribute.Int("registry.size", len(data)))
+	defer span.End()
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	span.SetAttributes(attribute.String("registry.digest", digest))
+	return digest
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"docker-registry-manager/internal/auth"
+
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
@@ -23,6 +25,7 @@ const (
 	ErrorCodeTagInvalid          = "TAG_INVALID"
 	ErrorCodeUnauthorized        = "UNAUTHORIZED"
 	ErrorCodeDenied              = "DENIED"
+	ErrorCodeTooManyRequests     = "TOOMANYREQUESTS"
 	ErrorCodeUnsupported         = "UNSUPPORTED"
 	ErrorCodeUnknown             = "UNKNOWN"
 )
@@ -37,6 +40,8 @@ type RegistryError struct {
 // ErrorResponse represents the error response format
 type ErrorResponse struct {
 	Errors []RegistryError `json:"errors"`
+	// RequestID lets clients quote the request when reporting a problem
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // CatalogResponse represents the catalog response
@@ -58,13 +63,24 @@ func (r *Router) handleV2Base(w http.ResponseWriter, req *http.Request) {
 
 // handleCatalog handles the catalog endpoint
 func (r *Router) handleCatalog(w http.ResponseWriter, req *http.Request) {
-	repositories, err := r.storage.ListRepositories()
+	repositories, err := r.storage.ListRepositories(req.Context())
 	if err != nil {
 		logrus.Errorf("Failed to list repositories: %v", err)
 		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to list repositories")
 		return
 	}
 
+	// Hide repositories a scoped token cannot pull
+	if identity := auth.IdentityFromContext(req.Context()); identity != nil && identity.Scopes != nil {
+		visible := make([]string, 0, len(repositories))
+		for _, repo := range repositories {
+			if identity.Can(repo, auth.ActionPull) {
+				visible = append(visible, repo)
+			}
+		}
+		repositories = visible
+	}
+
 	response := CatalogResponse{
 		Repositories: repositories,
 	}
@@ -84,7 +100,7 @@ func (r *Router) handleTagsList(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	tags, err := r.storage.ListTags(name)
+	tags, err := r.storage.ListTags(req.Context(), name)
 	if err != nil {
 		logrus.Errorf("Failed to list tags for repository %s: %v", name, err)
 		r.writeError(w, http.StatusNotFound, ErrorCodeNameUnknown, "Repository not found")
@@ -113,6 +129,7 @@ func (r *Router) writeError(w http.ResponseWriter, statusCode int, errorCode, me
 				Message: message,
 			},
 		},
+		RequestID: w.Header().Get(requestIDHeader),
 	}
 
 	json.NewEncoder(w).Encode(errorResponse)
@@ -2,7 +2,7 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"html/template"
 	"io"
 	"net/http"
@@ -12,9 +12,16 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 
+	"docker-registry-manager/internal/audit"
+	"docker-registry-manager/internal/auth"
+	"docker-registry-manager/internal/events"
+	"docker-registry-manager/internal/notifications"
 	"docker-registry-manager/web"
 )
 
+// sessionCookieName is the cookie holding the web session ID
+const sessionCookieName = "session"
+
 // WebData represents data passed to web templates
 type WebData struct {
 	Title                 string
@@ -22,8 +29,16 @@ type WebData struct {
 	Repository            *RepositoryData
 	Stats                 *StatsData
 	IsLoggedIn            bool
+	IsAdmin               bool
 	Username              string
+	OIDCEnabled           bool
 	RepositoryDescription string
+	Tokens                []TokenData
+	AuditEvents           []audit.Event
+	AuditFilter           audit.Filter
+	Notifications         []notifications.EndpointStatus
+	Activity              []events.Event
+	Quotas                []QuotaData
 }
 
 // RepositoryData represents repository information for web display
@@ -48,83 +63,39 @@ type StatsData struct {
 
 // handleWebIndex handles the main web interface
 func (r *Router) handleWebIndex(w http.ResponseWriter, req *http.Request) {
-	repositories, err := r.storage.ListRepositories()
+	infos, err := r.repositoryInfos(req.Context())
 	if err != nil {
 		logrus.Errorf("Failed to list repositories: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	var repoData []RepositoryData
-	totalTags := 0
-
-	for _, repo := range repositories {
-		tags, err := r.storage.ListTags(repo)
-		if err != nil {
-			logrus.Errorf("Failed to list tags for %s: %v", repo, err)
-			continue
-		}
-
-		repoData = append(repoData, RepositoryData{
-			Name:     repo,
-			TagCount: len(tags),
-		})
-		totalTags += len(tags)
-	}
-
-	totalSize, err := r.storage.GetTotalStorageSize()
+	stats, err := r.registryStats(req.Context(), infos)
 	if err != nil {
-		logrus.Errorf("Failed to get total storage size: %v", err)
+		logrus.Errorf("Failed to get statistics: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
-	// Convert bytes to MB
-	totalSizeMB := float64(totalSize) / (1024 * 1024)
-	formattedSize := fmt.Sprintf("%.2f MB", totalSizeMB)
-
-	data := WebData{
-		Title:        r.config.Web.Title,
-		Repositories: repoData,
-		Stats: &StatsData{
-			RepositoryCount: len(repositories),
-			TotalTags:       totalTags,
-			TotalSize:       formattedSize,
-		},
-		IsLoggedIn: r.isLoggedIn(req),
-		Username:   r.config.Auth.Username,
-	}
+	data := r.newWebData(req)
+	data.Repositories = repositoryData(infos)
+	data.Stats = stats
+	data.Activity = r.recentActivity(req)
 
 	r.renderTemplate(w, "index.html", data)
 }
 
 // handleWebRepositories handles the repositories list page
 func (r *Router) handleWebRepositories(w http.ResponseWriter, req *http.Request) {
-	repositories, err := r.storage.ListRepositories()
+	infos, err := r.repositoryInfos(req.Context())
 	if err != nil {
 		logrus.Errorf("Failed to list repositories: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	var repoData []RepositoryData
-	for _, repo := range repositories {
-		tags, err := r.storage.ListTags(repo)
-		if err != nil {
-			logrus.Errorf("Failed to list tags for %s: %v", repo, err)
-			continue
-		}
-
-		repoData = append(repoData, RepositoryData{
-			Name:     repo,
-			TagCount: len(tags),
-		})
-	}
-
-	data := WebData{
-		Title:        r.config.Web.Title,
-		Repositories: repoData,
-		IsLoggedIn:   r.isLoggedIn(req),
-		Username:     r.config.Auth.Username,
-	}
+	data := r.newWebData(req)
+	data.Repositories = repositoryData(infos)
 
 	r.renderTemplate(w, "repositories.html", data)
 }
@@ -134,7 +105,7 @@ func (r *Router) handleWebRepository(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	name := vars["name"]
 
-	tags, err := r.storage.ListTags(name)
+	tags, err := r.tagInfos(req.Context(), name)
 	if err != nil {
 		logrus.Errorf("Failed to list tags for %s: %v", name, err)
 		http.Error(w, "Repository not found", http.StatusNotFound)
@@ -143,15 +114,9 @@ func (r *Router) handleWebRepository(w http.ResponseWriter, req *http.Request) {
 
 	var tagData []TagData
 	for _, tag := range tags {
-		digest, err := r.storage.GetTagDigest(name, tag)
-		if err != nil {
-			logrus.Errorf("Failed to get digest for %s:%s: %v", name, tag, err)
-			continue
-		}
-
 		tagData = append(tagData, TagData{
-			Name:   tag,
-			Digest: digest,
+			Name:   tag.Name,
+			Digest: tag.Digest,
 		})
 	}
 
@@ -162,29 +127,48 @@ func (r *Router) handleWebRepository(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// 获取仓库说明
-	desc, err := r.storage.GetRepositoryDescription(name)
+	desc, err := r.storage.GetRepositoryDescription(req.Context(), name)
 	if err != nil {
 		logrus.Errorf("Failed to get repository description for %s: %v", name, err)
 		// Non-critical error, proceed without description
 	}
 
-	data := WebData{
-		Title:                 r.config.Web.Title,
-		Repository:            &repoData,
-		IsLoggedIn:            r.isLoggedIn(req),
-		Username:              r.config.Auth.Username,
-		RepositoryDescription: desc,
+	// Quota usage is informational here; the page works without it
+	quotas, err := r.quotas.Status(req.Context(), r.cfg().Quotas, name)
+	if err != nil {
+		logrus.Errorf("Failed to get quota usage for %s: %v", name, err)
 	}
 
+	data := r.newWebData(req)
+	data.Repository = &repoData
+	data.RepositoryDescription = desc
+	data.Quotas = quotaData(quotas)
+
 	r.renderTemplate(w, "repository.html", data)
 }
 
+// newWebData returns template data describing the signed-in user
+func (r *Router) newWebData(req *http.Request) WebData {
+	data := WebData{
+		Title:       r.cfg().Web.Title,
+		OIDCEnabled: r.oidc != nil,
+	}
+
+	if identity := r.sessionIdentity(req); identity != nil {
+		data.IsLoggedIn = true
+		data.IsAdmin = identity.IsAdmin()
+		data.Username = identity.Username
+	}
+
+	return data
+}
+
 // renderTemplate renders an HTML template
 func (r *Router) renderTemplate(w http.ResponseWriter, templateName string, data interface{}) {
 	var tmpl *template.Template
 	var err error
 
-	if r.config.Web.Enabled {
+	if r.cfg().Web.Enabled {
 		// If web is enabled, try to parse embedded assets
 		tmpl, err = template.ParseFS(web.EmbeddedAssets, "templates/"+templateName)
 		if err != nil {
@@ -215,73 +199,61 @@ func (r *Router) renderTemplate(w http.ResponseWriter, templateName string, data
 
 // handleAPIRepositories returns repositories as JSON
 func (r *Router) handleAPIRepositories(w http.ResponseWriter, req *http.Request) {
-	repositories, err := r.storage.ListRepositories()
+	infos, err := r.repositoryInfos(req.Context())
 	if err != nil {
 		logrus.Errorf("Failed to list repositories: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	var repoData []RepositoryData
-	for _, repo := range repositories {
-		tags, err := r.storage.ListTags(repo)
-		if err != nil {
-			logrus.Errorf("Failed to list tags for %s: %v", repo, err)
-			continue
-		}
-
-		repoData = append(repoData, RepositoryData{
-			Name:     repo,
-			TagCount: len(tags),
-		})
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(repoData)
+	json.NewEncoder(w).Encode(repositoryData(infos))
 }
 
 // handleAPIStats returns statistics as JSON
 func (r *Router) handleAPIStats(w http.ResponseWriter, req *http.Request) {
-	repositories, err := r.storage.ListRepositories()
+	stats, err := r.registryStats(req.Context(), nil)
 	if err != nil {
-		logrus.Errorf("Failed to list repositories: %v", err)
+		logrus.Errorf("Failed to get statistics: %v", err)
 		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to list repositories")
 		return
 	}
 
-	var totalTags int
-	for _, repo := range repositories {
-		tags, err := r.storage.ListTags(repo)
-		if err != nil {
-			logrus.Errorf("Failed to list tags for %s: %v", repo, err)
-			continue
-		}
-		totalTags += len(tags)
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
 
-	totalSize, err := r.storage.GetTotalStorageSize()
+// sessionIdentity returns the identity of the request's web session, if any
+func (r *Router) sessionIdentity(req *http.Request) *auth.Identity {
+	cookie, err := req.Cookie(sessionCookieName)
 	if err != nil {
-		logrus.Errorf("Failed to get total storage size: %v", err)
+		return nil
 	}
-
-	// Convert bytes to MB
-	totalSizeMB := float64(totalSize) / (1024 * 1024)
-	formattedSize := fmt.Sprintf("%.2f MB", totalSizeMB)
-
-	stats := StatsData{
-		RepositoryCount: len(repositories),
-		TotalTags:       totalTags,
-		TotalSize:       formattedSize,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+	return r.sessions.Get(cookie.Value)
 }
 
 // 在 Router 结构体后添加辅助方法
 func (r *Router) isLoggedIn(req *http.Request) bool {
-	cookie, err := req.Cookie("login")
-	return err == nil && cookie.Value == "1"
+	return r.sessionIdentity(req) != nil
+}
+
+// startSession signs the identity in and sets the session cookie
+func (r *Router) startSession(w http.ResponseWriter, req *http.Request, identity *auth.Identity) error {
+	id, err := r.sessions.Create(identity)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(r.sessions.TTL().Seconds()),
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
 }
 
 // 登录处理函数
@@ -300,33 +272,70 @@ func (r *Router) handleLogin(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-	if lr.Username == r.config.Auth.Username && lr.Password == r.config.Auth.Password {
-		// 登录成功，设置 Cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "login",
-			Value:    "1",
-			Path:     "/",
-			HttpOnly: true,
+
+	identity, err := r.checkCredentials(req, lr.Username, lr.Password)
+	if err != nil {
+		r.recordAudit(req, audit.Event{
+			Actor:   lr.Username,
+			Action:  audit.ActionLogin,
+			Outcome: audit.OutcomeFailure,
+			Detail:  err.Error(),
 		})
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"success":true}`))
+
+		var locked *lockedOutError
+		if errors.As(err, &locked) {
+			r.writeTooManyRequests(w, locked.retryAfter, "Too many failed login attempts")
+			return
+		}
+		if !errors.Is(err, auth.ErrInvalidCredentials) {
+			logrus.Errorf("Authentication backend error: %v", err)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+	// Access tokens are for the registry API, not for web sessions
+	if identity.TokenID != "" {
+		r.recordAudit(req, audit.Event{
+			Actor:   lr.Username,
+			Action:  audit.ActionLogin,
+			Outcome: audit.OutcomeFailure,
+			Detail:  "access tokens cannot sign in to the web UI",
+		})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// 登录成功，设置 Cookie
+	if err := r.startSession(w, req, identity); err != nil {
+		logrus.Errorf("Failed to create session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	r.recordAudit(req, audit.Event{
+		Actor:   identity.Username,
+		Action:  audit.ActionLogin,
+		Outcome: audit.OutcomeSuccess,
+		Detail:  "password",
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"success":true}`))
 }
 
 // 登录页面渲染
 func (r *Router) handleWebLogin(w http.ResponseWriter, req *http.Request) {
-	data := WebData{
-		Title: r.config.Web.Title,
-	}
+	data := r.newWebData(req)
 	r.renderTemplate(w, "login.html", data)
 }
 
-// handleLogout clears the login cookie
+// handleLogout ends the web session and clears its cookie
 func (r *Router) handleLogout(w http.ResponseWriter, req *http.Request) {
+	if cookie, err := req.Cookie(sessionCookieName); err == nil {
+		r.sessions.Delete(cookie.Value)
+	}
+
 	http.SetCookie(w, &http.Cookie{
-		Name:     "login",
+		Name:     sessionCookieName,
 		Value:    "",
 		Path:     "/",
 		Expires:  time.Unix(0, 0), // Set expiry to past to delete
@@ -346,7 +355,7 @@ func (r *Router) handleGetRepositoryDescription(w http.ResponseWriter, req *http
 	vars := mux.Vars(req)
 	name := vars["name"]
 
-	description, err := r.storage.GetRepositoryDescription(name)
+	description, err := r.storage.GetRepositoryDescription(req.Context(), name)
 	if err != nil {
 		logrus.Errorf("Failed to get repository description for %s: %v", name, err)
 		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to get description")
@@ -359,7 +368,8 @@ func (r *Router) handleGetRepositoryDescription(w http.ResponseWriter, req *http
 
 // handlePutRepositoryDescription handles updating a repository's description
 func (r *Router) handlePutRepositoryDescription(w http.ResponseWriter, req *http.Request) {
-	if !r.isLoggedIn(req) {
+	identity := r.sessionIdentity(req)
+	if identity == nil {
 		r.writeError(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Unauthorized access")
 		return
 	}
@@ -367,6 +377,11 @@ func (r *Router) handlePutRepositoryDescription(w http.ResponseWriter, req *http
 	vars := mux.Vars(req)
 	name := vars["name"]
 
+	if !identity.Can(name, auth.ActionPush) {
+		r.writeError(w, http.StatusForbidden, ErrorCodeDenied, "Requested access to the resource is denied")
+		return
+	}
+
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
 		logrus.Errorf("Failed to read request body: %v", err)
@@ -376,7 +391,7 @@ func (r *Router) handlePutRepositoryDescription(w http.ResponseWriter, req *http
 
 	description := string(body)
 
-	if err := r.storage.PutRepositoryDescription(name, description); err != nil {
+	if err := r.storage.PutRepositoryDescription(req.Context(), name, description); err != nil {
 		logrus.Errorf("Failed to put repository description for %s: %v", name, err)
 		r.writeError(w, http.StatusInternalServerError, ErrorCodeUnknown, "Failed to save description")
 		return
@@ -385,3 +400,39 @@ func (r *Router) handlePutRepositoryDescription(w http.ResponseWriter, req *http
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"success":true}`))
 }
+
+// handleDeleteTag removes a tag on behalf of a signed-in web user
+func (r *Router) handleDeleteTag(w http.ResponseWriter, req *http.Request) {
+	identity := r.sessionIdentity(req)
+	if identity == nil {
+		r.writeError(w, http.StatusUnauthorized, ErrorCodeUnauthorized, "Unauthorized access")
+		return
+	}
+
+	vars := mux.Vars(req)
+	name := vars["name"]
+	tag := vars["tag"]
+
+	if !identity.Can(name, auth.ActionPush) {
+		r.writeError(w, http.StatusForbidden, ErrorCodeDenied, "Requested access to the resource is denied")
+		return
+	}
+
+	if err := r.storage.DeleteTag(req.Context(), name, tag); err != nil {
+		logrus.Errorf("Failed to delete tag %s:%s: %v", name, tag, err)
+		r.writeError(w, http.StatusNotFound, ErrorCodeManifestUnknown, "Tag not found")
+		return
+	}
+
+	logrus.Infof("Tag %s:%s deleted by %s", name, tag, identity.Username)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"success":true}`))
+
+	r.notify(req, notifications.ActionDelete, notifications.Target{Repository: name, Tag: tag})
+	r.publishActivity(req, events.Event{
+		Type:       events.TypeTagDelete,
+		Repository: name,
+		Tag:        tag,
+		Actor:      identity.Username,
+	})
+}
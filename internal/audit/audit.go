@@ -0,0 +1,241 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Audited actions
+const (
+	ActionPushManifest    = "push_manifest"
+	ActionDeleteManifest  = "delete_manifest"
+	ActionDeleteTag       = "delete_tag"
+	ActionPushBlob        = "push_blob"
+	ActionDeleteBlob      = "delete_blob"
+	ActionEditDescription = "edit_description"
+	ActionLogin           = "login"
+	ActionCreateToken     = "create_token"
+	ActionRevokeToken     = "revoke_token"
+)
+
+// Outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is one audit record
+type Event struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	Repository string    `json:"repository,omitempty"`
+	Reference  string    `json:"reference,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	ClientIP   string    `json:"client_ip"`
+	Outcome    string    `json:"outcome"`
+	Status     int       `json:"status,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// Filter selects events in Query. Zero fields match everything.
+type Filter struct {
+	Actor      string
+	Action     string
+	Repository string
+	Outcome    string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// Logger appends events as JSON lines to a file, rotating it by size
+type Logger struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file  *os.File
+	size  int64
+	mutex sync.Mutex
+}
+
+// NewLogger opens (or creates) the audit log at path. The file is rotated
+// once it exceeds maxSize bytes, keeping maxBackups old files.
+func NewLogger(path string, maxSize int64, maxBackups int) (*Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	l := &Logger{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Log appends an event
+func (l *Logger) Log(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.maxSize > 0 && l.size+int64(len(line)) > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// Query returns matching events, newest first
+func (l *Logger) Query(filter Filter) ([]Event, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var events []Event
+	for i := 0; i <= l.maxBackups; i++ {
+		path := l.backupPath(i)
+		matched, err := readEvents(path, filter)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		// Files are in chronological order; walk each backwards
+		for j := len(matched) - 1; j >= 0; j-- {
+			events = append(events, matched[j])
+			if filter.Limit > 0 && len(events) >= filter.Limit {
+				return events, nil
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// Close closes the underlying file
+func (l *Logger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.file.Close()
+}
+
+// open opens the current log file for appending. Callers must hold the lock
+// or have exclusive access.
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate shifts audit.log.N-1 -> audit.log.N ... audit.log -> audit.log.1
+// and starts a new file. Callers must hold the lock.
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	if l.maxBackups > 0 {
+		os.Remove(l.backupPath(l.maxBackups))
+		for i := l.maxBackups - 1; i >= 0; i-- {
+			if err := os.Rename(l.backupPath(i), l.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	} else if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return l.open()
+}
+
+// backupPath returns the path of the i-th file; 0 is the live log
+func (l *Logger) backupPath(i int) string {
+	if i == 0 {
+		return l.path
+	}
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+// readEvents returns the events in a file that match filter
+func readEvents(path string, filter Filter) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue // Skip a line truncated by a crash
+		}
+		if filter.matches(event) {
+			events = append(events, event)
+		}
+	}
+
+	return events, scanner.Err()
+}
+
+// matches reports whether event passes the filter
+func (f Filter) matches(event Event) bool {
+	if f.Actor != "" && event.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && event.Action != f.Action {
+		return false
+	}
+	if f.Repository != "" && event.Repository != f.Repository {
+		return false
+	}
+	if f.Outcome != "" && event.Outcome != f.Outcome {
+		return false
+	}
+	if !f.Since.IsZero() && event.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && event.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrInvalidCredentials is returned when a username/password pair is rejected
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator verifies a username and password
+type Authenticator interface {
+	Authenticate(username, password string) (*Identity, error)
+}
+
+// StaticAuthenticator checks credentials against the single user from config
+type StaticAuthenticator struct {
+	Username string
+	Password string
+}
+
+// Authenticate implements Authenticator
+func (s *StaticAuthenticator) Authenticate(username, password string) (*Identity, error) {
+	// Compare digests so neither the contents nor the lengths leak through timing
+	userOK := constantTimeEqual(username, s.Username)
+	passOK := constantTimeEqual(password, s.Password)
+	if s.Username == "" || !userOK || !passOK {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Username: username,
+		Role:     RoleAdmin,
+	}, nil
+}
+
+// Chain tries each authenticator in order and returns the first success.
+// A backend failure does not stop the chain, but is reported if no other
+// authenticator accepts the credentials.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator
+func (c Chain) Authenticate(username, password string) (*Identity, error) {
+	var backendErr error
+	for _, a := range c {
+		id, err := a.Authenticate(username, password)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) && backendErr == nil {
+			backendErr = err
+		}
+	}
+
+	if backendErr != nil {
+		return nil, backendErr
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// constantTimeEqual compares two strings in time independent of their contents
+func constantTimeEqual(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
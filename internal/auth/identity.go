@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"path"
+)
+
+// Action is an operation a client performs against a repository
+type Action string
+
+const (
+	ActionPull Action = "pull"
+	ActionPush Action = "push"
+)
+
+// Role is the coarse permission level of a user
+type Role string
+
+const (
+	RoleAdmin     Role = "admin"
+	RoleDeveloper Role = "developer"
+	RoleReader    Role = "reader"
+)
+
+// Scope grants a set of actions on repositories matching a pattern.
+// Patterns use path.Match syntax, e.g. "team/*" or "*".
+type Scope struct {
+	Repository string   `json:"repository"`
+	Actions    []Action `json:"actions"`
+}
+
+// Identity describes an authenticated client
+type Identity struct {
+	Username string
+	Role     Role
+	// Scopes restricts access when the identity was established with a
+	// token. A nil slice means the role alone decides.
+	Scopes  []Scope
+	TokenID string
+}
+
+// IsAdmin reports whether the identity may use the admin API
+func (id *Identity) IsAdmin() bool {
+	return id != nil && id.Role == RoleAdmin && id.Scopes == nil
+}
+
+// Can reports whether the identity may perform action on repository
+func (id *Identity) Can(repository string, action Action) bool {
+	if id == nil {
+		return false
+	}
+
+	if !roleAllows(id.Role, action) {
+		return false
+	}
+
+	if id.Scopes == nil {
+		return true
+	}
+
+	for _, scope := range id.Scopes {
+		if scope.Allows(repository, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the scope covers action on repository
+func (s Scope) Allows(repository string, action Action) bool {
+	matched, err := path.Match(s.Repository, repository)
+	if err != nil || !matched {
+		return false
+	}
+
+	for _, a := range s.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// roleAllows reports whether a role permits an action at all
+func roleAllows(role Role, action Action) bool {
+	switch role {
+	case RoleAdmin, RoleDeveloper:
+		return true
+	case RoleReader:
+		return action == ActionPull
+	default:
+		return false
+	}
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role Role) bool {
+	switch role {
+	case RoleAdmin, RoleDeveloper, RoleReader:
+		return true
+	default:
+		return false
+	}
+}
+
+// rolePriority orders roles so the most privileged mapped group wins
+var rolePriority = map[Role]int{
+	RoleReader:    1,
+	RoleDeveloper: 2,
+	RoleAdmin:     3,
+}
+
+// mapGroupsToRole picks the most privileged role among the groups, falling
+// back to defaultRole. It returns false if no role applies.
+func mapGroupsToRole(groups []string, mapping map[string]string, defaultRole string) (Role, bool) {
+	var best Role
+	for _, group := range groups {
+		role := Role(mapping[group])
+		if rolePriority[role] > rolePriority[best] {
+			best = role
+		}
+	}
+
+	if best == "" {
+		if defaultRole == "" {
+			return "", false
+		}
+		best = Role(defaultRole)
+	}
+	return best, true
+}
+
+// validateRoleMapping checks that a group mapping only names known roles
+func validateRoleMapping(mapping map[string]string, defaultRole string) error {
+	for group, role := range mapping {
+		if !ValidRole(Role(role)) {
+			return fmt.Errorf("role_mapping: invalid role %q for group %q", role, group)
+		}
+	}
+	if defaultRole != "" && !ValidRole(Role(defaultRole)) {
+		return fmt.Errorf("default_role: invalid role %q", defaultRole)
+	}
+	return nil
+}
+
+// ValidAction reports whether action is a known action
+func ValidAction(action Action) bool {
+	return action == ActionPull || action == ActionPush
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored in ctx, if any
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
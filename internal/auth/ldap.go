@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"docker-registry-manager/internal/config"
+)
+
+// LDAPConn is the subset of an LDAP connection used by LDAPAuthenticator.
+// *ldap.Conn satisfies it; tests can supply an in-process stand-in.
+type LDAPConn interface {
+	StartTLS(config *tls.Config) error
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// LDAPDialer opens a connection to the directory
+type LDAPDialer func() (LDAPConn, error)
+
+// LDAPAuthenticator authenticates users with a search-then-bind against an
+// LDAP directory and maps their groups to registry roles
+type LDAPAuthenticator struct {
+	config    config.LDAPConfig
+	tlsConfig *tls.Config
+	dial      LDAPDialer
+}
+
+// NewLDAPAuthenticator creates an authenticator from config. A nil dialer
+// connects to cfg.URL.
+func NewLDAPAuthenticator(cfg config.LDAPConfig, dialer LDAPDialer) (*LDAPAuthenticator, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("ldap url is required")
+	}
+	if cfg.BaseDN == "" {
+		return nil, fmt.Errorf("ldap base_dn is required")
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.GroupFilter == "" {
+		cfg.GroupFilter = "(member=%s)"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "cn"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	if err := validateRoleMapping(cfg.RoleMapping, cfg.DefaultRole); err != nil {
+		return nil, fmt.Errorf("ldap: %w", err)
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ldap ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ldap ca_file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	a := &LDAPAuthenticator{
+		config:    cfg,
+		tlsConfig: tlsConfig,
+		dial:      dialer,
+	}
+	if a.dial == nil {
+		a.dial = a.dialURL
+	}
+
+	return a, nil
+}
+
+// Authenticate implements Authenticator
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*Identity, error) {
+	// An empty password would be an unauthenticated bind, which most
+	// servers accept for any DN
+	if username == "" || password == "" || strings.HasPrefix(password, TokenPrefix) {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, fmt.Errorf("ldap connect: %w", err)
+	}
+	defer conn.Close()
+
+	if a.config.StartTLS {
+		if err := conn.StartTLS(a.tlsConfig); err != nil {
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	userDN, memberOf, err := a.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap user bind: %w", err)
+	}
+
+	// Group lookups run with the service account's privileges
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	groups, err := a.findGroups(conn, userDN)
+	if err != nil {
+		return nil, err
+	}
+	groups = append(groups, memberOf...)
+
+	role, ok := mapGroupsToRole(groups, a.config.RoleMapping, a.config.DefaultRole)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Username: username,
+		Role:     role,
+	}, nil
+}
+
+// dialURL connects to the configured server
+func (a *LDAPAuthenticator) dialURL() (LDAPConn, error) {
+	conn, err := ldap.DialURL(a.config.URL, ldap.DialWithTLSConfig(a.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.config.Timeout)
+	return conn, nil
+}
+
+// bindService binds as the configured service account, if any
+func (a *LDAPAuthenticator) bindService(conn LDAPConn) error {
+	if a.config.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+		return fmt.Errorf("ldap service bind: %w", err)
+	}
+	return nil
+}
+
+// findUser returns the DN and memberOf groups of the single matching user
+func (a *LDAPAuthenticator) findUser(conn LDAPConn, username string) (string, []string, error) {
+	request := ldap.NewSearchRequest(
+		a.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.config.Timeout.Seconds()), false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", "memberOf"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return "", nil, ErrInvalidCredentials
+		}
+		return "", nil, fmt.Errorf("ldap user search: %w", err)
+	}
+
+	if len(result.Entries) != 1 {
+		return "", nil, ErrInvalidCredentials
+	}
+
+	entry := result.Entries[0]
+	var groups []string
+	for _, dn := range entry.GetAttributeValues("memberOf") {
+		groups = append(groups, groupName(dn))
+	}
+
+	return entry.DN, groups, nil
+}
+
+// findGroups returns the names of groups that list userDN as a member
+func (a *LDAPAuthenticator) findGroups(conn LDAPConn, userDN string) ([]string, error) {
+	if a.config.GroupBaseDN == "" {
+		return nil, nil
+	}
+
+	request := ldap.NewSearchRequest(
+		a.config.GroupBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(a.config.Timeout.Seconds()), false,
+		fmt.Sprintf(a.config.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{a.config.GroupAttribute},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, fmt.Errorf("ldap group search: %w", err)
+	}
+
+	var groups []string
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.GetAttributeValues(a.config.GroupAttribute)...)
+	}
+	return groups, nil
+}
+
+// groupName returns the first RDN value of a group DN, e.g. "devs" for
+// "cn=devs,ou=groups,dc=example,dc=com"
+func groupName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
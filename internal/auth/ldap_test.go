@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"docker-registry-manager/internal/config"
+)
+
+// fakeDirectory is an in-process LDAP directory. It understands the
+// equality and presence filters the authenticator sends.
+type fakeDirectory struct {
+	passwords map[string]string
+	entries   []*ldap.Entry
+
+	// filters records every search filter, in order
+	filters []string
+	// binds records every DN bound as, in order
+	binds []string
+}
+
+func newFakeDirectory() *fakeDirectory {
+	d := &fakeDirectory{passwords: map[string]string{
+		"cn=registry,dc=example,dc=com": "service-secret",
+	}}
+	d.addUser("alice", "alice-secret", "cn=readers,ou=groups,dc=example,dc=com")
+	d.addUser("bob", "bob-secret")
+	d.addGroup("devs", "uid=alice,ou=people,dc=example,dc=com")
+	return d
+}
+
+// addUser adds a person with the given memberOf groups
+func (d *fakeDirectory) addUser(uid, password string, memberOf ...string) {
+	dn := "uid=" + uid + ",ou=people,dc=example,dc=com"
+	d.passwords[dn] = password
+	d.entries = append(d.entries, ldap.NewEntry(dn, map[string][]string{
+		"uid":      {uid},
+		"memberOf": memberOf,
+	}))
+}
+
+// addGroup adds a group listing members by DN
+func (d *fakeDirectory) addGroup(cn string, members ...string) {
+	d.entries = append(d.entries, ldap.NewEntry("cn="+cn+",ou=groups,dc=example,dc=com", map[string][]string{
+		"cn":     {cn},
+		"member": members,
+	}))
+}
+
+func (d *fakeDirectory) dial() (LDAPConn, error) {
+	return &fakeConn{directory: d}, nil
+}
+
+// fakeConn is a connection to a fakeDirectory
+type fakeConn struct {
+	directory *fakeDirectory
+}
+
+func (c *fakeConn) StartTLS(*tls.Config) error { return nil }
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Bind(username, password string) error {
+	c.directory.binds = append(c.directory.binds, username)
+	if want, ok := c.directory.passwords[username]; !ok || want != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (c *fakeConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.directory.filters = append(c.directory.filters, request.Filter)
+	filter, err := ldap.CompileFilter(request.Filter)
+	if err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultFilterError, err)
+	}
+
+	var attribute, value string
+	switch filter.Tag {
+	case ldap.FilterEqualityMatch:
+		attribute, value = filter.Children[0].Value.(string), filter.Children[1].Value.(string)
+	case ldap.FilterPresent:
+		attribute = filter.Value.(string)
+	default:
+		panic(fmt.Sprintf("unsupported filter %s", request.Filter))
+	}
+
+	result := &ldap.SearchResult{}
+	for _, entry := range c.directory.entries {
+		if !strings.HasSuffix(entry.DN, ","+request.BaseDN) || !matches(entry, attribute, value) {
+			continue
+		}
+		if request.SizeLimit > 0 && len(result.Entries) == request.SizeLimit {
+			return nil, ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("size limit exceeded"))
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, nil
+}
+
+// matches reports whether entry has attribute equal to value, or has it at
+// all when value is empty
+func matches(entry *ldap.Entry, attribute, value string) bool {
+	for _, v := range entry.GetAttributeValues(attribute) {
+		if value == "" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newLDAPTest returns an authenticator backed by a fake directory
+func newLDAPTest(t *testing.T, cfg config.LDAPConfig) (*fakeDirectory, *LDAPAuthenticator) {
+	t.Helper()
+	directory := newFakeDirectory()
+	cfg.URL = "ldap://ldap.example.com"
+	cfg.BindDN = "cn=registry,dc=example,dc=com"
+	cfg.BindPassword = "service-secret"
+	cfg.BaseDN = "ou=people,dc=example,dc=com"
+	cfg.GroupBaseDN = "ou=groups,dc=example,dc=com"
+	authenticator, err := NewLDAPAuthenticator(cfg, directory.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return directory, authenticator
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	directory, authenticator := newLDAPTest(t, config.LDAPConfig{
+		RoleMapping: map[string]string{"readers": "reader", "devs": "developer"},
+	})
+
+	identity, err := authenticator.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Group search membership outranks the memberOf one
+	if identity.Username != "alice" || identity.Role != RoleDeveloper {
+		t.Fatalf("identity = %+v, want alice as developer", identity)
+	}
+
+	// Search as the service account, bind as the user, then back again
+	// for the group lookup
+	want := []string{
+		"cn=registry,dc=example,dc=com",
+		"uid=alice,ou=people,dc=example,dc=com",
+		"cn=registry,dc=example,dc=com",
+	}
+	if strings.Join(directory.binds, ";") != strings.Join(want, ";") {
+		t.Fatalf("binds = %q, want %q", directory.binds, want)
+	}
+}
+
+func TestLDAPWrongPassword(t *testing.T) {
+	_, authenticator := newLDAPTest(t, config.LDAPConfig{DefaultRole: "reader"})
+
+	for _, password := range []string{"wrong", "", TokenPrefix + "alice-secret"} {
+		if _, err := authenticator.Authenticate("alice", password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("password %q: got %v, want ErrInvalidCredentials", password, err)
+		}
+	}
+	if _, err := authenticator.Authenticate("carol", "carol-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unknown user: got %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLDAPServiceBindFails(t *testing.T) {
+	directory, authenticator := newLDAPTest(t, config.LDAPConfig{DefaultRole: "reader"})
+	directory.passwords["cn=registry,dc=example,dc=com"] = "rotated"
+
+	// A misconfigured service account is a backend failure, not a bad
+	// password from the user
+	_, err := authenticator.Authenticate("alice", "alice-secret")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) || !strings.Contains(err.Error(), "service bind") {
+		t.Fatalf("got %v, want a service bind error", err)
+	}
+}
+
+func TestLDAPGroupRoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		mapping  map[string]string
+		fallback string
+		want     Role
+	}{
+		{"memberOf group", "alice", map[string]string{"readers": "reader"}, "", RoleReader},
+		{"highest role wins", "alice", map[string]string{"readers": "admin", "devs": "developer"}, "", RoleAdmin},
+		{"default role", "bob", map[string]string{"devs": "developer"}, "reader", RoleReader},
+		{"no mapped group", "bob", map[string]string{"devs": "developer"}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, authenticator := newLDAPTest(t, config.LDAPConfig{RoleMapping: tt.mapping, DefaultRole: tt.fallback})
+
+			identity, err := authenticator.Authenticate(tt.username, tt.username+"-secret")
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("got %v, want ErrInvalidCredentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Role != tt.want {
+				t.Fatalf("role = %q, want %q", identity.Role, tt.want)
+			}
+		})
+	}
+}
+
+func TestLDAPFilterEscaping(t *testing.T) {
+	directory, authenticator := newLDAPTest(t, config.LDAPConfig{DefaultRole: "reader"})
+
+	// Unescaped, this would search for (uid=*)(uid=*) and match everyone
+	if _, err := authenticator.Authenticate("*)(uid=*", "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+	if want := `(uid=\2a\29\28uid=\2a)`; len(directory.filters) != 1 || directory.filters[0] != want {
+		t.Fatalf("filters = %q, want [%s]", directory.filters, want)
+	}
+	for _, dn := range directory.binds {
+		if dn != "cn=registry,dc=example,dc=com" {
+			t.Fatalf("bound as %s after a search that should match no one", dn)
+		}
+	}
+}
+
+func TestLDAPUnreachable(t *testing.T) {
+	unreachable, err := NewLDAPAuthenticator(config.LDAPConfig{
+		URL:         "ldap://ldap.example.com",
+		BaseDN:      "dc=example,dc=com",
+		DefaultRole: "reader",
+	}, func() (LDAPConn, error) {
+		return nil, errors.New("connection refused")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := Chain{unreachable, &StaticAuthenticator{Username: "admin", Password: "admin-secret"}}
+
+	// The next authenticator still signs its users in
+	identity, err := chain.Authenticate("admin", "admin-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Role != RoleAdmin {
+		t.Fatalf("identity = %+v, want admin", identity)
+	}
+
+	// Credentials no one accepts report the outage rather than a bad password
+	_, err = chain.Authenticate("alice", "alice-secret")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("got %v, want the connection error", err)
+	}
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"docker-registry-manager/internal/config"
+)
+
+// pendingLoginTTL bounds how long a user may take at the identity provider
+const pendingLoginTTL = 10 * time.Minute
+
+// maxPendingLogins bounds the logins awaiting a callback, since anyone can
+// start one; past it the oldest is dropped and that user has to start over
+const maxPendingLogins = 1000
+
+// ErrUnknownLoginState is returned when a callback does not match a login we started
+var ErrUnknownLoginState = errors.New("unknown or expired login state")
+
+// pendingLogin holds the per-login secrets between redirect and callback
+type pendingLogin struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// OIDCAuthenticator runs the authorization code flow with PKCE against an
+// OpenID Connect provider. Discovery happens on first use so the registry
+// starts even if the provider is briefly unreachable.
+type OIDCAuthenticator struct {
+	config config.OIDCConfig
+
+	mutex    sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	pending  map[string]*pendingLogin
+}
+
+// NewOIDCAuthenticator validates cfg and returns an authenticator
+func NewOIDCAuthenticator(cfg config.OIDCConfig) (*OIDCAuthenticator, error) {
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("oidc issuer is required")
+	}
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("oidc client_id is required")
+	}
+	if cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc redirect_url is required")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if err := validateRoleMapping(cfg.RoleMapping, cfg.DefaultRole); err != nil {
+		return nil, fmt.Errorf("oidc: %w", err)
+	}
+
+	return &OIDCAuthenticator{
+		config:  cfg,
+		pending: make(map[string]*pendingLogin),
+	}, nil
+}
+
+// AuthCodeURL starts a login and returns the provider URL to redirect to
+func (o *OIDCAuthenticator) AuthCodeURL(ctx context.Context) (string, error) {
+	oauth2Config, _, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	stateBytes, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	nonceBytes, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+
+	state := hex.EncodeToString(stateBytes)
+	login := &pendingLogin{
+		nonce:     hex.EncodeToString(nonceBytes),
+		verifier:  oauth2.GenerateVerifier(),
+		expiresAt: time.Now().Add(pendingLoginTTL),
+	}
+
+	o.mutex.Lock()
+	o.prunePending(time.Now())
+	o.pending[state] = login
+	o.mutex.Unlock()
+
+	return oauth2Config.AuthCodeURL(state,
+		oidc.Nonce(login.nonce),
+		oauth2.S256ChallengeOption(login.verifier),
+	), nil
+}
+
+// Exchange completes a login from the provider's callback parameters
+func (o *OIDCAuthenticator) Exchange(ctx context.Context, state, code string) (*Identity, error) {
+	o.mutex.Lock()
+	login, exists := o.pending[state]
+	delete(o.pending, state)
+	o.mutex.Unlock()
+
+	if !exists || time.Now().After(login.expiresAt) {
+		return nil, ErrUnknownLoginState
+	}
+
+	oauth2Config, verifier, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc code exchange: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("oidc token response has no id_token")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("oidc id_token verification: %w", err)
+	}
+	if idToken.Nonce != login.nonce {
+		return nil, fmt.Errorf("oidc id_token nonce mismatch")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("oidc claims: %w", err)
+	}
+
+	username := claimString(claims, o.config.UsernameClaim)
+	if username == "" {
+		username = claimString(claims, "email")
+	}
+	if username == "" {
+		username = idToken.Subject
+	}
+
+	role, ok := mapGroupsToRole(claimStrings(claims, o.config.GroupsClaim), o.config.RoleMapping, o.config.DefaultRole)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Username: username,
+		Role:     role,
+	}, nil
+}
+
+// prunePending drops expired logins, then the oldest ones until there is
+// room for another. The caller holds the mutex.
+func (o *OIDCAuthenticator) prunePending(now time.Time) {
+	for key, p := range o.pending {
+		if now.After(p.expiresAt) {
+			delete(o.pending, key)
+		}
+	}
+	for len(o.pending) >= maxPendingLogins {
+		var oldest string
+		for key, p := range o.pending {
+			if oldest == "" || p.expiresAt.Before(o.pending[oldest].expiresAt) {
+				oldest = key
+			}
+		}
+		delete(o.pending, oldest)
+	}
+}
+
+// discover fetches provider metadata once and caches the derived clients
+func (o *OIDCAuthenticator) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.oauth2 != nil {
+		return o.oauth2, o.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, o.config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	o.oauth2 = &oauth2.Config{
+		ClientID:     o.config.ClientID,
+		ClientSecret: o.config.ClientSecret,
+		RedirectURL:  o.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       o.config.Scopes,
+	}
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.config.ClientID})
+
+	return o.oauth2, o.verifier, nil
+}
+
+// claimString returns a string claim, or "" if absent
+func claimString(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimStrings returns a claim that may be a single string or a list
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-registry-manager/internal/auth/oidctest"
+	"docker-registry-manager/internal/config"
+)
+
+// newOIDCTest starts a provider and an authenticator using it
+func newOIDCTest(t *testing.T) (*oidctest.Provider, *OIDCAuthenticator) {
+	t.Helper()
+	provider := oidctest.NewProvider(t, "registry")
+	authenticator, err := NewOIDCAuthenticator(config.OIDCConfig{
+		Issuer:      provider.Issuer,
+		ClientID:    "registry",
+		RedirectURL: "https://registry.example.com/auth/oidc/callback",
+		RoleMapping: map[string]string{"platform": "admin", "dev": "developer"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, authenticator
+}
+
+// login starts a login and signs in at the provider, returning the state
+// and code of the callback
+func login(t *testing.T, provider *oidctest.Provider, authenticator *OIDCAuthenticator) (string, string) {
+	t.Helper()
+	authURL, err := authenticator.AuthCodeURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := provider.Authorize(t, authURL)
+	if !strings.HasPrefix(callback.String(), "https://registry.example.com/auth/oidc/callback?") {
+		t.Fatalf("callback = %s", callback)
+	}
+	return callback.Query().Get("state"), callback.Query().Get("code")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider, authenticator := newOIDCTest(t)
+	provider.Claims["preferred_username"] = "alice"
+	provider.Claims["groups"] = []string{"dev", "platform"}
+
+	state, code := login(t, provider, authenticator)
+	identity, err := authenticator.Exchange(context.Background(), state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "alice" || identity.Role != RoleAdmin {
+		t.Fatalf("identity = %+v, want alice as admin", identity)
+	}
+
+	// The identity signs the browser in
+	sessions := NewSessionStore(time.Hour)
+	id, err := sessions.Create(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sessions.Get(id); got == nil || got.Username != "alice" {
+		t.Fatalf("session identity = %+v, want alice", got)
+	}
+
+	// A state is good for one callback
+	if _, err := authenticator.Exchange(context.Background(), state, code); !errors.Is(err, ErrUnknownLoginState) {
+		t.Fatalf("replayed callback: got %v, want ErrUnknownLoginState", err)
+	}
+}
+
+func TestOIDCUnmappedGroups(t *testing.T) {
+	provider, authenticator := newOIDCTest(t)
+	provider.Claims["groups"] = []string{"sales"}
+
+	state, code := login(t, provider, authenticator)
+	if _, err := authenticator.Exchange(context.Background(), state, code); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unmapped groups: got %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestOIDCStateMismatch(t *testing.T) {
+	provider, authenticator := newOIDCTest(t)
+
+	_, code := login(t, provider, authenticator)
+	if _, err := authenticator.Exchange(context.Background(), "forged", code); !errors.Is(err, ErrUnknownLoginState) {
+		t.Fatalf("forged state: got %v, want ErrUnknownLoginState", err)
+	}
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+	provider, authenticator := newOIDCTest(t)
+	provider.Nonce = "replayed-token-nonce"
+
+	state, code := login(t, provider, authenticator)
+	_, err := authenticator.Exchange(context.Background(), state, code)
+	if err == nil || !strings.Contains(err.Error(), "nonce mismatch") {
+		t.Fatalf("wrong nonce: got %v, want a nonce mismatch", err)
+	}
+}
+
+func TestOIDCExpiredLogin(t *testing.T) {
+	provider, authenticator := newOIDCTest(t)
+
+	state, code := login(t, provider, authenticator)
+	authenticator.mutex.Lock()
+	authenticator.pending[state].expiresAt = time.Now().Add(-time.Second)
+	authenticator.mutex.Unlock()
+
+	if _, err := authenticator.Exchange(context.Background(), state, code); !errors.Is(err, ErrUnknownLoginState) {
+		t.Fatalf("expired login: got %v, want ErrUnknownLoginState", err)
+	}
+}
+
+func TestOIDCExpiredIDToken(t *testing.T) {
+	provider, authenticator := newOIDCTest(t)
+	provider.TokenTTL = -time.Minute
+
+	state, code := login(t, provider, authenticator)
+	_, err := authenticator.Exchange(context.Background(), state, code)
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("expired id_token: got %v, want an expiry error", err)
+	}
+}
+
+func TestOIDCPendingLimit(t *testing.T) {
+	provider, authenticator := newOIDCTest(t)
+
+	first, code := login(t, provider, authenticator)
+	for i := 0; i < maxPendingLogins+10; i++ {
+		if _, err := authenticator.AuthCodeURL(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authenticator.mutex.Lock()
+	pending := len(authenticator.pending)
+	authenticator.mutex.Unlock()
+	if pending > maxPendingLogins {
+		t.Fatalf("pending logins = %d, want at most %d", pending, maxPendingLogins)
+	}
+
+	// The oldest login made room for the newer ones
+	if _, err := authenticator.Exchange(context.Background(), first, code); !errors.Is(err, ErrUnknownLoginState) {
+		t.Fatalf("evicted login: got %v, want ErrUnknownLoginState", err)
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// session is a signed-in web user
+type session struct {
+	identity  *Identity
+	expiresAt time.Time
+}
+
+// SessionStore keeps web sessions in memory. Sessions do not survive a
+// restart; users simply sign in again.
+type SessionStore struct {
+	ttl      time.Duration
+	sessions map[string]*session
+	mutex    sync.Mutex
+}
+
+// NewSessionStore creates a session store whose sessions last for ttl
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{
+		ttl:      ttl,
+		sessions: make(map[string]*session),
+	}
+}
+
+// TTL returns the lifetime of new sessions
+func (s *SessionStore) TTL() time.Duration {
+	return s.ttl
+}
+
+// Create starts a session for identity and returns its ID
+func (s *SessionStore) Create(identity *Identity) (string, error) {
+	b, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Drop expired sessions so the map does not grow without bound
+	for key, sess := range s.sessions {
+		if now.After(sess.expiresAt) {
+			delete(s.sessions, key)
+		}
+	}
+
+	s.sessions[id] = &session{
+		identity:  identity,
+		expiresAt: now.Add(s.ttl),
+	}
+	return id, nil
+}
+
+// Get returns the identity of a live session, or nil
+func (s *SessionStore) Get(id string) *Identity {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, exists := s.sessions[id]
+	if !exists {
+		return nil
+	}
+	if time.Now().After(sess.expiresAt) {
+		delete(s.sessions, id)
+		return nil
+	}
+	return sess.identity
+}
+
+// Delete ends a session
+func (s *SessionStore) Delete(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, id)
+}
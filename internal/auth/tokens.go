@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TokenPrefix marks a password as an access token
+	TokenPrefix = "drm_"
+	// RobotPrefix is prepended to robot account names to form the login username
+	RobotPrefix = "robot$"
+
+	// lastUsedPersistInterval limits how often last-used updates hit the disk
+	lastUsedPersistInterval = time.Minute
+)
+
+// ErrTokenNotFound is returned when a token ID does not exist
+var ErrTokenNotFound = errors.New("token not found")
+
+// Token is a robot account or personal access token
+type Token struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Robot       bool       `json:"robot"`
+	Owner       string     `json:"owner"`
+	Role        Role       `json:"role"`
+	Scopes      []Scope    `json:"scopes"`
+	Hash        string     `json:"hash"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Username returns the login name to use with the token
+func (t *Token) Username() string {
+	if t.Robot {
+		return RobotPrefix + t.Name
+	}
+	return t.Owner
+}
+
+// Expired reports whether the token is past its expiry
+func (t *Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+}
+
+// TokenRequest describes a token to create
+type TokenRequest struct {
+	Name        string
+	Description string
+	Robot       bool
+	Owner       string
+	Role        Role
+	Scopes      []Scope
+	ExpiresAt   *time.Time
+}
+
+// TokenStore keeps tokens in a JSON file. Only the SHA-256 of each secret is
+// stored; secrets are random, so a slow hash adds nothing.
+type TokenStore struct {
+	path      string
+	tokens    map[string]*Token
+	persisted map[string]time.Time
+	mutex     sync.RWMutex
+}
+
+// NewTokenStore loads tokens from filename, creating the file on first write
+func NewTokenStore(filename string) (*TokenStore, error) {
+	ts := &TokenStore{
+		path:      filename,
+		tokens:    make(map[string]*Token),
+		persisted: make(map[string]time.Time),
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ts, nil
+		}
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	var tokens []*Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse token file: %w", err)
+	}
+
+	for _, t := range tokens {
+		ts.tokens[t.ID] = t
+	}
+
+	return ts, nil
+}
+
+// List returns all tokens sorted by creation time
+func (ts *TokenStore) List() []Token {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	tokens := make([]Token, 0, len(ts.tokens))
+	for _, t := range ts.tokens {
+		tokens = append(tokens, *t)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return tokens
+}
+
+// Create stores a new token and returns it along with its secret. The secret
+// is not recoverable afterwards.
+func (ts *TokenStore) Create(req TokenRequest) (Token, string, error) {
+	if req.Name == "" {
+		return Token{}, "", fmt.Errorf("token name is required")
+	}
+	if strings.ContainsAny(req.Name, "$:/ ") {
+		return Token{}, "", fmt.Errorf("token name contains invalid characters")
+	}
+	if !ValidRole(req.Role) {
+		return Token{}, "", fmt.Errorf("invalid role %q", req.Role)
+	}
+	for _, scope := range req.Scopes {
+		if _, err := path.Match(scope.Repository, ""); err != nil {
+			return Token{}, "", fmt.Errorf("invalid repository pattern %q", scope.Repository)
+		}
+		for _, action := range scope.Actions {
+			if !ValidAction(action) {
+				return Token{}, "", fmt.Errorf("invalid action %q", action)
+			}
+		}
+	}
+
+	idBytes, err := randomBytes(8)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secretBytes, err := randomBytes(24)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret := TokenPrefix + hex.EncodeToString(secretBytes)
+
+	scopes := req.Scopes
+	if scopes == nil {
+		scopes = []Scope{}
+	}
+
+	token := &Token{
+		ID:          hex.EncodeToString(idBytes),
+		Name:        req.Name,
+		Description: req.Description,
+		Robot:       req.Robot,
+		Owner:       req.Owner,
+		Role:        req.Role,
+		Scopes:      scopes,
+		Hash:        hashSecret(secret),
+		CreatedAt:   time.Now().UTC(),
+		ExpiresAt:   req.ExpiresAt,
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if token.Robot {
+		for _, t := range ts.tokens {
+			if t.Robot && t.Name == token.Name {
+				return Token{}, "", fmt.Errorf("robot account %q already exists", token.Name)
+			}
+		}
+	}
+
+	ts.tokens[token.ID] = token
+	if err := ts.save(); err != nil {
+		delete(ts.tokens, token.ID)
+		return Token{}, "", err
+	}
+
+	return *token, secret, nil
+}
+
+// Revoke deletes a token
+func (ts *TokenStore) Revoke(id string) error {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	token, exists := ts.tokens[id]
+	if !exists {
+		return ErrTokenNotFound
+	}
+
+	delete(ts.tokens, id)
+	if err := ts.save(); err != nil {
+		ts.tokens[id] = token
+		return err
+	}
+
+	delete(ts.persisted, id)
+	return nil
+}
+
+// Authenticate implements Authenticator. The password must be a token secret
+// and the username the token's login name.
+func (ts *TokenStore) Authenticate(username, password string) (*Identity, error) {
+	if !strings.HasPrefix(password, TokenPrefix) {
+		return nil, ErrInvalidCredentials
+	}
+
+	hash := hashSecret(password)
+	now := time.Now().UTC()
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	var token *Token
+	for _, t := range ts.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+			token = t
+			break
+		}
+	}
+
+	if token == nil || token.Username() != username || token.Expired(now) {
+		return nil, ErrInvalidCredentials
+	}
+
+	token.LastUsedAt = &now
+	if now.Sub(ts.persisted[token.ID]) >= lastUsedPersistInterval {
+		if err := ts.save(); err == nil {
+			ts.persisted[token.ID] = now
+		}
+	}
+
+	return &Identity{
+		Username: username,
+		Role:     token.Role,
+		Scopes:   token.Scopes,
+		TokenID:  token.ID,
+	}, nil
+}
+
+// save writes all tokens to disk. Callers must hold the write lock.
+func (ts *TokenStore) save() error {
+	tokens := make([]*Token, 0, len(ts.tokens))
+	for _, t := range ts.tokens {
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ts.path), 0700); err != nil {
+		return fmt.Errorf("failed to create token directory: %w", err)
+	}
+
+	tmpPath := ts.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+
+	return os.Rename(tmpPath, ts.path)
+}
+
+// hashSecret returns the hex SHA-256 of a token secret
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomBytes returns n cryptographically random bytes
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTokenStore(t *testing.T) *TokenStore {
+	t.Helper()
+	ts, err := NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// storedTokens reads the token file as written
+func storedTokens(t *testing.T, ts *TokenStore) ([]byte, []Token) {
+	t.Helper()
+	data, err := os.ReadFile(ts.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		t.Fatal(err)
+	}
+	return data, tokens
+}
+
+func TestTokenScopes(t *testing.T) {
+	ts := newTokenStore(t)
+	_, secret, err := ts.Create(TokenRequest{
+		Name:   "ci",
+		Robot:  true,
+		Role:   RoleDeveloper,
+		Scopes: []Scope{{Repository: "team/*", Actions: []Action{ActionPull, ActionPush}}, {Repository: "base", Actions: []Action{ActionPull}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := ts.Authenticate("robot$ci", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		repository string
+		action     Action
+		want       bool
+	}{
+		{"team/app", ActionPush, true},
+		{"team/app", ActionPull, true},
+		{"base", ActionPull, true},
+		{"base", ActionPush, false},
+		{"other/app", ActionPull, false},
+		{"team/app/nested", ActionPull, false},
+	}
+	for _, tt := range tests {
+		if got := identity.Can(tt.repository, tt.action); got != tt.want {
+			t.Errorf("Can(%s, %s) = %v, want %v", tt.repository, tt.action, got, tt.want)
+		}
+	}
+	if identity.IsAdmin() {
+		t.Error("a scoped token must not reach the admin API")
+	}
+
+	// Scopes never grant more than the role
+	_, secret, err = ts.Create(TokenRequest{
+		Name:   "reader",
+		Owner:  "alice",
+		Role:   RoleReader,
+		Scopes: []Scope{{Repository: "*", Actions: []Action{ActionPull, ActionPush}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err = ts.Authenticate("alice", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Can("team/app", ActionPush) {
+		t.Error("reader token allowed to push")
+	}
+}
+
+func TestTokenRejected(t *testing.T) {
+	ts := newTokenStore(t)
+	past := time.Now().Add(-time.Minute)
+	_, expired, err := ts.Create(TokenRequest{Name: "old", Owner: "alice", Role: RoleReader, ExpiresAt: &past})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedToken, revoked, err := ts.Create(TokenRequest{Name: "laptop", Owner: "alice", Role: RoleReader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Revoke(revokedToken.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, valid, err := ts.Create(TokenRequest{Name: "desktop", Owner: "alice", Role: RoleReader})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"expired", "alice", expired},
+		{"revoked", "alice", revoked},
+		{"other user", "bob", valid},
+		{"robot name", "robot$desktop", valid},
+		{"not a token", "alice", "hunter2"},
+		{"wrong secret", "alice", valid[:len(valid)-1] + "x"},
+	}
+	for _, tt := range tests {
+		if _, err := ts.Authenticate(tt.username, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: got %v, want ErrInvalidCredentials", tt.name, err)
+		}
+	}
+
+	if _, err := ts.Authenticate("alice", valid); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	if err := ts.Revoke(revokedToken.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("second revoke: got %v, want ErrTokenNotFound", err)
+	}
+
+	// Revocation survives a restart
+	reloaded, err := NewTokenStore(ts.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Authenticate("alice", revoked); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("revoked token after reload: got %v", err)
+	}
+}
+
+func TestTokenFileHoldsOnlyHashes(t *testing.T) {
+	ts := newTokenStore(t)
+	token, secret, err := ts.Create(TokenRequest{Name: "ci", Robot: true, Role: RoleDeveloper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, TokenPrefix) {
+		t.Fatalf("secret %q lacks the %s prefix", secret, TokenPrefix)
+	}
+
+	data, tokens := storedTokens(t, ts)
+	if strings.Contains(string(data), strings.TrimPrefix(secret, TokenPrefix)) {
+		t.Fatal("token file contains the secret")
+	}
+	if len(tokens) != 1 || tokens[0].Hash != hashSecret(secret) || tokens[0].ID != token.ID {
+		t.Fatalf("stored tokens = %+v, want one with the SHA-256 of the secret", tokens)
+	}
+	if info, err := os.Stat(ts.path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("token file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestTokenLastUsedThrottled(t *testing.T) {
+	ts := newTokenStore(t)
+	token, secret, err := ts.Create(TokenRequest{Name: "ci", Robot: true, Role: RoleDeveloper})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ts.Authenticate("robot$ci", secret); err != nil {
+		t.Fatal(err)
+	}
+	_, tokens := storedTokens(t, ts)
+	first := tokens[0].LastUsedAt
+	if first == nil {
+		t.Fatal("first use not persisted")
+	}
+
+	// Uses within the interval update memory only
+	if _, err := ts.Authenticate("robot$ci", secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, tokens = storedTokens(t, ts); !tokens[0].LastUsedAt.Equal(*first) {
+		t.Fatalf("last use persisted again after %s", tokens[0].LastUsedAt.Sub(*first))
+	}
+	if listed := ts.List()[0].LastUsedAt; !listed.After(*first) {
+		t.Fatalf("in-memory last use %s not updated", listed)
+	}
+
+	// Once the interval has passed the next use is written
+	ts.mutex.Lock()
+	ts.persisted[token.ID] = ts.persisted[token.ID].Add(-lastUsedPersistInterval)
+	ts.mutex.Unlock()
+	if _, err := ts.Authenticate("robot$ci", secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, tokens = storedTokens(t, ts); !tokens[0].LastUsedAt.After(*first) {
+		t.Fatal("last use not persisted after the interval")
+	}
+}
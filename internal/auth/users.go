@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrUserNotFound is returned when a local user does not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when adding a local user that already exists
+	ErrUserExists = errors.New("user already exists")
+)
+
+// dummyHash is compared against for unknown users, so a login takes as long
+// whether or not the user exists. It has the cost hashPassword uses.
+var dummyHash = []byte("$2a$10$m9jgpgn3Xpf38ZRFiZ804OV6kRIVY.m6oEnhS7gbHDqj0dVawpk5K")
+
+// User is a local account managed with the user command
+type User struct {
+	Username  string    `json:"username"`
+	Role      Role      `json:"role"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserStore keeps local users in a JSON file with bcrypt password hashes.
+// The file is re-read when it changes, so users managed offline take effect
+// on a running server without a restart.
+type UserStore struct {
+	path    string
+	users   map[string]*User
+	modTime time.Time
+	mutex   sync.RWMutex
+}
+
+// NewUserStore loads users from filename, creating the file on first write
+func NewUserStore(filename string) (*UserStore, error) {
+	us := &UserStore{path: filename}
+	if err := us.load(); err != nil {
+		return nil, err
+	}
+	return us, nil
+}
+
+// List returns all users sorted by name
+func (us *UserStore) List() []User {
+	us.refresh()
+	us.mutex.RLock()
+	defer us.mutex.RUnlock()
+
+	users := make([]User, 0, len(us.users))
+	for _, u := range us.users {
+		users = append(users, *u)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	return users
+}
+
+// Add creates a user
+func (us *UserStore) Add(username, password string, role Role) error {
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if strings.ContainsAny(username, ":$/ ") || strings.HasPrefix(username, RobotPrefix) {
+		return fmt.Errorf("username contains invalid characters")
+	}
+	if !ValidRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	us.refresh()
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	if _, exists := us.users[username]; exists {
+		return ErrUserExists
+	}
+
+	now := time.Now().UTC()
+	us.users[username] = &User{
+		Username:  username,
+		Role:      role,
+		Hash:      hash,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := us.save(); err != nil {
+		delete(us.users, username)
+		return err
+	}
+
+	return nil
+}
+
+// Remove deletes a user
+func (us *UserStore) Remove(username string) error {
+	us.refresh()
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	user, exists := us.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	delete(us.users, username)
+	if err := us.save(); err != nil {
+		us.users[username] = user
+		return err
+	}
+
+	return nil
+}
+
+// SetPassword replaces a user's password
+func (us *UserStore) SetPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	us.refresh()
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	user, exists := us.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	previous := *user
+	user.Hash = hash
+	user.UpdatedAt = time.Now().UTC()
+	if err := us.save(); err != nil {
+		*user = previous
+		return err
+	}
+
+	return nil
+}
+
+// Authenticate implements Authenticator
+func (us *UserStore) Authenticate(username, password string) (*Identity, error) {
+	us.refresh()
+	us.mutex.RLock()
+	user, exists := us.users[username]
+	us.mutex.RUnlock()
+
+	if !exists {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Username: username,
+		Role:     user.Role,
+	}, nil
+}
+
+// refresh reloads the file if it changed since it was last read. A file
+// that fails to load leaves the current users in place.
+func (us *UserStore) refresh() {
+	info, err := os.Stat(us.path)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	us.mutex.RLock()
+	var modTime time.Time
+	if info != nil {
+		modTime = info.ModTime()
+	}
+	changed := !modTime.Equal(us.modTime)
+	us.mutex.RUnlock()
+
+	if changed {
+		us.load()
+	}
+}
+
+// load reads all users from disk
+func (us *UserStore) load() error {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	info, err := os.Stat(us.path)
+	if os.IsNotExist(err) {
+		us.users = make(map[string]*User)
+		us.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read user file: %w", err)
+	}
+
+	data, err := os.ReadFile(us.path)
+	if err != nil {
+		return fmt.Errorf("failed to read user file: %w", err)
+	}
+
+	var list []*User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse user file: %w", err)
+	}
+
+	users := make(map[string]*User, len(list))
+	for _, u := range list {
+		users[u.Username] = u
+	}
+	us.users = users
+	us.modTime = info.ModTime()
+	return nil
+}
+
+// save writes all users to disk. Callers must hold the write lock.
+func (us *UserStore) save() error {
+	users := make([]*User, 0, len(us.users))
+	for _, u := range us.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(us.path), 0700); err != nil {
+		return fmt.Errorf("failed to create user directory: %w", err)
+	}
+
+	tmpPath := us.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write user file: %w", err)
+	}
+	if err := os.Rename(tmpPath, us.path); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(us.path); err == nil {
+		us.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// hashPassword returns the bcrypt hash of a password
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", fmt.Errorf("password is required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
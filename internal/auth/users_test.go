@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUserStoreAuthenticate(t *testing.T) {
+	users, err := NewUserStore(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Add("alice", "alice-secret", RoleDeveloper); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := users.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "alice" || identity.Role != RoleDeveloper {
+		t.Fatalf("identity = %+v, want alice as developer", identity)
+	}
+
+	for _, login := range [][2]string{{"alice", "wrong"}, {"bob", "alice-secret"}} {
+		if _, err := users.Authenticate(login[0], login[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("%s/%s: got %v, want ErrInvalidCredentials", login[0], login[1], err)
+		}
+	}
+}
+
+func TestDummyHashCost(t *testing.T) {
+	// Unknown users only take as long as known ones if the dummy hash is
+	// as expensive as the real ones
+	cost, err := bcrypt.Cost(dummyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Fatalf("dummy hash cost = %d, want %d", cost, bcrypt.DefaultCost)
+	}
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -13,17 +16,74 @@ type AuthConfig struct {
 	Enabled  bool   `yaml:"enabled"`
 	Username string `yaml:"username"`
 	Password string `yaml:"password"` // 建议存储哈希值
+	// RequirePullAuth rejects anonymous pulls when enabled
+	RequirePullAuth bool `yaml:"require_pull_auth"`
+	// TokenFile stores robot accounts and personal access tokens
+	TokenFile string `yaml:"token_file"`
+	// UsersFile stores local users managed with the user command
+	UsersFile string `yaml:"users_file"`
+	// SessionTTL is how long a web session stays valid
+	SessionTTL time.Duration    `yaml:"session_ttl"`
+	LDAP       LDAPConfig       `yaml:"ldap"`
+	OIDC       OIDCConfig       `yaml:"oidc"`
+	ClientCert ClientCertConfig `yaml:"client_cert"`
+}
+
+// ClientCertConfig contains mutual TLS client certificate authentication
+// settings; it requires server.tls
+type ClientCertConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CAFile is the PEM bundle of CAs that sign client certificates
+	CAFile string `yaml:"ca_file"`
+	// Require rejects TLS connections without a valid client certificate
+	Require bool `yaml:"require"`
+	// UsernameField is cn (default), email, dns or uri
+	UsernameField string `yaml:"username_field"`
+	// RoleMapping maps subject organizational units to registry roles
+	RoleMapping map[string]string `yaml:"role_mapping"`
+	// DefaultRole is given to certificates with no mapped OU; empty rejects them
+	DefaultRole string `yaml:"default_role"`
+}
+
+// LDAPConfig contains LDAP directory authentication settings
+type LDAPConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	URL                string        `yaml:"url"`
+	StartTLS           bool          `yaml:"start_tls"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+	CAFile             string        `yaml:"ca_file"`
+	Timeout            time.Duration `yaml:"timeout"`
+	BindDN             string        `yaml:"bind_dn"`
+	BindPassword       string        `yaml:"bind_password"`
+	BaseDN             string        `yaml:"base_dn"`
+	UserFilter         string        `yaml:"user_filter"`
+	GroupBaseDN        string        `yaml:"group_base_dn"`
+	GroupFilter        string        `yaml:"group_filter"`
+	GroupAttribute     string        `yaml:"group_attribute"`
+	// RoleMapping maps group names to registry roles (admin, developer, reader)
+	RoleMapping map[string]string `yaml:"role_mapping"`
+	// DefaultRole is given to users in no mapped group; empty rejects them
+	DefaultRole string `yaml:"default_role"`
 }
 
 // Config represents the application configuration
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Storage  StorageConfig  `yaml:"storage"`
-	Registry RegistryConfig `yaml:"registry"`
-	Logging  LoggingConfig  `yaml:"logging"`
-	Web      WebConfig      `yaml:"web"`
-	CORS     CORSConfig     `yaml:"cors"`
-	Auth     AuthConfig     `yaml:"auth"` // 添加这行
+	Server        ServerConfig        `yaml:"server"`
+	Storage       StorageConfig       `yaml:"storage"`
+	Registry      RegistryConfig      `yaml:"registry"`
+	Logging       LoggingConfig       `yaml:"logging"`
+	Web           WebConfig           `yaml:"web"`
+	CORS          CORSConfig          `yaml:"cors"`
+	Auth          AuthConfig          `yaml:"auth"` // 添加这行
+	Security      SecurityConfig      `yaml:"security"`
+	Audit         AuditConfig         `yaml:"audit"`
+	Notifications NotificationsConfig `yaml:"notifications"`
+	Metrics       MetricsConfig       `yaml:"metrics"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+	Health        HealthConfig        `yaml:"health"`
+	Scrub         ScrubConfig         `yaml:"scrub"`
+	Index         IndexConfig         `yaml:"index"`
+	Quotas        []QuotaConfig       `yaml:"quotas"`
 }
 
 // ServerConfig contains server-related configuration
@@ -32,12 +92,47 @@ type ServerConfig struct {
 	Port         int           `yaml:"port"`
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
+	TLS          TLSConfig     `yaml:"tls"`
+}
+
+// TLSConfig contains HTTPS listener settings
+type TLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// MinVersion is "1.2" (default) or "1.3"
+	MinVersion string `yaml:"min_version"`
+	// CipherSuites restricts TLS 1.2 suites by Go name; empty uses Go's defaults
+	CipherSuites []string `yaml:"cipher_suites"`
+	// ReloadInterval is how often the certificate files are checked for changes
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	// RedirectAddr, if set, serves redirects from plain HTTP to HTTPS, e.g. ":80"
+	RedirectAddr string `yaml:"redirect_addr"`
 }
 
 // StorageConfig contains storage-related configuration
 type StorageConfig struct {
 	Type string `yaml:"type"`
+	// Path holds the filesystem storage, and local state such as users,
+	// tokens and the audit log with any storage type
 	Path string `yaml:"path"`
+	// Drivers holds the other keys of the storage section, each the
+	// settings of the driver of that type, e.g. storage.s3. They are passed
+	// to the driver as written, and it decodes and checks them.
+	Drivers map[string]DriverSection `yaml:",inline"`
+}
+
+// DriverSection is one storage driver's settings
+type DriverSection map[string]interface{}
+
+// DriverParameters returns the settings passed to the storage driver: its
+// section of the storage configuration, and the path
+func (s StorageConfig) DriverParameters() map[string]interface{} {
+	params := map[string]interface{}{"path": s.Path}
+	for key, value := range s.Drivers[s.Type] {
+		params[key] = value
+	}
+	return params
 }
 
 // RegistryConfig contains registry-related configuration
@@ -50,6 +145,11 @@ type RegistryConfig struct {
 type LoggingConfig struct {
 	Level  string `yaml:"level"`
 	Format string `yaml:"format"`
+	// AccessFormat writes request logs in "common" or "combined" Log Format
+	// instead of structured logrus entries
+	AccessFormat string `yaml:"access_format"`
+	// AccessLog is the file for Common/Combined lines; stdout when empty
+	AccessLog string `yaml:"access_log"`
 }
 
 // WebConfig contains web interface configuration
@@ -58,6 +158,172 @@ type WebConfig struct {
 	Title   string `yaml:"title"`
 }
 
+// OIDCConfig contains OpenID Connect single sign-on settings for the web UI
+type OIDCConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+	// UsernameClaim names the claim used as the registry username
+	UsernameClaim string `yaml:"username_claim"`
+	GroupsClaim   string `yaml:"groups_claim"`
+	// RoleMapping maps group claim values to registry roles
+	RoleMapping map[string]string `yaml:"role_mapping"`
+	DefaultRole string            `yaml:"default_role"`
+}
+
+// SecurityConfig contains brute-force protection and rate limiting settings
+type SecurityConfig struct {
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For / X-Real-IP.
+	// Only enable it behind a reverse proxy that sets these headers.
+	TrustProxyHeaders bool `yaml:"trust_proxy_headers"`
+	// TrustedProxies lists the addresses or CIDR ranges of those proxies;
+	// headers from other peers are ignored. Empty trusts loopback and
+	// private addresses.
+	TrustedProxies []string              `yaml:"trusted_proxies"`
+	Login          LoginProtectionConfig `yaml:"login"`
+	RateLimit      RateLimitConfig       `yaml:"rate_limit"`
+}
+
+// LoginProtectionConfig controls lockout after repeated authentication failures
+type LoginProtectionConfig struct {
+	// MaxFailures before a client IP or username is locked out; 0 uses the
+	// default of 5 and a negative value disables lockout
+	MaxFailures int           `yaml:"max_failures"`
+	Lockout     time.Duration `yaml:"lockout"`
+	MaxLockout  time.Duration `yaml:"max_lockout"`
+	ResetAfter  time.Duration `yaml:"reset_after"`
+}
+
+// RateLimitConfig contains the /v2 API request rate limit
+type RateLimitConfig struct {
+	Enabled           bool    `yaml:"enabled"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+	// PerRepository keys buckets by client and repository instead of client only
+	PerRepository bool `yaml:"per_repository"`
+}
+
+// AuditConfig contains audit log settings
+type AuditConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Path       string `yaml:"path"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups"`
+}
+
+// NotificationsConfig contains webhook endpoints notified of registry events
+type NotificationsConfig struct {
+	// QueuePath holds undelivered events so they survive restarts
+	QueuePath string                 `yaml:"queue_path"`
+	Endpoints []NotificationEndpoint `yaml:"endpoints"`
+}
+
+// NotificationEndpoint is one webhook receiver
+type NotificationEndpoint struct {
+	Name     string            `yaml:"name"`
+	URL      string            `yaml:"url"`
+	Disabled bool              `yaml:"disabled"`
+	Headers  map[string]string `yaml:"headers"`
+	// Secret signs each body with HMAC-SHA256 in the X-Registry-Signature header
+	Secret  string        `yaml:"secret"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Events limits delivery to these actions (push, pull, delete); empty means all
+	Events []string `yaml:"events"`
+	// Repositories limits delivery to matching repository patterns; empty means all
+	Repositories []string      `yaml:"repositories"`
+	MaxRetries   int           `yaml:"max_retries"`
+	Backoff      time.Duration `yaml:"backoff"`
+	MaxBackoff   time.Duration `yaml:"max_backoff"`
+}
+
+// MetricsConfig contains the Prometheus endpoint settings
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+	// StorageInterval is how long storage size and repository counts are cached
+	StorageInterval time.Duration `yaml:"storage_interval"`
+}
+
+// TracingConfig contains OpenTelemetry tracing settings
+type TracingConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	ServiceName string `yaml:"service_name"`
+	// Exporter is "otlp" (default) or "file"
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP/HTTP collector as host:port or URL
+	Endpoint string            `yaml:"endpoint"`
+	Insecure bool              `yaml:"insecure"`
+	Headers  map[string]string `yaml:"headers"`
+	// FilePath receives spans as JSON when Exporter is "file"
+	FilePath string `yaml:"file_path"`
+	// SampleRatio of new traces to record, 0 < ratio <= 1; defaults to 1
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// ScrubConfig contains background blob integrity verification settings
+type ScrubConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval is how often each blob is re-verified
+	Interval time.Duration `yaml:"interval"`
+	// RateMB limits how many megabytes per second are read for verification
+	RateMB int64 `yaml:"rate_mb"`
+	// StateFile records when each blob was last verified
+	StateFile string `yaml:"state_file"`
+}
+
+// IndexConfig contains metadata index settings
+type IndexConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Path is the index database file
+	Path string `yaml:"path"`
+}
+
+// QuotaConfig limits the storage of one repository, or of every repository
+// in a namespace taken together
+type QuotaConfig struct {
+	// Repository is the exact repository the quota applies to
+	Repository string `yaml:"repository"`
+	// Namespace applies the quota to the repositories under this prefix
+	Namespace string `yaml:"namespace"`
+	// MaxSizeMB limits the unique blobs referenced by manifests; 0 means no limit
+	MaxSizeMB int64 `yaml:"max_size_mb"`
+	// MaxTags limits the number of tags; 0 means no limit
+	MaxTags int `yaml:"max_tags"`
+}
+
+// Applies reports whether the quota covers repository
+func (q QuotaConfig) Applies(repository string) bool {
+	if q.Repository != "" {
+		return q.Repository == repository
+	}
+	namespace := strings.TrimSuffix(q.Namespace, "/")
+	return repository == namespace || strings.HasPrefix(repository, namespace+"/")
+}
+
+// Scope describes what the quota applies to, e.g. "namespace team"
+func (q QuotaConfig) Scope() string {
+	if q.Repository != "" {
+		return "repository " + q.Repository
+	}
+	return "namespace " + strings.TrimSuffix(q.Namespace, "/")
+}
+
+// MaxSize returns the size limit in bytes
+func (q QuotaConfig) MaxSize() int64 {
+	return q.MaxSizeMB << 20
+}
+
+// HealthConfig contains readiness probe and graceful shutdown settings
+type HealthConfig struct {
+	// MinFreeMB is the free disk space below which the registry is not ready
+	MinFreeMB int64 `yaml:"min_free_mb"`
+	// DrainDelay is how long readiness reports draining before shutdown starts
+	DrainDelay time.Duration `yaml:"drain_delay"`
+}
+
 // CORSConfig contains CORS configuration
 type CORSConfig struct {
 	Enabled        bool     `yaml:"enabled"`
@@ -66,22 +332,225 @@ type CORSConfig struct {
 	AllowedHeaders []string `yaml:"allowed_headers"`
 }
 
-// LoadConfig loads configuration from a YAML file
-func LoadConfig(filename string) (*Config, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+// LoadConfig loads configuration with this precedence, highest first:
+// overrides ("server.port=7000", from the -set flag), REGISTRY_* environment
+// variables, the YAML file, then built-in defaults. The result is validated
+// against storageTypes, and all problems are returned together in a
+// *ValidationError.
+func LoadConfig(filename string, storageTypes []string, overrides ...string) (*Config, error) {
+	var config Config
+	var problems []string
+
+	// An empty filename runs from defaults, environment and flags alone
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		// Strict decoding reports unknown keys, which are usually typos
+		if err := yaml.UnmarshalStrict(data, &config); err != nil {
+			yamlErrs, ok := yamlProblems(err)
+			if !ok {
+				return nil, fmt.Errorf("failed to parse config file: %w", err)
+			}
+			problems = append(problems, yamlErrs...)
+		}
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	if err := applyEnv(&config, os.Environ()); err != nil {
+		return nil, fmt.Errorf("invalid environment override: %w", err)
+	}
+
+	for _, override := range overrides {
+		path, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid override %q: expected key=value", override)
+		}
+		if err := Set(&config, path, value); err != nil {
+			return nil, fmt.Errorf("invalid override: %w", err)
+		}
 	}
 
+	config.applyDefaults()
+
+	var invalid *ValidationError
+	if err := config.Validate(storageTypes); errors.As(err, &invalid) {
+		problems = append(problems, invalid.Problems...)
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
 	return &config, nil
 }
 
+// applyDefaults fills in settings left unset by the file, environment and flags
+func (c *Config) applyDefaults() {
+	if c.Server.Host == "" {
+		c.Server.Host = "0.0.0.0"
+	}
+	if c.Server.Port == 0 {
+		c.Server.Port = 7000
+	}
+	if c.Server.ReadTimeout == 0 {
+		c.Server.ReadTimeout = 30 * time.Second
+	}
+	if c.Server.WriteTimeout == 0 {
+		c.Server.WriteTimeout = 30 * time.Second
+	}
+	if c.Storage.Type == "" {
+		c.Storage.Type = "filesystem"
+	}
+	if c.Storage.Path == "" {
+		c.Storage.Path = "./data"
+	}
+	if c.Registry.Realm == "" {
+		c.Registry.Realm = "Docker Registry Manager"
+	}
+	if c.Registry.Service == "" {
+		c.Registry.Service = "docker-registry-manager"
+	}
+	if c.Logging.Level == "" {
+		c.Logging.Level = "info"
+	}
+	if c.Logging.Format == "" {
+		c.Logging.Format = "text"
+	}
+	if c.Web.Title == "" {
+		c.Web.Title = "Mini Docker仓库管理器"
+	}
+}
+
 // GetAddress returns the server address
 func (c *Config) GetAddress() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
 }
+
+// GetSessionTTL returns the web session lifetime, defaulting to 12 hours
+func (c *Config) GetSessionTTL() time.Duration {
+	if c.Auth.SessionTTL > 0 {
+		return c.Auth.SessionTTL
+	}
+	return 12 * time.Hour
+}
+
+// GetLoginProtection returns login lockout settings with defaults applied
+func (c *Config) GetLoginProtection() LoginProtectionConfig {
+	login := c.Security.Login
+	if login.MaxFailures == 0 {
+		login.MaxFailures = 5
+	}
+	if login.Lockout <= 0 {
+		login.Lockout = 30 * time.Second
+	}
+	if login.MaxLockout <= 0 {
+		login.MaxLockout = time.Hour
+	}
+	if login.ResetAfter <= 0 {
+		login.ResetAfter = 15 * time.Minute
+	}
+	return login
+}
+
+// GetAuditPath returns the audit log path, defaulting to a file under the storage path
+func (c *Config) GetAuditPath() string {
+	if c.Audit.Path != "" {
+		return c.Audit.Path
+	}
+	return filepath.Join(c.Storage.Path, "audit", "audit.log")
+}
+
+// GetMetricsPath returns the Prometheus endpoint path, defaulting to /metrics
+func (c *Config) GetMetricsPath() string {
+	if c.Metrics.Path != "" {
+		return c.Metrics.Path
+	}
+	return "/metrics"
+}
+
+// GetMetricsStorageInterval returns how long storage gauges are cached, defaulting to 30s
+func (c *Config) GetMetricsStorageInterval() time.Duration {
+	if c.Metrics.StorageInterval > 0 {
+		return c.Metrics.StorageInterval
+	}
+	return 30 * time.Second
+}
+
+// GetTLSReloadInterval returns how often certificates are checked, defaulting to 30s
+func (c *Config) GetTLSReloadInterval() time.Duration {
+	if c.Server.TLS.ReloadInterval > 0 {
+		return c.Server.TLS.ReloadInterval
+	}
+	return 30 * time.Second
+}
+
+// GetHealthMinFreeBytes returns the readiness free disk threshold, defaulting to 100 MB
+func (c *Config) GetHealthMinFreeBytes() uint64 {
+	if c.Health.MinFreeMB > 0 {
+		return uint64(c.Health.MinFreeMB) << 20
+	}
+	return 100 << 20
+}
+
+// GetHealthDrainDelay returns how long to drain before shutdown, defaulting to 5s
+func (c *Config) GetHealthDrainDelay() time.Duration {
+	if c.Health.DrainDelay > 0 {
+		return c.Health.DrainDelay
+	}
+	return 5 * time.Second
+}
+
+// GetScrubInterval returns how often each blob is re-verified, defaulting to a week
+func (c *Config) GetScrubInterval() time.Duration {
+	if c.Scrub.Interval > 0 {
+		return c.Scrub.Interval
+	}
+	return 7 * 24 * time.Hour
+}
+
+// GetScrubRate returns the verification read rate in bytes per second, defaulting to 10MB/s
+func (c *Config) GetScrubRate() int64 {
+	if c.Scrub.RateMB > 0 {
+		return c.Scrub.RateMB << 20
+	}
+	return 10 << 20
+}
+
+// GetScrubStateFile returns the scrub state file path, defaulting to a file under the storage path
+func (c *Config) GetScrubStateFile() string {
+	if c.Scrub.StateFile != "" {
+		return c.Scrub.StateFile
+	}
+	return filepath.Join(c.Storage.Path, "scrub", "state.json")
+}
+
+// GetIndexPath returns the metadata index database path, defaulting to a file under the storage path
+func (c *Config) GetIndexPath() string {
+	if c.Index.Path != "" {
+		return c.Index.Path
+	}
+	return filepath.Join(c.Storage.Path, "index.db")
+}
+
+// GetNotificationQueuePath returns the outbound queue directory, defaulting to one under the storage path
+func (c *Config) GetNotificationQueuePath() string {
+	if c.Notifications.QueuePath != "" {
+		return c.Notifications.QueuePath
+	}
+	return filepath.Join(c.Storage.Path, "notifications")
+}
+
+// GetUsersFile returns the local user file path, defaulting to a file under the storage path
+func (c *Config) GetUsersFile() string {
+	if c.Auth.UsersFile != "" {
+		return c.Auth.UsersFile
+	}
+	return filepath.Join(c.Storage.Path, "auth", "users.json")
+}
+
+// GetTokenFile returns the token file path, defaulting to a file under the storage path
+func (c *Config) GetTokenFile() string {
+	if c.Auth.TokenFile != "" {
+		return c.Auth.TokenFile
+	}
+	return filepath.Join(c.Storage.Path, "auth", "tokens.json")
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var storageTypes = []string{"filesystem", "memory", "s3"}
+
+// writeConfig writes a config file into a temporary directory
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigReportsEveryProblem(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: 70000
+  prot: 8080
+storage:
+  type: ftp
+logging:
+  level: verbose
+auth:
+  password: secret
+security:
+  trusted_proxies: ["10.0.0.0/8", "proxy.local"]
+notifications:
+  endpoints:
+    - name: ci
+      url: ftp://ci.example.com/hook
+quotas:
+  - max_tags: 10
+`)
+
+	_, err := LoadConfig(path, storageTypes)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("got %v, want a *ValidationError", err)
+	}
+
+	want := []string{
+		`unknown key "prot"`,
+		"server.port must be between 1 and 65535, got 70000",
+		`storage.type must be one of "filesystem", "memory", "s3", got "ftp"`,
+		`logging.level must be one of`,
+		"auth.username is required when auth.password is set",
+		`security.trusted_proxies: "proxy.local" is not an IP address or CIDR range`,
+		`notifications.endpoints[0].url must be an http:// or https:// URL, got "ftp://ci.example.com/hook"`,
+		"quotas[0] must set exactly one of repository and namespace",
+	}
+	if len(invalid.Problems) != len(want) {
+		t.Errorf("got %d problems, want %d:\n%s", len(invalid.Problems), len(want), err)
+	}
+	for _, message := range want {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("missing %q in:\n%s", message, err)
+		}
+	}
+}
+
+func TestLoadConfigValid(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: 5000
+storage:
+  type: memory
+`)
+
+	cfg, err := LoadConfig(path, storageTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 5000 || cfg.Server.Host != "0.0.0.0" || cfg.Logging.Level != "info" {
+		t.Fatalf("got %+v, want the file's settings over defaults", cfg.Server)
+	}
+}
+
+func TestLoadConfigSyntaxError(t *testing.T) {
+	path := writeConfig(t, "server:\n  port: [\n")
+
+	_, err := LoadConfig(path, storageTypes)
+	var invalid *ValidationError
+	if err == nil || errors.As(err, &invalid) {
+		t.Fatalf("got %v, want a parse error", err)
+	}
+}
+
+func TestStorageDriverSections(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret_key")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REGISTRY_STORAGE_S3_SECRET_KEY_FILE", secret)
+	t.Setenv("REGISTRY_STORAGE_S3_ACCESS_KEY", "registry")
+	path := writeConfig(t, `
+storage:
+  type: s3
+  path: /var/lib/registry
+  s3:
+    bucket: images
+    insecure: true
+    part_size: 8
+  memory:
+    unused: true
+`)
+
+	cfg, err := LoadConfig(path, storageTypes, "storage.s3.region=eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"path":       "/var/lib/registry",
+		"bucket":     "images",
+		"insecure":   true,
+		"part_size":  8,
+		"access_key": "registry",
+		"secret_key": "s3cret",
+		"region":     "eu-west-1",
+	}
+	if got := cfg.Storage.DriverParameters(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("driver parameters = %v, want %v", got, want)
+	}
+
+	_, err = LoadConfig(writeConfig(t, "storage:\n  type: memory\n  ftp:\n    host: x\n  pth: ./data\n"), storageTypes)
+	for _, message := range []string{
+		`unknown key "storage.ftp": not a storage type (available: filesystem, memory, s3)`,
+		"cannot unmarshal !!str `./data`",
+	} {
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("missing %q in %v", message, err)
+		}
+	}
+	if err := Set(cfg, "storage.s3", "x"); err == nil {
+		t.Error("set a whole driver section")
+	}
+}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the name of every configuration environment variable
+const EnvPrefix = "REGISTRY_"
+
+// fileSuffix marks a variable whose value is read from the named file, so
+// secrets can be mounted rather than placed in the environment
+const fileSuffix = "_FILE"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides fields from environment variables named after their
+// YAML path, e.g. REGISTRY_SERVER_PORT or REGISTRY_AUTH_LDAP_BIND_PASSWORD.
+// List entries are addressed by index: REGISTRY_NOTIFICATIONS_ENDPOINTS_0_URL.
+// Any variable may instead be given as <NAME>_FILE, naming a file that holds
+// the value.
+func applyEnv(cfg *Config, environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(name, EnvPrefix) {
+			env[name] = value
+		}
+	}
+
+	return applyEnvTo(reflect.ValueOf(cfg).Elem(), strings.TrimSuffix(EnvPrefix, "_"), env)
+}
+
+// applyEnvTo sets the fields of the struct v whose variable under prefix is set
+func applyEnvTo(v reflect.Value, prefix string, env map[string]string) error {
+	var names []string
+	for i := 0; i < v.NumField(); i++ {
+		key := yamlKey(v.Type().Field(i))
+		if key == "" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		names = append(names, name)
+		field := v.Field(i)
+
+		switch {
+		case field.Kind() == reflect.Struct:
+			if err := applyEnvTo(field, name, env); err != nil {
+				return err
+			}
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+			for index := 0; hasPrefix(env, name+"_"+strconv.Itoa(index)+"_"); index++ {
+				elem := sliceElem(field, index)
+				if err := applyEnvTo(elem, name+"_"+strconv.Itoa(index), env); err != nil {
+					return err
+				}
+			}
+		default:
+			value, ok, err := lookupEnv(env, name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err := setValue(field, value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	if sections, ok := inlineSections(v); ok {
+		return applyEnvToSections(sections, prefix, names, env)
+	}
+	return nil
+}
+
+// applyEnvToSections sets entries of an inline map of sections from the
+// variables under prefix that name no field: REGISTRY_STORAGE_S3_BUCKET
+// sets bucket in section s3. Section names cannot contain underscores, and
+// the values are strings for the section's owner to convert.
+func applyEnvToSections(sections reflect.Value, prefix string, fields []string, env map[string]string) error {
+	var names []string
+	seen := make(map[string]bool)
+	for name := range env {
+		if !strings.HasPrefix(name, prefix+"_") {
+			continue
+		}
+		taken := false
+		for _, field := range fields {
+			taken = taken || name == field || strings.HasPrefix(name, field+"_")
+		}
+		if name = strings.TrimSuffix(name, fileSuffix); !taken && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		section, key, ok := strings.Cut(strings.TrimPrefix(name, prefix+"_"), "_")
+		if !ok {
+			continue
+		}
+		value, _, err := lookupEnv(env, name)
+		if err != nil {
+			return err
+		}
+		setSection(sections, strings.ToLower(section), strings.ToLower(key), value)
+	}
+	return nil
+}
+
+// lookupEnv returns the value of name, or the contents of the file named by
+// name_FILE with trailing newlines removed
+func lookupEnv(env map[string]string, name string) (string, bool, error) {
+	value, ok := env[name]
+	path, fromFile := env[name+fileSuffix]
+	if !fromFile {
+		return value, ok, nil
+	}
+	if ok {
+		return "", false, fmt.Errorf("%s and %s are both set", name, name+fileSuffix)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %w", name+fileSuffix, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// Set overrides the field at a dotted YAML path such as "server.port" or
+// "notifications.endpoints.0.url", as given to the -set flag
+func Set(cfg *Config, path, value string) error {
+	v := reflect.ValueOf(cfg).Elem()
+	parts := strings.Split(path, ".")
+
+	for i, part := range parts {
+		switch {
+		case v.Kind() == reflect.Struct:
+			field, ok := fieldByKey(v, part)
+			if sections, inline := inlineSections(v); !ok && inline {
+				if i != len(parts)-2 {
+					return fmt.Errorf("%s: expected a key in section %s", path, strings.Join(parts[:i+1], "."))
+				}
+				setSection(sections, part, parts[i+1], value)
+				return nil
+			}
+			if !ok {
+				return fmt.Errorf("unknown configuration key %q", strings.Join(parts[:i+1], "."))
+			}
+			v = field
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 {
+				return fmt.Errorf("%s: expected a list index, got %q", strings.Join(parts[:i], "."), part)
+			}
+			v = sliceElem(v, index)
+		default:
+			return fmt.Errorf("unknown configuration key %q", strings.Join(parts[:i+1], "."))
+		}
+	}
+
+	if v.Kind() == reflect.Struct || (v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct) {
+		return fmt.Errorf("%s is a section, not a value", path)
+	}
+	if err := setValue(v, value); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// setValue parses s into v. Lists are comma separated and maps are
+// comma separated key=value pairs.
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		items := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range splitList(s) {
+			items = reflect.Append(items, reflect.ValueOf(item))
+		}
+		v.Set(items)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map type %s", v.Type())
+		}
+		m := reflect.MakeMap(v.Type())
+		for _, item := range splitList(s) {
+			key, value, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", item)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)), reflect.ValueOf(strings.TrimSpace(value)))
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// yamlKey returns the YAML name of a struct field, or "" if it has none
+func yamlKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// fieldByKey finds the field of struct v with the given YAML name
+func fieldByKey(v reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if yamlKey(v.Type().Field(i)) == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// inlineSections returns the field of struct v that collects its other
+// keys as sections, such as the storage driver settings
+func inlineSections(v reflect.Value) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if isSections(v.Type().Field(i)) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// isSections reports whether field is an inline map of sections
+func isSections(field reflect.StructField) bool {
+	return field.Type.Kind() == reflect.Map && strings.Contains(field.Tag.Get("yaml"), ",inline")
+}
+
+// setSection sets key in the named section of the map of sections m
+func setSection(m reflect.Value, section, key, value string) {
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+	entries := m.MapIndex(reflect.ValueOf(section))
+	if !entries.IsValid() || entries.IsNil() {
+		entries = reflect.MakeMap(m.Type().Elem())
+		m.SetMapIndex(reflect.ValueOf(section), entries)
+	}
+	entries.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(value))
+}
+
+// sliceElem returns element index of slice v, growing it if needed
+func sliceElem(v reflect.Value, index int) reflect.Value {
+	if index >= v.Len() {
+		grown := reflect.MakeSlice(v.Type(), index+1, index+1)
+		reflect.Copy(grown, v)
+		v.Set(grown)
+	}
+	return v.Index(index)
+}
+
+// hasPrefix reports whether any variable name in env starts with prefix
+func hasPrefix(env map[string]string, prefix string) bool {
+	for name := range env {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
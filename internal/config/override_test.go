@@ -0,0 +1,185 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "bind_password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	err := applyEnv(&cfg, []string{
+		"REGISTRY_SERVER_PORT=6000",
+		"REGISTRY_SERVER_READ_TIMEOUT=1m30s",
+		"REGISTRY_SERVER_TLS_ENABLED=true",
+		"REGISTRY_AUTH_LDAP_BIND_PASSWORD_FILE=" + secret,
+		"REGISTRY_AUTH_LDAP_ROLE_MAPPING=ops=admin, dev=developer",
+		"REGISTRY_CORS_ALLOWED_ORIGINS=https://a.example.com,,https://b.example.com",
+		"REGISTRY_NOTIFICATIONS_ENDPOINTS_0_URL=https://ci.example.com/hook",
+		"REGISTRY_TRACING_SAMPLE_RATIO=0.25",
+		"OTHER_SERVER_PORT=1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"integer", cfg.Server.Port, 6000},
+		{"duration", cfg.Server.ReadTimeout, 90 * time.Second},
+		{"nested boolean", cfg.Server.TLS.Enabled, true},
+		{"from file", cfg.Auth.LDAP.BindPassword, "s3cret"},
+		{"map", cfg.Auth.LDAP.RoleMapping, map[string]string{"ops": "admin", "dev": "developer"}},
+		{"list", cfg.CORS.AllowedOrigins, []string{"https://a.example.com", "https://b.example.com"}},
+		{"list index", len(cfg.Notifications.Endpoints), 1},
+		{"list entry", cfg.Notifications.Endpoints[0].URL, "https://ci.example.com/hook"},
+		{"float", cfg.Tracing.SampleRatio, 0.25},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("s3cret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		environ []string
+		want    string
+	}{
+		{"integer", []string{"REGISTRY_SERVER_PORT=http"}, `REGISTRY_SERVER_PORT: invalid integer "http"`},
+		{"integer range", []string{"REGISTRY_AUDIT_MAX_BACKUPS=99999999999999999999"}, "REGISTRY_AUDIT_MAX_BACKUPS: invalid integer"},
+		{"boolean", []string{"REGISTRY_AUTH_ENABLED=maybe"}, `REGISTRY_AUTH_ENABLED: invalid boolean "maybe"`},
+		{"duration", []string{"REGISTRY_AUTH_SESSION_TTL=12"}, `REGISTRY_AUTH_SESSION_TTL: invalid duration "12"`},
+		{"map entry", []string{"REGISTRY_AUTH_OIDC_ROLE_MAPPING=ops"}, `expected key=value, got "ops"`},
+		{"value and file", []string{"REGISTRY_AUTH_PASSWORD=a", "REGISTRY_AUTH_PASSWORD_FILE=" + secret}, "REGISTRY_AUTH_PASSWORD and REGISTRY_AUTH_PASSWORD_FILE are both set"},
+		{"missing file", []string{"REGISTRY_AUTH_PASSWORD_FILE=" + secret + ".missing"}, "REGISTRY_AUTH_PASSWORD_FILE: open"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			err := applyEnv(&cfg, tt.environ)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		path  string
+		value string
+		get   func(*Config) interface{}
+		want  interface{}
+	}{
+		{"server.port", "7001", func(c *Config) interface{} { return c.Server.Port }, 7001},
+		{"server.tls.min_version", "1.3", func(c *Config) interface{} { return c.Server.TLS.MinVersion }, "1.3"},
+		{"auth.ldap.timeout", "5s", func(c *Config) interface{} { return c.Auth.LDAP.Timeout }, 5 * time.Second},
+		{"notifications.endpoints.0.events", "push,delete", func(c *Config) interface{} { return c.Notifications.Endpoints[0].Events }, []string{"push", "delete"}},
+		{"quotas.2.max_tags", "5", func(c *Config) interface{} { return c.Quotas[2].MaxTags }, 5},
+		{"health.min_free_mb", "512", func(c *Config) interface{} { return c.Health.MinFreeMB }, int64(512)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var cfg Config
+			if err := Set(&cfg, tt.path, tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.get(&cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetErrors(t *testing.T) {
+	tests := []struct {
+		path  string
+		value string
+		want  string
+	}{
+		{"server.prot", "7000", `unknown configuration key "server.prot"`},
+		{"server.port.number", "7000", `unknown configuration key "server.port.number"`},
+		{"server", "7000", "server is a section, not a value"},
+		{"notifications.endpoints", "x", "notifications.endpoints is a section, not a value"},
+		{"notifications.endpoints.first.url", "x", `notifications.endpoints: expected a list index, got "first"`},
+		{"notifications.endpoints.-1.url", "x", `notifications.endpoints: expected a list index, got "-1"`},
+		{"server.port", "seven", `server.port: invalid integer "seven"`},
+		{"tracing.sample_ratio", "half", `tracing.sample_ratio: invalid number "half"`},
+		{"index.enabled", "yes please", `index.enabled: invalid boolean "yes please"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var cfg Config
+			if err := Set(&cfg, tt.path, tt.value); err == nil || err.Error() != tt.want {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: 5000
+  host: 127.0.0.1
+storage:
+  type: memory
+web:
+  title: From file
+`)
+	t.Setenv("REGISTRY_SERVER_PORT", "6000")
+	t.Setenv("REGISTRY_WEB_TITLE", "From env")
+
+	tests := []struct {
+		name      string
+		overrides []string
+		port      int
+		title     string
+	}{
+		{"environment over file", nil, 6000, "From env"},
+		{"flag over environment", []string{"server.port=7000"}, 7000, "From env"},
+		{"last flag wins", []string{"web.title=First", "web.title=Second"}, 6000, "Second"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(path, storageTypes, tt.overrides...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Port != tt.port || cfg.Web.Title != tt.title {
+				t.Fatalf("port %d, title %q; want %d, %q", cfg.Server.Port, cfg.Web.Title, tt.port, tt.title)
+			}
+			// Unset everywhere else, the file still applies
+			if cfg.Server.Host != "127.0.0.1" {
+				t.Fatalf("host %q, want the file's", cfg.Server.Host)
+			}
+		})
+	}
+
+	if _, err := LoadConfig(path, storageTypes, "server.port"); err == nil || !strings.Contains(err.Error(), "expected key=value") {
+		t.Fatalf("override without a value: got %v", err)
+	}
+	t.Setenv("REGISTRY_SERVER_PORT", "six thousand")
+	if _, err := LoadConfig(path, storageTypes, "server.port=7000"); err == nil || !strings.Contains(err.Error(), "invalid environment override") {
+		t.Fatalf("invalid environment value: got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ApplyReloadable returns current with the settings that can change at
+// runtime taken from next: the configured user, auth.enabled and
+// auth.require_pull_auth, logging level and format, CORS, security (login
+// lockout, rate limit, proxy headers), quotas and the web title. It also returns the
+// keys of any other settings that differ, which need a restart to apply.
+func ApplyReloadable(current, next *Config) (*Config, []string) {
+	merged := *current
+	merged.Auth.Enabled = next.Auth.Enabled
+	merged.Auth.Username = next.Auth.Username
+	merged.Auth.Password = next.Auth.Password
+	merged.Auth.RequirePullAuth = next.Auth.RequirePullAuth
+	merged.Logging.Level = next.Logging.Level
+	merged.Logging.Format = next.Logging.Format
+	merged.CORS = next.CORS
+	merged.Security = next.Security
+	merged.Web.Title = next.Web.Title
+	merged.Quotas = next.Quotas
+
+	return &merged, diffKeys(reflect.ValueOf(merged), reflect.ValueOf(*next), "")
+}
+
+// diffKeys lists the dotted YAML keys of leaf settings that differ between
+// the structs a and b
+func diffKeys(a, b reflect.Value, prefix string) []string {
+	var keys []string
+	for i := 0; i < a.NumField(); i++ {
+		key := yamlKey(a.Type().Field(i))
+		if key == "" {
+			if isSections(a.Type().Field(i)) {
+				keys = append(keys, diffSections(a.Field(i), b.Field(i), prefix)...)
+			}
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		fa, fb := a.Field(i), b.Field(i)
+		if fa.Kind() == reflect.Struct {
+			keys = append(keys, diffKeys(fa, fb, key)...)
+			continue
+		}
+		if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// diffSections lists the keys that differ between two inline maps of
+// sections, such as the storage driver settings
+func diffSections(a, b reflect.Value, prefix string) []string {
+	changed := make(map[string]bool)
+	compare := func(x, y reflect.Value) {
+		for _, section := range x.MapKeys() {
+			entries, other := x.MapIndex(section), y.MapIndex(section)
+			for _, key := range entries.MapKeys() {
+				var value, otherValue interface{}
+				value = entries.MapIndex(key).Interface()
+				if other.IsValid() {
+					if v := other.MapIndex(key); v.IsValid() {
+						otherValue = v.Interface()
+					}
+				}
+				if !reflect.DeepEqual(value, otherValue) {
+					changed[prefix+"."+section.String()+"."+key.String()] = true
+				}
+			}
+		}
+	}
+	compare(a, b)
+	compare(b, a)
+
+	keys := make([]string, 0, len(changed))
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyReloadable(t *testing.T) {
+	current := &Config{
+		Server:  ServerConfig{Port: 5000, TLS: TLSConfig{CertFile: "old.pem"}},
+		Storage: StorageConfig{Type: "s3", Path: "/data", Drivers: map[string]DriverSection{"s3": {"bucket": "old", "region": "eu"}}},
+		Logging: LoggingConfig{Level: "info"},
+		Auth:    AuthConfig{Username: "admin", Password: "old", LDAP: LDAPConfig{URL: "ldap://old"}},
+		Web:     WebConfig{Title: "Old"},
+	}
+	next := &Config{
+		Server:        ServerConfig{Port: 6000, TLS: TLSConfig{CertFile: "new.pem"}},
+		Storage:       StorageConfig{Type: "s3", Path: "/data", Drivers: map[string]DriverSection{"s3": {"bucket": "new", "region": "eu", "insecure": true}}},
+		Logging:       LoggingConfig{Level: "debug"},
+		Auth:          AuthConfig{Username: "admin", Password: "new", LDAP: LDAPConfig{URL: "ldap://new"}},
+		Web:           WebConfig{Title: "New"},
+		Security:      SecurityConfig{Login: LoginProtectionConfig{MaxFailures: 3, Lockout: time.Minute}},
+		Quotas:        []QuotaConfig{{Namespace: "team", MaxTags: 10}},
+		Notifications: NotificationsConfig{Endpoints: []NotificationEndpoint{{Name: "ci", URL: "https://ci.example.com"}}},
+	}
+
+	applied, restart := ApplyReloadable(current, next)
+
+	want := []string{"server.port", "server.tls.cert_file", "storage.s3.bucket", "storage.s3.insecure", "auth.ldap.url", "notifications.endpoints"}
+	if !reflect.DeepEqual(restart, want) {
+		t.Fatalf("restart keys = %v, want %v", restart, want)
+	}
+
+	// Runtime settings come from next
+	if applied.Auth.Password != "new" || applied.Logging.Level != "debug" || applied.Web.Title != "New" ||
+		applied.Security.Login.MaxFailures != 3 || len(applied.Quotas) != 1 {
+		t.Fatalf("reloadable settings not applied: %+v", applied)
+	}
+	// The rest keep their running values
+	if applied.Server.Port != 5000 || applied.Server.TLS.CertFile != "old.pem" || applied.Auth.LDAP.URL != "ldap://old" ||
+		applied.Storage.Drivers["s3"]["bucket"] != "old" || len(applied.Notifications.Endpoints) != 0 {
+		t.Fatalf("restart-required settings applied: %+v", applied)
+	}
+	if current.Auth.Password != "old" {
+		t.Fatal("current config modified")
+	}
+}
+
+func TestApplyReloadableUnchanged(t *testing.T) {
+	cfg := &Config{Server: ServerConfig{Port: 5000}, Quotas: []QuotaConfig{{Repository: "app", MaxTags: 1}}}
+	next := *cfg
+
+	if _, restart := ApplyReloadable(cfg, &next); len(restart) != 0 {
+		t.Fatalf("restart keys = %v, want none", restart)
+	}
+}
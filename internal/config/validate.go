@@ -0,0 +1,347 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ValidationError lists every problem found in a configuration, so they can
+// all be fixed in one pass instead of one restart each
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	if len(e.Problems) == 1 {
+		b.WriteString("invalid configuration (1 problem):")
+	} else {
+		fmt.Fprintf(&b, "invalid configuration (%d problems):", len(e.Problems))
+	}
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
+
+// unknownFieldPattern matches yaml.v2's strict decoding error for an unknown key
+var unknownFieldPattern = regexp.MustCompile(`^(line \d+: )field (\S+) not found in type .*$`)
+
+// yamlProblems turns strict decoding errors into validation problems. It
+// returns false for syntax errors, which leave nothing to validate.
+func yamlProblems(err error) ([]string, bool) {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return nil, false
+	}
+
+	problems := make([]string, 0, len(typeErr.Errors))
+	for _, msg := range typeErr.Errors {
+		problems = append(problems, unknownFieldPattern.ReplaceAllString(msg, `${1}unknown key "$2"`))
+	}
+	return problems, true
+}
+
+// Known enumerated values
+var (
+	validLogLevels     = []string{"panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"}
+	validLogFormats    = []string{"text", "json"}
+	validAccessFormats = []string{"", "common", "combined"}
+	validRoles         = []string{"admin", "developer", "reader"}
+	validTLSVersions   = []string{"", "1.2", "1.3"}
+	validCertFields    = []string{"", "cn", "email", "dns", "uri"}
+	validExporters     = []string{"", "otlp", "file"}
+	validNotifyActions = []string{"push", "pull", "delete"}
+)
+
+// Validate checks required fields, value ranges and conflicting settings,
+// returning a *ValidationError listing every problem. storageTypes are the
+// accepted storage.type values, i.e. the registered storage drivers.
+func (c *Config) Validate(storageTypes []string) error {
+	v := &validator{}
+
+	// Server
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		v.addf("server.port must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if c.Server.ReadTimeout < 0 {
+		v.addf("server.read_timeout must not be negative")
+	}
+	if c.Server.WriteTimeout < 0 {
+		v.addf("server.write_timeout must not be negative")
+	}
+	if tls := c.Server.TLS; tls.Enabled {
+		v.requireFile("server.tls.cert_file", tls.CertFile)
+		v.requireFile("server.tls.key_file", tls.KeyFile)
+		v.oneOf("server.tls.min_version", tls.MinVersion, validTLSVersions)
+		if tls.ReloadInterval < 0 {
+			v.addf("server.tls.reload_interval must not be negative")
+		}
+	} else if tls.RedirectAddr != "" {
+		v.addf("server.tls.redirect_addr requires server.tls.enabled")
+	}
+
+	// Storage
+	v.oneOf("storage.type", c.Storage.Type, storageTypes)
+	if c.Storage.Type == "filesystem" && c.Storage.Path == "" {
+		v.addf("storage.path is required for filesystem storage")
+	}
+	// Every other key is the section of a driver
+	sections := make([]string, 0, len(c.Storage.Drivers))
+	for name := range c.Storage.Drivers {
+		sections = append(sections, name)
+	}
+	sort.Strings(sections)
+	for _, name := range sections {
+		if !contains(storageTypes, name) {
+			v.addf("unknown key \"storage.%s\": not a storage type (available: %s)", name, strings.Join(storageTypes, ", "))
+		}
+	}
+
+	// Logging
+	v.oneOf("logging.level", strings.ToLower(c.Logging.Level), validLogLevels)
+	v.oneOf("logging.format", c.Logging.Format, validLogFormats)
+	v.oneOf("logging.access_format", c.Logging.AccessFormat, validAccessFormats)
+
+	// Authentication
+	auth := c.Auth
+	if auth.Username != "" && auth.Password == "" {
+		v.addf("auth.password is required when auth.username is set")
+	}
+	if auth.Password != "" && auth.Username == "" {
+		v.addf("auth.username is required when auth.password is set")
+	}
+	if auth.Enabled && auth.Username == "" && !auth.LDAP.Enabled && !auth.OIDC.Enabled && !auth.ClientCert.Enabled && !fileExists(c.GetUsersFile()) {
+		v.addf("auth.enabled requires a user: set auth.username and auth.password, add one with the user command, or enable ldap, oidc or client_cert")
+	}
+	if !auth.Enabled && auth.RequirePullAuth {
+		v.addf("auth.require_pull_auth requires auth.enabled")
+	}
+	if c.Web.Enabled && !auth.Enabled {
+		v.addf("web.enabled requires auth.enabled; the web interface can delete tags and manage tokens")
+	}
+	if auth.SessionTTL < 0 {
+		v.addf("auth.session_ttl must not be negative")
+	}
+	if ldap := auth.LDAP; ldap.Enabled {
+		v.require("auth.ldap.url", ldap.URL)
+		v.require("auth.ldap.base_dn", ldap.BaseDN)
+		if ldap.URL != "" {
+			if u, err := url.Parse(ldap.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+				v.addf("auth.ldap.url must be an ldap:// or ldaps:// URL, got %q", ldap.URL)
+			}
+		}
+		if ldap.CAFile != "" {
+			v.requireFile("auth.ldap.ca_file", ldap.CAFile)
+		}
+		v.roleMapping("auth.ldap", ldap.RoleMapping, ldap.DefaultRole)
+	}
+	if oidc := auth.OIDC; oidc.Enabled {
+		v.require("auth.oidc.issuer", oidc.Issuer)
+		v.require("auth.oidc.client_id", oidc.ClientID)
+		v.require("auth.oidc.redirect_url", oidc.RedirectURL)
+		v.roleMapping("auth.oidc", oidc.RoleMapping, oidc.DefaultRole)
+	}
+	if cc := auth.ClientCert; cc.Enabled {
+		if !c.Server.TLS.Enabled {
+			v.addf("auth.client_cert requires server.tls.enabled")
+		}
+		v.requireFile("auth.client_cert.ca_file", cc.CAFile)
+		v.oneOf("auth.client_cert.username_field", cc.UsernameField, validCertFields)
+		v.roleMapping("auth.client_cert", cc.RoleMapping, cc.DefaultRole)
+	}
+
+	// CORS
+	if c.CORS.Enabled && len(c.CORS.AllowedOrigins) == 0 {
+		v.addf("cors.allowed_origins must not be empty when cors.enabled")
+	}
+
+	// Security
+	if rl := c.Security.RateLimit; rl.Enabled {
+		if rl.RequestsPerSecond <= 0 {
+			v.addf("security.rate_limit.requests_per_second must be positive")
+		}
+		if rl.Burst < 0 {
+			v.addf("security.rate_limit.burst must not be negative")
+		}
+	}
+	for _, proxy := range c.Security.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			v.addf("security.trusted_proxies: %q is not an IP address or CIDR range", proxy)
+		}
+	}
+	if login := c.Security.Login; login.Lockout < 0 || login.MaxLockout < 0 || login.ResetAfter < 0 {
+		v.addf("security.login durations must not be negative")
+	}
+
+	// Audit
+	if c.Audit.MaxSizeMB < 0 {
+		v.addf("audit.max_size_mb must not be negative")
+	}
+	if c.Audit.MaxBackups < 0 {
+		v.addf("audit.max_backups must not be negative")
+	}
+
+	// Notifications
+	names := make(map[string]bool)
+	for i, endpoint := range c.Notifications.Endpoints {
+		field := fmt.Sprintf("notifications.endpoints[%d]", i)
+		if endpoint.Name == "" {
+			v.addf("%s.name is required", field)
+		} else if names[endpoint.Name] {
+			v.addf("%s.name %q is used by another endpoint", field, endpoint.Name)
+		}
+		names[endpoint.Name] = true
+
+		if u, err := url.Parse(endpoint.URL); endpoint.URL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.addf("%s.url must be an http:// or https:// URL, got %q", field, endpoint.URL)
+		}
+		for _, action := range endpoint.Events {
+			v.oneOf(field+".events", action, validNotifyActions)
+		}
+		if endpoint.Timeout < 0 || endpoint.Backoff < 0 || endpoint.MaxBackoff < 0 || endpoint.MaxRetries < 0 {
+			v.addf("%s timeouts and retry settings must not be negative", field)
+		}
+	}
+
+	// Metrics
+	if c.Metrics.Enabled && !strings.HasPrefix(c.GetMetricsPath(), "/") {
+		v.addf("metrics.path must start with /, got %q", c.Metrics.Path)
+	}
+	if c.Metrics.StorageInterval < 0 {
+		v.addf("metrics.storage_interval must not be negative")
+	}
+
+	// Tracing
+	if tracing := c.Tracing; tracing.Enabled {
+		v.oneOf("tracing.exporter", tracing.Exporter, validExporters)
+		if tracing.Exporter == "file" {
+			v.require("tracing.file_path", tracing.FilePath)
+		}
+		if tracing.SampleRatio < 0 || tracing.SampleRatio > 1 {
+			v.addf("tracing.sample_ratio must be between 0 and 1, got %g", tracing.SampleRatio)
+		}
+	}
+
+	// Health
+	if c.Health.MinFreeMB < 0 {
+		v.addf("health.min_free_mb must not be negative")
+	}
+	if c.Health.DrainDelay < 0 {
+		v.addf("health.drain_delay must not be negative")
+	}
+
+	// Scrub
+	if c.Scrub.Interval < 0 {
+		v.addf("scrub.interval must not be negative")
+	}
+	if c.Scrub.RateMB < 0 {
+		v.addf("scrub.rate_mb must not be negative")
+	}
+
+	// Quotas
+	scopes := make(map[string]bool)
+	for i, quota := range c.Quotas {
+		field := fmt.Sprintf("quotas[%d]", i)
+		if (quota.Repository == "") == (quota.Namespace == "") {
+			v.addf("%s must set exactly one of repository and namespace", field)
+		} else if scopes[quota.Scope()] {
+			v.addf("%s: %s has another quota", field, quota.Scope())
+		}
+		scopes[quota.Scope()] = true
+
+		if quota.MaxSizeMB < 0 || quota.MaxTags < 0 {
+			v.addf("%s limits must not be negative", field)
+		} else if quota.MaxSizeMB == 0 && quota.MaxTags == 0 {
+			v.addf("%s must set max_size_mb or max_tags", field)
+		}
+	}
+
+	// Index: a memory store is empty on every start, so there is nothing to keep
+	if c.Index.Enabled && c.Storage.Type == "memory" {
+		v.addf("index.enabled cannot be used with memory storage")
+	}
+
+	return v.err()
+}
+
+// validator accumulates problems
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) require(field, value string) {
+	if value == "" {
+		v.addf("%s is required", field)
+	}
+}
+
+// requireFile checks that path is set and names a readable file
+func (v *validator) requireFile(field, path string) {
+	if path == "" {
+		v.addf("%s is required", field)
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.addf("%s: %v", field, err)
+	}
+}
+
+// fileExists reports whether path names an existing file
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// contains reports whether list holds value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) oneOf(field, value string, allowed []string) {
+	if contains(allowed, value) {
+		return
+	}
+
+	var quoted []string
+	for _, a := range allowed {
+		if a != "" {
+			quoted = append(quoted, fmt.Sprintf("%q", a))
+		}
+	}
+	v.addf("%s must be one of %s, got %q", field, strings.Join(quoted, ", "), value)
+}
+
+func (v *validator) roleMapping(section string, mapping map[string]string, defaultRole string) {
+	for group, role := range mapping {
+		v.oneOf(fmt.Sprintf("%s.role_mapping[%s]", section, group), role, validRoles)
+	}
+	if defaultRole != "" {
+		v.oneOf(section+".default_role", defaultRole, validRoles)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
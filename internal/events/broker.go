@@ -0,0 +1,134 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Activity types
+const (
+	TypeManifestPush   = "manifest_push"
+	TypeManifestDelete = "manifest_delete"
+	TypeTagUpdate      = "tag_update"
+	TypeTagDelete      = "tag_delete"
+	TypeBlobPush       = "blob_push"
+	TypeBlobDelete     = "blob_delete"
+	TypeUploadProgress = "upload_progress"
+	TypeBlobQuarantine = "blob_quarantine"
+)
+
+// Event is one item of registry activity
+type Event struct {
+	ID             uint64    `json:"id"`
+	Type           string    `json:"type"`
+	Time           time.Time `json:"time"`
+	Repository     string    `json:"repository"`
+	Tag            string    `json:"tag,omitempty"`
+	Digest         string    `json:"digest,omitempty"`
+	PreviousDigest string    `json:"previous_digest,omitempty"`
+	Upload         string    `json:"upload,omitempty"`
+	Size           int64     `json:"size,omitempty"`
+	Actor          string    `json:"actor,omitempty"`
+}
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before further events are dropped for it
+const subscriberBuffer = 64
+
+// Subscription receives published events on C until it is closed
+type Subscription struct {
+	C <-chan Event
+
+	ch     chan Event
+	broker *Broker
+}
+
+// Close stops delivery to the subscription
+func (s *Subscription) Close() {
+	s.broker.mutex.Lock()
+	defer s.broker.mutex.Unlock()
+
+	if _, ok := s.broker.subscribers[s]; ok {
+		delete(s.broker.subscribers, s)
+		close(s.ch)
+	}
+}
+
+// Broker fans activity out to live subscribers and keeps the most recent
+// events so new subscribers can catch up
+type Broker struct {
+	mutex       sync.Mutex
+	nextID      uint64
+	recent      []Event
+	maxRecent   int
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroker creates a broker remembering the last maxRecent events
+func NewBroker(maxRecent int) *Broker {
+	return &Broker{
+		nextID:      1,
+		maxRecent:   maxRecent,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the event an ID and delivers it to every subscriber.
+// It never blocks: subscribers that are too far behind miss the event.
+func (b *Broker) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	event.ID = b.nextID
+	b.nextID++
+
+	// Upload progress is only interesting while it happens
+	if event.Type != TypeUploadProgress && b.maxRecent > 0 {
+		b.recent = append(b.recent, event)
+		if len(b.recent) > b.maxRecent {
+			b.recent = b.recent[len(b.recent)-b.maxRecent:]
+		}
+	}
+
+	for s := range b.subscribers {
+		select {
+		case s.ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a new subscriber
+func (b *Broker) Subscribe() *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: ch, ch: ch, broker: b}
+
+	b.mutex.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mutex.Unlock()
+
+	return s
+}
+
+// Recent returns remembered events with an ID greater than afterID, oldest first
+func (b *Broker) Recent(afterID uint64) []Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// An ID we never issued comes from before a restart
+	if afterID >= b.nextID {
+		afterID = 0
+	}
+
+	var events []Event
+	for _, event := range b.recent {
+		if event.ID > afterID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package health
+
+import "syscall"
+
+// freeBytes returns the space available to unprivileged users on the
+// filesystem holding dir
+func freeBytes(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,173 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check statuses
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+// checkTimeout bounds how long a readiness probe waits for all checks
+const checkTimeout = 5 * time.Second
+
+// Check returns an error when the component it watches is unhealthy
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one readiness check
+type Result struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Report is the body of a health or readiness response
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks,omitempty"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the registered readiness checks and tracks drain mode
+type Checker struct {
+	mutex    sync.RWMutex
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+// New creates a Checker with no checks
+func New() *Checker {
+	return &Checker{}
+}
+
+// Add registers a readiness check under name
+func (c *Checker) Add(name string, check Check) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Drain marks the process as shutting down so readiness fails and load
+// balancers stop routing new requests to it
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Draining reports whether Drain has been called
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Ready runs every check concurrently and reports the overall status
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mutex.RLock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func(i int, nc namedCheck) {
+			defer wg.Done()
+			start := time.Now()
+			result := Result{Name: nc.name, Status: StatusOK}
+			if err := nc.check(ctx); err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+			result.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+			results[i] = result
+		}(i, nc)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	if c.Draining() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+// LiveHandler answers liveness probes: the process is up and serving
+func (c *Checker) LiveHandler(w http.ResponseWriter, req *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: StatusOK})
+}
+
+// ReadyHandler answers readiness probes with the result of every check,
+// returning 503 if any fails or the server is draining
+func (c *Checker) ReadyHandler(w http.ResponseWriter, req *http.Request) {
+	report := c.Ready(req.Context())
+
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, report)
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// Writable checks that a file can be created, written and removed in dir
+func Writable(dir string) Check {
+	return func(ctx context.Context) error {
+		file, err := os.CreateTemp(dir, ".healthcheck-*")
+		if err != nil {
+			return fmt.Errorf("storage path is not writable: %w", err)
+		}
+		name := file.Name()
+		defer os.Remove(name)
+
+		if _, err := file.Write([]byte("ok")); err != nil {
+			file.Close()
+			return fmt.Errorf("storage path is not writable: %w", err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("storage path is not writable: %w", err)
+		}
+		return nil
+	}
+}
+
+// DiskSpace checks that the filesystem holding dir has at least minFree
+// bytes available
+func DiskSpace(dir string, minFree uint64) Check {
+	return func(ctx context.Context) error {
+		free, err := freeBytes(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read free disk space: %w", err)
+		}
+		if free < minFree {
+			return fmt.Errorf("%d MB free, below the %d MB threshold", free>>20, minFree>>20)
+		}
+		return nil
+	}
+}
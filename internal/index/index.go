@@ -0,0 +1,828 @@
+package index
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"docker-registry-manager/internal/storage"
+)
+
+// schemaVersion changes whenever the layout below does; an index written
+// with another version is rebuilt on open
+const schemaVersion = "2"
+
+// lockTimeout is how long Open waits for another process, usually the
+// server, to release the database
+const lockTimeout = time.Second
+
+var (
+	// ErrLocked is returned by Open when another process has the index open
+	ErrLocked = errors.New("index is in use by another process")
+	// ErrStale is returned by queries while the index needs rebuilding
+	ErrStale = errors.New("index is out of date")
+)
+
+// The database has three top-level buckets:
+//
+//	meta          version, open flag and the running totals
+//	repositories  a bucket per repository holding "updated",
+//	              "tag_count" and the "tags" (tag -> tagRecord) and
+//	              "manifests" (digest -> manifestRecord) buckets
+//	blobs         digest -> size
+//
+// A repository is listed once it has a manifests bucket, matching the
+// filesystem storage, which lists directories holding manifests.
+var (
+	bucketMeta         = []byte("meta")
+	bucketRepositories = []byte("repositories")
+	bucketBlobs        = []byte("blobs")
+	bucketTags         = []byte("tags")
+	bucketManifests    = []byte("manifests")
+
+	keyVersion      = []byte("version")
+	keyOpen         = []byte("open")
+	keyUpdated      = []byte("updated")
+	keyTagCount     = []byte("tag_count")
+	keyRepositories = []byte("repositories")
+	keyTags         = []byte("tags")
+	keySize         = []byte("size")
+)
+
+// tagRecord is the stored value of a tag
+type tagRecord struct {
+	Digest  string    `json:"digest"`
+	Updated time.Time `json:"updated"`
+}
+
+// manifestRecord is the stored value of a manifest
+type manifestRecord struct {
+	Size      int64  `json:"size"`
+	MediaType string `json:"media_type"`
+	// ImageSize adds the sizes of the referenced blobs to Size
+	ImageSize int64 `json:"image_size"`
+	// Blobs are the config and layer digests, for usage by unique blobs
+	Blobs  []string  `json:"blobs"`
+	Pushed time.Time `json:"pushed"`
+}
+
+// newManifestRecord describes a manifest, looking up the sizes of the
+// blobs it references with blobSize
+func newManifestRecord(data []byte, mediaType string, pushed time.Time, blobSize func(digest string) int64) manifestRecord {
+	record := manifestRecord{
+		Size:      int64(len(data)),
+		MediaType: mediaType,
+		ImageSize: int64(len(data)),
+		Pushed:    pushed,
+	}
+	blobs, _, err := storage.References(data)
+	if err != nil {
+		return record
+	}
+	seen := make(map[string]bool, len(blobs))
+	for _, digest := range blobs {
+		if !seen[digest] {
+			seen[digest] = true
+			record.Blobs = append(record.Blobs, digest)
+			record.ImageSize += blobSize(digest)
+		}
+	}
+	return record
+}
+
+// Stats are the registry totals kept by the index
+type Stats struct {
+	Repositories int `json:"repositories"`
+	Tags         int `json:"tags"`
+	// Size is the total size of stored blobs and manifests
+	Size int64 `json:"size"`
+}
+
+// Index wraps a storage and records repositories, tags, manifests and blobs
+// in a bbolt database as they are written, so listings and totals are
+// answered without walking the storage. Operations it does not track pass
+// straight through.
+type Index struct {
+	storage.Storage
+	db *bolt.DB
+
+	// rebuilding is held exclusively by Rebuild and shared by writes, so a
+	// write cannot slip in between reading the storage and saving the result
+	rebuilding sync.RWMutex
+	// stale is set when the storage changed but the index could not be
+	// updated; queries then go to the storage until the index is rebuilt
+	stale atomic.Bool
+	// locks serializes writes per repository and per blob
+	locks keyLocks
+}
+
+// Open opens or creates the index at path for store. An index that is new,
+// from another version, or was not closed cleanly, since a crash can leave
+// it behind the storage, is stale until rebuilt.
+func Open(store storage.Storage, path string) (*Index, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+		}
+		return nil, err
+	}
+
+	idx := &Index{Storage: store, db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta == nil || string(meta.Get(keyVersion)) != schemaVersion || meta.Get(keyOpen) != nil {
+			idx.stale.Store(true)
+		}
+		if meta == nil {
+			return nil
+		}
+		return meta.Put(keyOpen, []byte("1"))
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Close marks the index as closed cleanly, unless it fell behind the
+// storage, and closes the database
+func (idx *Index) Close() error {
+	if !idx.stale.Load() {
+		err := idx.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketMeta).Delete(keyOpen)
+		})
+		if err != nil {
+			logrus.Errorf("Failed to close metadata index: %v", err)
+		}
+	}
+	return idx.db.Close()
+}
+
+// Stale reports whether the index may be behind the storage and needs
+// rebuilding. A stale index answers queries from the storage.
+func (idx *Index) Stale() bool {
+	return idx.stale.Load()
+}
+
+// Rebuild replaces the index with the current content of the storage.
+// Writes wait until it finishes. Push times of entries still present are
+// kept; entries the index has not seen have none.
+func (idx *Index) Rebuild(ctx context.Context) error {
+	idx.rebuilding.Lock()
+	defer idx.rebuilding.Unlock()
+
+	previous := make(map[string]map[string]time.Time)
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		repos := tx.Bucket(bucketRepositories)
+		if repos == nil {
+			return nil
+		}
+		return repos.ForEachBucket(func(name []byte) error {
+			times := make(map[string]time.Time)
+			repo := repos.Bucket(name)
+			times[""] = parseTime(repo.Get(keyUpdated))
+			forEach(repo.Bucket(bucketTags), func(tag string, record tagRecord) {
+				times["tag:"+tag] = record.Updated
+			})
+			forEach(repo.Bucket(bucketManifests), func(digest string, record manifestRecord) {
+				times["manifest:"+digest] = record.Pushed
+			})
+			previous[string(name)] = times
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	blobs, err := idx.scanBlobs(ctx)
+	if err != nil {
+		return err
+	}
+	repositories, err := idx.Storage.ListRepositories(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	type scanned struct {
+		tags      map[string]tagRecord
+		manifests map[string]manifestRecord
+	}
+	contents := make(map[string]scanned, len(repositories))
+	for _, repository := range repositories {
+		times := previous[repository]
+		tags, err := idx.scanTags(ctx, repository, times)
+		if err != nil {
+			return err
+		}
+		manifests, err := idx.scanManifests(ctx, repository, blobs, times)
+		if err != nil {
+			return err
+		}
+		contents[repository] = scanned{tags, manifests}
+	}
+
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketMeta, bucketRepositories, bucketBlobs} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucket(bucketMeta)
+		if err != nil {
+			return err
+		}
+		repos, err := tx.CreateBucket(bucketRepositories)
+		if err != nil {
+			return err
+		}
+		blobBucket, err := tx.CreateBucket(bucketBlobs)
+		if err != nil {
+			return err
+		}
+
+		var totals Stats
+		for digest, size := range blobs {
+			if err := blobBucket.Put([]byte(digest), encodeInt(size)); err != nil {
+				return err
+			}
+			totals.Size += size
+		}
+		for repository, content := range contents {
+			repo, err := repos.CreateBucket([]byte(repository))
+			if err != nil {
+				return err
+			}
+			if err := repo.Put(keyUpdated, formatTime(previous[repository][""])); err != nil {
+				return err
+			}
+			if err := repo.Put(keyTagCount, encodeInt(int64(len(content.tags)))); err != nil {
+				return err
+			}
+			tags, err := repo.CreateBucket(bucketTags)
+			if err != nil {
+				return err
+			}
+			for tag, record := range content.tags {
+				if err := putJSON(tags, tag, record); err != nil {
+					return err
+				}
+			}
+			manifests, err := repo.CreateBucket(bucketManifests)
+			if err != nil {
+				return err
+			}
+			for digest, record := range content.manifests {
+				if err := putJSON(manifests, digest, record); err != nil {
+					return err
+				}
+				totals.Size += record.Size
+			}
+			totals.Repositories++
+			totals.Tags += len(content.tags)
+		}
+
+		for key, value := range map[string]int64{
+			string(keyRepositories): int64(totals.Repositories),
+			string(keyTags):         int64(totals.Tags),
+			string(keySize):         totals.Size,
+		} {
+			if err := meta.Put([]byte(key), encodeInt(value)); err != nil {
+				return err
+			}
+		}
+		if err := meta.Put(keyVersion, []byte(schemaVersion)); err != nil {
+			return err
+		}
+		return meta.Put(keyOpen, []byte("1"))
+	})
+	if err != nil {
+		return err
+	}
+
+	idx.stale.Store(false)
+	return nil
+}
+
+// scanBlobs returns the size of every blob in the storage
+func (idx *Index) scanBlobs(ctx context.Context) (map[string]int64, error) {
+	digests, err := idx.Storage.ListBlobs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	blobs := make(map[string]int64, len(digests))
+	for _, digest := range digests {
+		size, err := idx.Storage.GetBlobSize(ctx, digest)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // Deleted since it was listed
+			}
+			return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+		}
+		blobs[digest] = size
+	}
+	return blobs, nil
+}
+
+// scanTags returns the tags of a repository with their previous update times
+func (idx *Index) scanTags(ctx context.Context, repository string, times map[string]time.Time) (map[string]tagRecord, error) {
+	names, err := idx.Storage.ListTags(ctx, repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of %s: %w", repository, err)
+	}
+	tags := make(map[string]tagRecord, len(names))
+	for _, tag := range names {
+		digest, err := idx.Storage.GetTagDigest(ctx, repository, tag)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read tag %s:%s: %w", repository, tag, err)
+		}
+		tags[tag] = tagRecord{Digest: digest, Updated: times["tag:"+tag]}
+	}
+	return tags, nil
+}
+
+// scanManifests returns the manifests of a repository with their previous
+// push times
+func (idx *Index) scanManifests(ctx context.Context, repository string, blobs map[string]int64, times map[string]time.Time) (map[string]manifestRecord, error) {
+	digests, err := idx.Storage.ListManifests(ctx, repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list manifests of %s: %w", repository, err)
+	}
+	manifests := make(map[string]manifestRecord, len(digests))
+	for _, digest := range digests {
+		data, mediaType, err := idx.Storage.GetManifest(ctx, repository, digest)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read manifest %s@%s: %w", repository, digest, err)
+		}
+		manifests[digest] = newManifestRecord(data, mediaType, times["manifest:"+digest], func(digest string) int64 {
+			return blobs[digest]
+		})
+	}
+	return manifests, nil
+}
+
+// Stats returns the registry totals
+func (idx *Index) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	if idx.stale.Load() {
+		return stats, ErrStale
+	}
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		stats.Repositories = int(decodeInt(meta.Get(keyRepositories)))
+		stats.Tags = int(decodeInt(meta.Get(keyTags)))
+		stats.Size = decodeInt(meta.Get(keySize))
+		return nil
+	})
+	return stats, err
+}
+
+// Repositories returns every repository in name order with its tag count
+// and last update
+func (idx *Index) Repositories(ctx context.Context) ([]storage.RepositoryInfo, error) {
+	if idx.stale.Load() {
+		return nil, ErrStale
+	}
+	var infos []storage.RepositoryInfo
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		repos := tx.Bucket(bucketRepositories)
+		return repos.ForEachBucket(func(name []byte) error {
+			repo := repos.Bucket(name)
+			if repo.Bucket(bucketManifests) == nil {
+				return nil
+			}
+			infos = append(infos, storage.RepositoryInfo{
+				Name:     string(name),
+				TagCount: int(decodeInt(repo.Get(keyTagCount))),
+				Updated:  parseTime(repo.Get(keyUpdated)),
+			})
+			return nil
+		})
+	})
+	return infos, err
+}
+
+// Tags returns the tags of a repository in name order with their digest,
+// image size and last update. A repository that does not exist has none.
+func (idx *Index) Tags(ctx context.Context, repository string) ([]storage.TagInfo, error) {
+	if idx.stale.Load() {
+		return nil, ErrStale
+	}
+	var infos []storage.TagInfo
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		repo := tx.Bucket(bucketRepositories).Bucket([]byte(repository))
+		if repo == nil {
+			return nil
+		}
+		manifests := repo.Bucket(bucketManifests)
+		forEach(repo.Bucket(bucketTags), func(tag string, record tagRecord) {
+			info := storage.TagInfo{Name: tag, Digest: record.Digest, Updated: record.Updated}
+			if manifests != nil {
+				var manifest manifestRecord
+				if getJSON(manifests, record.Digest, &manifest) {
+					info.Size = manifest.ImageSize
+				}
+			}
+			infos = append(infos, info)
+		})
+		return nil
+	})
+	return infos, err
+}
+
+// Usage returns the unique stored blobs referenced by the manifests of the
+// repositories selected by match, with their sizes, and the number of tags
+// in those repositories
+func (idx *Index) Usage(ctx context.Context, match func(repository string) bool) (map[string]int64, int, error) {
+	if idx.stale.Load() {
+		return nil, 0, ErrStale
+	}
+	blobs := make(map[string]int64)
+	var tags int
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		sizes := tx.Bucket(bucketBlobs)
+		repos := tx.Bucket(bucketRepositories)
+		return repos.ForEachBucket(func(name []byte) error {
+			if !match(string(name)) {
+				return nil
+			}
+			repo := repos.Bucket(name)
+			tags += int(decodeInt(repo.Get(keyTagCount)))
+			forEach(repo.Bucket(bucketManifests), func(_ string, record manifestRecord) {
+				for _, digest := range record.Blobs {
+					// A manifest may have been stored ahead of its blobs
+					if size := sizes.Get([]byte(digest)); size != nil {
+						blobs[digest] = decodeInt(size)
+					}
+				}
+			})
+			return nil
+		})
+	})
+	return blobs, tags, err
+}
+
+// ListRepositories returns the indexed repositories
+func (idx *Index) ListRepositories(ctx context.Context) ([]string, error) {
+	if idx.stale.Load() {
+		return idx.Storage.ListRepositories(ctx)
+	}
+	infos, err := idx.Repositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	repositories := make([]string, len(infos))
+	for i, info := range infos {
+		repositories[i] = info.Name
+	}
+	return repositories, nil
+}
+
+// ListTags returns the indexed tags of a repository
+func (idx *Index) ListTags(ctx context.Context, repository string) ([]string, error) {
+	if idx.stale.Load() {
+		return idx.Storage.ListTags(ctx, repository)
+	}
+	tags := []string{}
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		repo := tx.Bucket(bucketRepositories).Bucket([]byte(repository))
+		if repo == nil || repo.Bucket(bucketTags) == nil {
+			return nil
+		}
+		return repo.Bucket(bucketTags).ForEach(func(tag, _ []byte) error {
+			tags = append(tags, string(tag))
+			return nil
+		})
+	})
+	return tags, err
+}
+
+// GetTotalStorageSize returns the indexed size of blobs and manifests
+func (idx *Index) GetTotalStorageSize(ctx context.Context) (int64, error) {
+	if idx.stale.Load() {
+		return idx.Storage.GetTotalStorageSize(ctx)
+	}
+	stats, err := idx.Stats(ctx)
+	return stats.Size, err
+}
+
+// DeleteRepository deletes a repository and subtracts its tags and manifests
+// from the totals; its blobs stay indexed until they are deleted
+func (idx *Index) DeleteRepository(ctx context.Context, repository string) error {
+	return idx.write("repository:"+repository, func() error {
+		return idx.Storage.DeleteRepository(ctx, repository)
+	}, func(tx *bolt.Tx, now time.Time) error {
+		repos := tx.Bucket(bucketRepositories)
+		repo := repos.Bucket([]byte(repository))
+		if repo == nil {
+			return nil
+		}
+		var size int64
+		forEach(repo.Bucket(bucketManifests), func(_ string, record manifestRecord) {
+			size += record.Size
+		})
+		if repo.Bucket(bucketManifests) != nil {
+			if err := addTotal(tx, keyRepositories, -1); err != nil {
+				return err
+			}
+		}
+		if err := addTotal(tx, keyTags, -decodeInt(repo.Get(keyTagCount))); err != nil {
+			return err
+		}
+		if err := addTotal(tx, keySize, -size); err != nil {
+			return err
+		}
+		return repos.DeleteBucket([]byte(repository))
+	})
+}
+
+// PutTag points a tag at a manifest, counting it if it is new
+func (idx *Index) PutTag(ctx context.Context, repository, tag, digest string) error {
+	return idx.write("repository:"+repository, func() error {
+		return idx.Storage.PutTag(ctx, repository, tag, digest)
+	}, func(tx *bolt.Tx, now time.Time) error {
+		repo, err := repositoryBucket(tx, repository, now)
+		if err != nil {
+			return err
+		}
+		tags, err := repo.CreateBucketIfNotExists(bucketTags)
+		if err != nil {
+			return err
+		}
+		if tags.Get([]byte(tag)) == nil {
+			if err := addTagCount(tx, repo, 1); err != nil {
+				return err
+			}
+		}
+		return putJSON(tags, tag, tagRecord{Digest: digest, Updated: now})
+	})
+}
+
+// DeleteTag deletes a tag and uncounts it
+func (idx *Index) DeleteTag(ctx context.Context, repository, tag string) error {
+	return idx.write("repository:"+repository, func() error {
+		return idx.Storage.DeleteTag(ctx, repository, tag)
+	}, func(tx *bolt.Tx, now time.Time) error {
+		repo := tx.Bucket(bucketRepositories).Bucket([]byte(repository))
+		if repo == nil || repo.Bucket(bucketTags) == nil || repo.Bucket(bucketTags).Get([]byte(tag)) == nil {
+			return nil
+		}
+		if err := repo.Put(keyUpdated, formatTime(now)); err != nil {
+			return err
+		}
+		if err := addTagCount(tx, repo, -1); err != nil {
+			return err
+		}
+		return repo.Bucket(bucketTags).Delete([]byte(tag))
+	})
+}
+
+// PutManifest stores a manifest and records its size and the image size
+// of the blobs it references
+func (idx *Index) PutManifest(ctx context.Context, repository, digest string, data []byte, mediaType string) error {
+	return idx.write("repository:"+repository, func() error {
+		return idx.Storage.PutManifest(ctx, repository, digest, data, mediaType)
+	}, func(tx *bolt.Tx, now time.Time) error {
+		repo, err := repositoryBucket(tx, repository, now)
+		if err != nil {
+			return err
+		}
+		manifests := repo.Bucket(bucketManifests)
+		if manifests == nil {
+			if manifests, err = repo.CreateBucket(bucketManifests); err != nil {
+				return err
+			}
+			if err := addTotal(tx, keyRepositories, 1); err != nil {
+				return err
+			}
+		}
+
+		var previous manifestRecord
+		getJSON(manifests, digest, &previous)
+		if err := addTotal(tx, keySize, int64(len(data))-previous.Size); err != nil {
+			return err
+		}
+		blobs := tx.Bucket(bucketBlobs)
+		return putJSON(manifests, digest, newManifestRecord(data, mediaType, now, func(digest string) int64 {
+			return decodeInt(blobs.Get([]byte(digest)))
+		}))
+	})
+}
+
+// DeleteManifest deletes a manifest and subtracts its size. Tags pointing
+// to it are left, as in the storage.
+func (idx *Index) DeleteManifest(ctx context.Context, repository, digest string) error {
+	return idx.write("repository:"+repository, func() error {
+		return idx.Storage.DeleteManifest(ctx, repository, digest)
+	}, func(tx *bolt.Tx, now time.Time) error {
+		repo := tx.Bucket(bucketRepositories).Bucket([]byte(repository))
+		if repo == nil || repo.Bucket(bucketManifests) == nil {
+			return nil
+		}
+		manifests := repo.Bucket(bucketManifests)
+		var previous manifestRecord
+		if !getJSON(manifests, digest, &previous) {
+			return nil
+		}
+		if err := repo.Put(keyUpdated, formatTime(now)); err != nil {
+			return err
+		}
+		if err := addTotal(tx, keySize, -previous.Size); err != nil {
+			return err
+		}
+		return manifests.Delete([]byte(digest))
+	})
+}
+
+// PutBlob stores a blob and records its size
+func (idx *Index) PutBlob(ctx context.Context, digest string, data []byte) error {
+	return idx.write("blob:"+digest, func() error {
+		return idx.Storage.PutBlob(ctx, digest, data)
+	}, func(tx *bolt.Tx, now time.Time) error {
+		return putBlob(tx, digest, int64(len(data)))
+	})
+}
+
+// CompleteBlobUpload finishes an upload and records the blob's size
+func (idx *Index) CompleteBlobUpload(ctx context.Context, uploadID, digest string, finalChunk []byte) error {
+	var size int64
+	return idx.write("blob:"+digest, func() error {
+		if err := idx.Storage.CompleteBlobUpload(ctx, uploadID, digest, finalChunk); err != nil {
+			return err
+		}
+		var err error
+		size, err = idx.Storage.GetBlobSize(ctx, digest)
+		if err != nil {
+			// The upload is complete; only the index misses it
+			idx.markStale(fmt.Errorf("failed to read size of %s: %w", digest, err))
+		}
+		return nil
+	}, func(tx *bolt.Tx, now time.Time) error {
+		return putBlob(tx, digest, size)
+	})
+}
+
+// DeleteBlob deletes a blob and subtracts its size
+func (idx *Index) DeleteBlob(ctx context.Context, digest string) error {
+	return idx.write("blob:"+digest, func() error {
+		return idx.Storage.DeleteBlob(ctx, digest)
+	}, func(tx *bolt.Tx, now time.Time) error {
+		return removeBlob(tx, digest)
+	})
+}
+
+// QuarantineBlob removes the blob from the index as it is no longer served
+func (idx *Index) QuarantineBlob(ctx context.Context, digest string) error {
+	return idx.write("blob:"+digest, func() error {
+		return idx.Storage.QuarantineBlob(ctx, digest)
+	}, func(tx *bolt.Tx, now time.Time) error {
+		return removeBlob(tx, digest)
+	})
+}
+
+// write applies change to the storage and then record to the index. Writes
+// with the same key run one at a time, so the index records them in the
+// order the storage applied them. If the index cannot be updated the change
+// still stands; the index is marked stale.
+func (idx *Index) write(key string, change func() error, record func(tx *bolt.Tx, now time.Time) error) error {
+	idx.rebuilding.RLock()
+	defer idx.rebuilding.RUnlock()
+	defer idx.locks.lock(key)()
+
+	if err := change(); err != nil {
+		return err
+	}
+	if idx.stale.Load() {
+		return nil
+	}
+	now := time.Now().UTC()
+	if err := idx.db.Update(func(tx *bolt.Tx) error { return record(tx, now) }); err != nil {
+		idx.markStale(err)
+	}
+	return nil
+}
+
+// markStale stops answering queries from the index until it is rebuilt
+func (idx *Index) markStale(err error) {
+	if !idx.stale.Swap(true) {
+		logrus.Errorf("Metadata index is out of date and will not be used until rebuilt: %v", err)
+	}
+}
+
+// repositoryBucket returns the bucket of a repository, creating it, and
+// records now as its last update
+func repositoryBucket(tx *bolt.Tx, repository string, now time.Time) (*bolt.Bucket, error) {
+	repo, err := tx.Bucket(bucketRepositories).CreateBucketIfNotExists([]byte(repository))
+	if err != nil {
+		return nil, err
+	}
+	return repo, repo.Put(keyUpdated, formatTime(now))
+}
+
+// putBlob records a blob's size, adjusting the total if it replaces one
+func putBlob(tx *bolt.Tx, digest string, size int64) error {
+	blobs := tx.Bucket(bucketBlobs)
+	previous := decodeInt(blobs.Get([]byte(digest)))
+	if err := addTotal(tx, keySize, size-previous); err != nil {
+		return err
+	}
+	return blobs.Put([]byte(digest), encodeInt(size))
+}
+
+// removeBlob forgets a blob and subtracts its size from the total
+func removeBlob(tx *bolt.Tx, digest string) error {
+	blobs := tx.Bucket(bucketBlobs)
+	value := blobs.Get([]byte(digest))
+	if value == nil {
+		return nil
+	}
+	if err := addTotal(tx, keySize, -decodeInt(value)); err != nil {
+		return err
+	}
+	return blobs.Delete([]byte(digest))
+}
+
+// addTotal adds delta to one of the running totals
+func addTotal(tx *bolt.Tx, key []byte, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	meta := tx.Bucket(bucketMeta)
+	return meta.Put(key, encodeInt(decodeInt(meta.Get(key))+delta))
+}
+
+// addTagCount adds delta to a repository's tag count and the total
+func addTagCount(tx *bolt.Tx, repo *bolt.Bucket, delta int64) error {
+	if err := repo.Put(keyTagCount, encodeInt(decodeInt(repo.Get(keyTagCount))+delta)); err != nil {
+		return err
+	}
+	return addTotal(tx, keyTags, delta)
+}
+
+// forEach decodes every record of a bucket in key order; bucket may be nil
+func forEach[T any](bucket *bolt.Bucket, fn func(key string, record T)) {
+	if bucket == nil {
+		return
+	}
+	bucket.ForEach(func(key, value []byte) error {
+		var record T
+		if json.Unmarshal(value, &record) == nil {
+			fn(string(key), record)
+		}
+		return nil
+	})
+}
+
+// getJSON decodes the record stored under key, reporting whether there was one
+func getJSON(bucket *bolt.Bucket, key string, record interface{}) bool {
+	value := bucket.Get([]byte(key))
+	return value != nil && json.Unmarshal(value, record) == nil
+}
+
+func putJSON(bucket *bolt.Bucket, key string, record interface{}) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), value)
+}
+
+func encodeInt(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+func decodeInt(value []byte) int64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(value))
+}
+
+func formatTime(t time.Time) []byte {
+	if t.IsZero() {
+		return []byte{}
+	}
+	return []byte(t.Format(time.RFC3339Nano))
+}
+
+func parseTime(value []byte) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, string(value))
+	return t
+}
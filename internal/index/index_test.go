@@ -0,0 +1,261 @@
+package index
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"docker-registry-manager/internal/storage"
+)
+
+// openIndex returns a fresh, rebuilt index over store
+func openIndex(t *testing.T, store storage.Storage) *Index {
+	t.Helper()
+	idx, err := Open(store, filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	if err := idx.Rebuild(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+// indexState is what the index answers, without timestamps, which a
+// rebuild cannot recover for entries it has not seen
+type indexState struct {
+	Stats     Stats
+	TotalSize int64
+	Repos     map[string]int
+	Tags      map[string][]storage.TagInfo
+	Blobs     map[string]int64
+	TagCount  int
+}
+
+func state(t *testing.T, idx *Index) indexState {
+	t.Helper()
+	ctx := context.Background()
+	if idx.Stale() {
+		t.Fatal("index is stale")
+	}
+
+	var s indexState
+	var err error
+	if s.Stats, err = idx.Stats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.TotalSize, err = idx.GetTotalStorageSize(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repos, err := idx.Repositories(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Repos = make(map[string]int)
+	s.Tags = make(map[string][]storage.TagInfo)
+	for _, repo := range repos {
+		s.Repos[repo.Name] = repo.TagCount
+		tags, err := idx.Tags(ctx, repo.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range tags {
+			tags[i] = storage.TagInfo{Name: tags[i].Name, Digest: tags[i].Digest, Size: tags[i].Size}
+		}
+		s.Tags[repo.Name] = tags
+	}
+	if s.Blobs, s.TagCount, err = idx.Usage(ctx, func(string) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// checkAgainstRebuild compares the index with a rebuild from its storage
+func checkAgainstRebuild(t *testing.T, idx *Index) {
+	t.Helper()
+	before := state(t, idx)
+	if err := idx.Rebuild(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if after := state(t, idx); !reflect.DeepEqual(before, after) {
+		t.Fatalf("index differs from a rebuild:\nindex:   %+v\nrebuilt: %+v", before, after)
+	}
+}
+
+// workload applies random writes the way the registry makes them: tags and
+// manifests only name what was pushed first, and only unreferenced blobs
+// are deleted, as garbage collection does
+type workload struct {
+	t   *testing.T
+	idx *Index
+	rnd *rand.Rand
+
+	blobs     []string
+	manifests map[string][]string // repository -> digests
+}
+
+func (w *workload) step() {
+	ctx := context.Background()
+	repos := []string{"library/alpine", "team/app", "team/db"}
+	repo := repos[w.rnd.Intn(len(repos))]
+	tag := fmt.Sprintf("v%d", w.rnd.Intn(4))
+
+	switch op := w.rnd.Intn(10); {
+	case op < 2 || len(w.blobs) == 0:
+		data := []byte(fmt.Sprintf("layer %d", w.rnd.Int63()))
+		digest := digestOf(data)
+		if err := w.idx.PutBlob(ctx, digest, data); err != nil {
+			w.t.Fatal(err)
+		}
+		w.blobs = append(w.blobs, digest)
+	case op < 4:
+		layers := ""
+		for i := 0; i < 1+w.rnd.Intn(3); i++ {
+			if i > 0 {
+				layers += ","
+			}
+			layers += fmt.Sprintf(`{"digest":%q}`, w.blobs[w.rnd.Intn(len(w.blobs))])
+		}
+		data := []byte(fmt.Sprintf(`{"schemaVersion":2,"layers":[%s]}`, layers))
+		digest := digestOf(data)
+		if err := w.idx.PutManifest(ctx, repo, digest, data, "application/vnd.oci.image.manifest.v1+json"); err != nil {
+			w.t.Fatal(err)
+		}
+		w.manifests[repo] = append(w.manifests[repo], digest)
+	case op < 6:
+		if digests := w.manifests[repo]; len(digests) > 0 {
+			w.idx.PutTag(ctx, repo, tag, digests[w.rnd.Intn(len(digests))])
+		}
+	case op < 7:
+		w.idx.DeleteTag(ctx, repo, tag)
+	case op < 8:
+		if digests := w.manifests[repo]; len(digests) > 0 {
+			i := w.rnd.Intn(len(digests))
+			w.idx.DeleteManifest(ctx, repo, digests[i])
+			w.manifests[repo] = append(digests[:i:i], digests[i+1:]...)
+		}
+	case op < 9:
+		w.idx.DeleteRepository(ctx, repo)
+		delete(w.manifests, repo)
+	default:
+		w.deleteUnreferencedBlob()
+	}
+}
+
+func (w *workload) deleteUnreferencedBlob() {
+	ctx := context.Background()
+	referenced := make(map[string]bool)
+	repos, _ := w.idx.Storage.ListRepositories(ctx)
+	for _, repo := range repos {
+		digests, _ := w.idx.Storage.ListManifests(ctx, repo)
+		for _, digest := range digests {
+			data, _, err := w.idx.Storage.GetManifest(ctx, repo, digest)
+			if err != nil {
+				w.t.Fatal(err)
+			}
+			blobs, _, _ := storage.References(data)
+			for _, blob := range blobs {
+				referenced[blob] = true
+			}
+		}
+	}
+	for i, digest := range w.blobs {
+		if !referenced[digest] {
+			if err := w.idx.DeleteBlob(ctx, digest); err != nil {
+				w.t.Fatal(err)
+			}
+			w.blobs = append(w.blobs[:i:i], w.blobs[i+1:]...)
+			return
+		}
+	}
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func TestIndexMatchesRebuild(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			w := &workload{
+				t:         t,
+				idx:       openIndex(t, storage.NewMemoryStorage()),
+				rnd:       rand.New(rand.NewSource(seed)),
+				manifests: make(map[string][]string),
+			}
+			for round := 0; round < 5; round++ {
+				for i := 0; i < 40; i++ {
+					w.step()
+				}
+				checkAgainstRebuild(t, w.idx)
+			}
+		})
+	}
+}
+
+// pausingStorage holds PutTag after the storage write, before the index
+// records it
+type pausingStorage struct {
+	storage.Storage
+	applied chan struct{}
+	release chan struct{}
+}
+
+func (p *pausingStorage) PutTag(ctx context.Context, repository, tag, digest string) error {
+	if err := p.Storage.PutTag(ctx, repository, tag, digest); err != nil {
+		return err
+	}
+	p.applied <- struct{}{}
+	<-p.release
+	return nil
+}
+
+func TestIndexConcurrentTagWrites(t *testing.T) {
+	ctx := context.Background()
+	store := &pausingStorage{
+		Storage: storage.NewMemoryStorage(),
+		applied: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	idx := openIndex(t, store)
+	manifest := []byte(`{"schemaVersion":2,"layers":[]}`)
+	if err := idx.PutManifest(ctx, "team/app", digestOf(manifest), manifest, "application/vnd.oci.image.manifest.v1+json"); err != nil {
+		t.Fatal(err)
+	}
+
+	pushed := make(chan error)
+	go func() {
+		pushed <- idx.PutTag(ctx, "team/app", "latest", digestOf(manifest))
+	}()
+	<-store.applied
+
+	// A delete arriving between the push's storage write and its index
+	// update must not be recorded ahead of it
+	deleted := make(chan error)
+	go func() {
+		deleted <- idx.DeleteTag(ctx, "team/app", "latest")
+	}()
+	select {
+	case err := <-deleted:
+		t.Fatalf("delete finished while the push was in progress: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(store.release)
+	if err := <-pushed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+	if tags, _ := idx.ListTags(ctx, "team/app"); len(tags) != 0 {
+		t.Fatalf("tags = %v, want the delete to win", tags)
+	}
+	checkAgainstRebuild(t, idx)
+}
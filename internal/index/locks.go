@@ -0,0 +1,42 @@
+package index
+
+import "sync"
+
+// keyLocks serializes writes per key, so the storage change and the index
+// update of one write are not interleaved with those of another write to
+// the same repository or blob
+type keyLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the lock of one key and how many writers hold or wait for it
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock locks key and returns the function that unlocks it
+func (k *keyLocks) lock(key string) func() {
+	k.mutex.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	l := k.locks[key]
+	if l == nil {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.users++
+	k.mutex.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mutex.Lock()
+		if l.users--; l.users == 0 {
+			delete(k.locks, key)
+		}
+		k.mutex.Unlock()
+	}
+}
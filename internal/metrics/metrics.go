@@ -0,0 +1,371 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/storage"
+)
+
+const namespace = "registry"
+
+// Authentication failure reasons
+const (
+	AuthInvalidCredentials = "invalid_credentials"
+	AuthInvalidClientCert  = "invalid_client_cert"
+	AuthLockedOut          = "locked_out"
+	AuthBackendError       = "backend_error"
+	AuthDenied             = "denied"
+)
+
+// Scrub results
+const (
+	ScrubOK      = "ok"
+	ScrubCorrupt = "corrupt"
+	ScrubError   = "error"
+)
+
+// Upload kinds
+const (
+	UploadMonolithic = "monolithic"
+	UploadChunked    = "chunked"
+)
+
+// Metrics holds the registry's Prometheus collectors. Recording methods are
+// no-ops on a nil *Metrics so callers need not check whether metrics are on.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+
+	blobBytesSent     prometheus.Counter
+	blobBytesReceived prometheus.Counter
+	uploadsInFlight   prometheus.Gauge
+	uploadsCompleted  *prometheus.CounterVec
+
+	gcRuns           prometheus.Counter
+	gcReclaimedBytes prometheus.Counter
+
+	scrubbedBlobs    *prometheus.CounterVec
+	scrubbedBytes    prometheus.Counter
+	quarantinedBlobs prometheus.Counter
+
+	authFailures *prometheus.CounterVec
+}
+
+// New creates the collectors. Storage size and repository/tag counts are
+// read from store when scraped, at most once per cacheTTL.
+func New(store storage.Storage, cacheTTL time.Duration) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by method, route template and status code.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"method", "route", "status"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+
+		blobBytesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "blob",
+			Name:      "bytes_sent_total",
+			Help:      "Blob bytes sent to clients.",
+		}),
+		blobBytesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "blob",
+			Name:      "bytes_received_total",
+			Help:      "Blob bytes received from clients.",
+		}),
+		uploadsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "blob",
+			Name:      "uploads_in_flight",
+			Help:      "Chunked blob uploads started but not yet completed or cancelled.",
+		}),
+		uploadsCompleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "blob",
+			Name:      "uploads_completed_total",
+			Help:      "Completed blob uploads by kind.",
+		}, []string{"kind"}),
+
+		gcRuns: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "gc",
+			Name:      "runs_total",
+			Help:      "Garbage collection runs.",
+		}),
+		gcReclaimedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "gc",
+			Name:      "reclaimed_bytes_total",
+			Help:      "Bytes reclaimed by garbage collection.",
+		}),
+
+		scrubbedBlobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scrub",
+			Name:      "blobs_total",
+			Help:      "Blobs re-verified by the background scrubber by result.",
+		}, []string{"result"}),
+		scrubbedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scrub",
+			Name:      "bytes_total",
+			Help:      "Blob bytes read by the background scrubber.",
+		}),
+		quarantinedBlobs: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scrub",
+			Name:      "quarantined_total",
+			Help:      "Blobs quarantined after failing verification.",
+		}),
+
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "failures_total",
+			Help:      "Failed authentication and authorization attempts by reason.",
+		}, []string{"reason"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.blobBytesSent,
+		m.blobBytesReceived,
+		m.uploadsInFlight,
+		m.uploadsCompleted,
+		m.gcRuns,
+		m.gcReclaimedBytes,
+		m.scrubbedBlobs,
+		m.scrubbedBytes,
+		m.quarantinedBlobs,
+		m.authFailures,
+		newStorageCollector(store, cacheTTL),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	for _, kind := range []string{UploadMonolithic, UploadChunked} {
+		m.uploadsCompleted.WithLabelValues(kind)
+	}
+	for _, result := range []string{ScrubOK, ScrubCorrupt, ScrubError} {
+		m.scrubbedBlobs.WithLabelValues(result)
+	}
+	for _, reason := range []string{AuthInvalidCredentials, AuthLockedOut, AuthBackendError, AuthDenied} {
+		m.authFailures.WithLabelValues(reason)
+	}
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// RequestStarted tracks a request entering the server
+func (m *Metrics) RequestStarted() {
+	if m == nil {
+		return
+	}
+	m.requestsInFlight.Inc()
+}
+
+// RequestFinished records a completed request
+func (m *Metrics) RequestFinished(method, route, status string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requestsInFlight.Dec()
+	m.requests.WithLabelValues(method, route, status).Inc()
+	m.requestDuration.WithLabelValues(method, route, status).Observe(duration.Seconds())
+}
+
+// BlobSent counts blob bytes served
+func (m *Metrics) BlobSent(n int64) {
+	if m == nil {
+		return
+	}
+	m.blobBytesSent.Add(float64(n))
+}
+
+// BlobReceived counts blob bytes uploaded
+func (m *Metrics) BlobReceived(n int64) {
+	if m == nil {
+		return
+	}
+	m.blobBytesReceived.Add(float64(n))
+}
+
+// UploadStarted tracks a new chunked upload
+func (m *Metrics) UploadStarted() {
+	if m == nil {
+		return
+	}
+	m.uploadsInFlight.Inc()
+}
+
+// UploadCancelled tracks a chunked upload that was abandoned
+func (m *Metrics) UploadCancelled() {
+	if m == nil {
+		return
+	}
+	m.uploadsInFlight.Dec()
+}
+
+// UploadCompleted records a finished upload of the given kind
+func (m *Metrics) UploadCompleted(kind string) {
+	if m == nil {
+		return
+	}
+	if kind == UploadChunked {
+		m.uploadsInFlight.Dec()
+	}
+	m.uploadsCompleted.WithLabelValues(kind).Inc()
+}
+
+// GCRun records a garbage collection run that reclaimed bytes
+func (m *Metrics) GCRun(reclaimed int64) {
+	if m == nil {
+		return
+	}
+	m.gcRuns.Inc()
+	m.gcReclaimedBytes.Add(float64(reclaimed))
+}
+
+// BlobScrubbed records a blob verification by the background scrubber
+func (m *Metrics) BlobScrubbed(result string, bytes int64) {
+	if m == nil {
+		return
+	}
+	m.scrubbedBlobs.WithLabelValues(result).Inc()
+	m.scrubbedBytes.Add(float64(bytes))
+}
+
+// BlobQuarantined records a blob moved to quarantine
+func (m *Metrics) BlobQuarantined() {
+	if m == nil {
+		return
+	}
+	m.quarantinedBlobs.Inc()
+}
+
+// AuthFailure counts a failed authentication or authorization
+func (m *Metrics) AuthFailure(reason string) {
+	if m == nil {
+		return
+	}
+	m.authFailures.WithLabelValues(reason).Inc()
+}
+
+// storageCollector reports repository, tag and storage size gauges. Walking
+// the storage is expensive, so results are cached between scrapes.
+type storageCollector struct {
+	store    storage.Storage
+	cacheTTL time.Duration
+
+	sizeDesc         *prometheus.Desc
+	repositoriesDesc *prometheus.Desc
+	tagsDesc         *prometheus.Desc
+
+	mutex        sync.Mutex
+	collectedAt  time.Time
+	size         float64
+	repositories float64
+	tags         float64
+}
+
+func newStorageCollector(store storage.Storage, cacheTTL time.Duration) *storageCollector {
+	return &storageCollector{
+		store:    store,
+		cacheTTL: cacheTTL,
+		sizeDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "storage", "size_bytes"),
+			"Total size of the registry storage.", nil, nil),
+		repositoriesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "storage", "repositories"),
+			"Number of repositories.", nil, nil),
+		tagsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "storage", "tags"),
+			"Number of tags across all repositories.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.sizeDesc
+	ch <- c.repositoriesDesc
+	ch <- c.tagsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.collectedAt.IsZero() || time.Since(c.collectedAt) >= c.cacheTTL {
+		c.refresh()
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.sizeDesc, prometheus.GaugeValue, c.size)
+	ch <- prometheus.MustNewConstMetric(c.repositoriesDesc, prometheus.GaugeValue, c.repositories)
+	ch <- prometheus.MustNewConstMetric(c.tagsDesc, prometheus.GaugeValue, c.tags)
+}
+
+// refresh reads the gauges from storage. Callers must hold the lock.
+func (c *storageCollector) refresh() {
+	ctx := context.Background()
+
+	repositories, err := c.store.ListRepositories(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to list repositories for metrics: %v", err)
+		return
+	}
+
+	tags := 0
+	for _, repo := range repositories {
+		repoTags, err := c.store.ListTags(ctx, repo)
+		if err != nil {
+			continue
+		}
+		tags += len(repoTags)
+	}
+
+	size, err := c.store.GetTotalStorageSize(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to get total storage size for metrics: %v", err)
+		return
+	}
+
+	c.repositories = float64(len(repositories))
+	c.tags = float64(tags)
+	c.size = float64(size)
+	c.collectedAt = time.Now()
+}
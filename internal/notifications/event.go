@@ -0,0 +1,63 @@
+package notifications
+
+import (
+	"time"
+)
+
+// EventsMediaType is the content type of an event envelope
+const EventsMediaType = "application/vnd.docker.distribution.events.v1+json"
+
+// Event actions
+const (
+	ActionPush   = "push"
+	ActionPull   = "pull"
+	ActionDelete = "delete"
+)
+
+// Envelope is the body POSTed to endpoints
+type Envelope struct {
+	Events []Event `json:"events"`
+}
+
+// Event describes a registry operation, following the layout of the
+// distribution notification event so existing receivers can consume it
+type Event struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Target    Target    `json:"target"`
+	Request   Request   `json:"request"`
+	Actor     Actor     `json:"actor"`
+	Source    Source    `json:"source"`
+}
+
+// Target is the object the event is about
+type Target struct {
+	MediaType  string `json:"mediaType,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Length     int64  `json:"length,omitempty"`
+	Repository string `json:"repository"`
+	URL        string `json:"url,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+}
+
+// Request describes the HTTP request that caused the event
+type Request struct {
+	ID        string `json:"id,omitempty"`
+	Addr      string `json:"addr"`
+	Host      string `json:"host"`
+	Method    string `json:"method"`
+	UserAgent string `json:"useragent"`
+}
+
+// Actor is who performed the operation
+type Actor struct {
+	Name string `json:"name,omitempty"`
+}
+
+// Source identifies the registry instance that emitted the event
+type Source struct {
+	Addr       string `json:"addr"`
+	InstanceID string `json:"instanceID"`
+}
@@ -0,0 +1,478 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/config"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the request body
+const SignatureHeader = "X-Registry-Signature"
+
+// corruptEventError marks a queue entry that cannot be read or decoded, so
+// retrying it is pointless
+type corruptEventError struct {
+	err error
+}
+
+func (e *corruptEventError) Error() string {
+	return "corrupt queue entry: " + e.err.Error()
+}
+
+func (e *corruptEventError) Unwrap() error {
+	return e.err
+}
+
+// EndpointStatus reports the delivery state of one endpoint
+type EndpointStatus struct {
+	Name        string     `json:"name"`
+	URL         string     `json:"url"`
+	Disabled    bool       `json:"disabled"`
+	Pending     int        `json:"pending"`
+	Delivered   int64      `json:"delivered"`
+	Failed      int64      `json:"failed"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	NextRetry   *time.Time `json:"next_retry,omitempty"`
+}
+
+// Notifier fans events out to webhook endpoints. Each endpoint has its own
+// on-disk queue and delivery goroutine, so a slow or unreachable receiver
+// neither blocks requests nor delays other endpoints.
+type Notifier struct {
+	endpoints []*endpoint
+	source    Source
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// endpoint is the delivery state of a single webhook receiver
+type endpoint struct {
+	config config.NotificationEndpoint
+	dir    string
+	client *http.Client
+	wake   chan struct{}
+
+	mutex   sync.Mutex
+	status  EndpointStatus
+	running bool
+}
+
+// NewNotifier creates the queues under queuePath and starts delivery
+func NewNotifier(endpoints []config.NotificationEndpoint, queuePath string) (*Notifier, error) {
+	instanceID, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+
+	n := &Notifier{
+		source: Source{Addr: hostname, InstanceID: instanceID},
+		stop:   make(chan struct{}),
+	}
+
+	seen := make(map[string]bool)
+	for _, cfg := range endpoints {
+		if cfg.Name == "" || cfg.URL == "" {
+			return nil, fmt.Errorf("notification endpoint requires name and url")
+		}
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("duplicate notification endpoint %q", cfg.Name)
+		}
+		seen[cfg.Name] = true
+
+		for _, pattern := range cfg.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("notification endpoint %s: invalid repository pattern %q", cfg.Name, pattern)
+			}
+		}
+		for _, action := range cfg.Events {
+			if action != ActionPush && action != ActionPull && action != ActionDelete {
+				return nil, fmt.Errorf("notification endpoint %s: unknown event %q", cfg.Name, action)
+			}
+		}
+
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = 5 * time.Second
+		}
+		if cfg.MaxRetries <= 0 {
+			cfg.MaxRetries = 10
+		}
+		if cfg.Backoff <= 0 {
+			cfg.Backoff = time.Second
+		}
+		if cfg.MaxBackoff <= 0 {
+			cfg.MaxBackoff = 5 * time.Minute
+		}
+
+		dir := filepath.Join(queuePath, cfg.Name)
+		if err := os.MkdirAll(filepath.Join(dir, "dead"), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create notification queue: %w", err)
+		}
+
+		e := &endpoint{
+			config: cfg,
+			dir:    dir,
+			client: &http.Client{Timeout: cfg.Timeout},
+			wake:   make(chan struct{}, 1),
+			status: EndpointStatus{
+				Name:     cfg.Name,
+				URL:      cfg.URL,
+				Disabled: cfg.Disabled,
+			},
+		}
+
+		pending, err := e.queued()
+		if err != nil {
+			return nil, err
+		}
+		e.status.Pending = len(pending)
+
+		if dead, err := os.ReadDir(filepath.Join(dir, "dead")); err == nil {
+			e.status.Failed = int64(len(dead))
+		}
+
+		n.endpoints = append(n.endpoints, e)
+	}
+
+	for _, e := range n.endpoints {
+		if e.config.Disabled {
+			continue
+		}
+		n.wg.Add(1)
+		e.setRunning(true)
+		go func(e *endpoint) {
+			defer n.wg.Done()
+			defer e.setRunning(false)
+			e.run(n.stop)
+		}(e)
+	}
+
+	return n, nil
+}
+
+// Publish queues event for every endpoint whose filters match
+func (n *Notifier) Publish(event Event) {
+	if event.ID == "" {
+		id, err := randomID()
+		if err != nil {
+			logrus.Errorf("Failed to generate notification event ID: %v", err)
+			return
+		}
+		event.ID = id
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	event.Source = n.source
+
+	for _, e := range n.endpoints {
+		if e.config.Disabled || !e.matches(event) {
+			continue
+		}
+		if err := e.enqueue(event); err != nil {
+			logrus.Errorf("Failed to queue notification for %s: %v", e.config.Name, err)
+		}
+	}
+}
+
+// Status returns the delivery state of every endpoint
+func (n *Notifier) Status() []EndpointStatus {
+	statuses := make([]EndpointStatus, 0, len(n.endpoints))
+	for _, e := range n.endpoints {
+		e.mutex.Lock()
+		statuses = append(statuses, e.status)
+		e.mutex.Unlock()
+	}
+	return statuses
+}
+
+// CheckHealth reports an error if the delivery goroutine of an enabled
+// endpoint is no longer running
+func (n *Notifier) CheckHealth(ctx context.Context) error {
+	for _, e := range n.endpoints {
+		if e.config.Disabled {
+			continue
+		}
+		e.mutex.Lock()
+		running := e.running
+		e.mutex.Unlock()
+		if !running {
+			return fmt.Errorf("delivery to %s is not running", e.config.Name)
+		}
+	}
+	return nil
+}
+
+// Close stops delivery; queued events remain on disk for the next start
+func (n *Notifier) Close() {
+	close(n.stop)
+	n.wg.Wait()
+}
+
+// matches reports whether the endpoint wants the event
+func (e *endpoint) matches(event Event) bool {
+	if len(e.config.Events) > 0 {
+		found := false
+		for _, action := range e.config.Events {
+			if action == event.Action {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(e.config.Repositories) > 0 {
+		for _, pattern := range e.config.Repositories {
+			if matched, _ := path.Match(pattern, event.Target.Repository); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// enqueue writes the event to the endpoint's queue directory
+func (e *endpoint) enqueue(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), event.ID)
+	tmpPath := filepath.Join(e.dir, "."+name)
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(e.dir, name)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	e.mutex.Lock()
+	e.status.Pending++
+	e.mutex.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// setRunning records whether the delivery goroutine is active
+func (e *endpoint) setRunning(running bool) {
+	e.mutex.Lock()
+	e.running = running
+	e.mutex.Unlock()
+}
+
+// queued lists pending event files, oldest first
+func (e *endpoint) queued() ([]string, error) {
+	entries, err := os.ReadDir(e.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// run delivers queued events in order until stop is closed
+func (e *endpoint) run(stop <-chan struct{}) {
+	attempts := 0
+
+	for {
+		files, err := e.queued()
+		if err != nil {
+			logrus.Errorf("Failed to read notification queue for %s: %v", e.config.Name, err)
+		}
+
+		if len(files) == 0 {
+			select {
+			case <-e.wake:
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		file := filepath.Join(e.dir, files[0])
+		err = e.deliver(file)
+		if err == nil {
+			os.Remove(file)
+			attempts = 0
+			e.recordSuccess()
+			continue
+		}
+
+		var corrupt *corruptEventError
+		if errors.As(err, &corrupt) {
+			logrus.Warnf("Moving notification %s for %s to the dead letter queue: %v", files[0], e.config.Name, err)
+			e.deadLetter(files[0])
+			attempts = 0
+			e.recordFailure(err, true, 0)
+			continue
+		}
+
+		attempts++
+		if attempts > e.config.MaxRetries {
+			logrus.Errorf("Giving up on notification %s for %s after %d attempts: %v", files[0], e.config.Name, attempts, err)
+			e.deadLetter(files[0])
+			attempts = 0
+			e.recordFailure(err, true, 0)
+			continue
+		}
+
+		backoff := e.config.Backoff
+		for i := 1; i < attempts && backoff < e.config.MaxBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > e.config.MaxBackoff {
+			backoff = e.config.MaxBackoff
+		}
+
+		logrus.Warnf("Notification delivery to %s failed (attempt %d), retrying in %s: %v", e.config.Name, attempts, backoff, err)
+		e.recordFailure(err, false, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// deadLetter moves a queued event out of the queue into dead/. If that
+// fails it is removed, so it cannot block the events behind it.
+func (e *endpoint) deadLetter(name string) {
+	file := filepath.Join(e.dir, name)
+	if err := os.Rename(file, filepath.Join(e.dir, "dead", name)); err != nil {
+		logrus.Errorf("Failed to move notification %s for %s to the dead letter queue, removing it: %v", name, e.config.Name, err)
+		os.Remove(file)
+	}
+}
+
+// deliver POSTs one queued event. An entry that cannot be read or decoded
+// returns a *corruptEventError.
+func (e *endpoint) deliver(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return &corruptEventError{err: err}
+	}
+
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return &corruptEventError{err: err}
+	}
+
+	body, err := json.Marshal(Envelope{Events: []Event{event}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", EventsMediaType)
+	for key, value := range e.config.Headers {
+		req.Header.Set(key, value)
+	}
+	if e.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(e.config.Secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// recordSuccess updates status after a delivery
+func (e *endpoint) recordSuccess() {
+	now := time.Now()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.status.Delivered++
+	e.status.LastSuccess = &now
+	e.status.NextRetry = nil
+	if e.status.Pending > 0 {
+		e.status.Pending--
+	}
+}
+
+// recordFailure updates status after a failed attempt; dropped marks an
+// event that exhausted its retries
+func (e *endpoint) recordFailure(err error, dropped bool, retryIn time.Duration) {
+	now := time.Now()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.status.LastFailure = &now
+	e.status.LastError = err.Error()
+	e.status.NextRetry = nil
+	if dropped {
+		e.status.Failed++
+		if e.status.Pending > 0 {
+			e.status.Pending--
+		}
+	} else {
+		next := now.Add(retryIn)
+		e.status.NextRetry = &next
+	}
+}
+
+// randomID returns a random hex identifier
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
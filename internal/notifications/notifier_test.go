@@ -0,0 +1,67 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"docker-registry-manager/internal/config"
+)
+
+func TestCorruptQueueEntry(t *testing.T) {
+	var received atomic.Int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope Envelope
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil || len(envelope.Events) != 1 {
+			http.Error(w, "bad envelope", http.StatusBadRequest)
+			return
+		}
+		received.Add(1)
+	}))
+	defer receiver.Close()
+
+	// An entry left behind by a crash mid-write, queued ahead of a good one
+	queuePath := t.TempDir()
+	dir := filepath.Join(queuePath, "ci")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001-corrupt.json"), []byte(`{"id":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier, err := NewNotifier([]config.NotificationEndpoint{{Name: "ci", URL: receiver.URL}}, queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifier.Close()
+	notifier.Publish(Event{Action: ActionPush, Target: Target{Repository: "library/alpine", Tag: "latest"}})
+
+	var status EndpointStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status = notifier.Status()[0]
+		if status.Pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queue not drained: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status.Delivered != 1 || status.Failed != 1 || received.Load() != 1 {
+		t.Fatalf("status = %+v with %d received, want 1 delivered and 1 failed", status, received.Load())
+	}
+	if status.LastError == "" {
+		t.Fatal("no error recorded for the corrupt entry")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dead", "00000000000000000001-corrupt.json")); err != nil {
+		t.Fatalf("corrupt entry not in the dead letter queue: %v", err)
+	}
+}
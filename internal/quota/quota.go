@@ -0,0 +1,235 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"docker-registry-manager/internal/config"
+	"docker-registry-manager/internal/index"
+	"docker-registry-manager/internal/storage"
+)
+
+// Status is a quota with the current usage of its repositories
+type Status struct {
+	Scope      string `json:"scope"`
+	Repository string `json:"repository,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	// MaxSize and MaxTags are the limits; 0 means no limit
+	MaxSize int64 `json:"max_size"`
+	MaxTags int   `json:"max_tags"`
+	Size    int64 `json:"size"`
+	Tags    int   `json:"tags"`
+}
+
+// Exceeded reports whether usage has reached a limit
+func (s Status) Exceeded() bool {
+	return (s.MaxSize > 0 && s.Size >= s.MaxSize) || (s.MaxTags > 0 && s.Tags >= s.MaxTags)
+}
+
+// ExceededError is returned when a push would go over a quota
+type ExceededError struct {
+	Status Status
+	// Limit names what would be exceeded, "size" or "tags"
+	Limit string
+	// Added is the size the push would add, if known
+	Added int64
+}
+
+func (e *ExceededError) Error() string {
+	if e.Limit == "tags" {
+		return fmt.Sprintf("quota exceeded for %s: %d of %d tags used", e.Status.Scope, e.Status.Tags, e.Status.MaxTags)
+	}
+	msg := fmt.Sprintf("quota exceeded for %s: %s of %s used", e.Status.Scope, storage.FormatMB(e.Status.Size), storage.FormatMB(e.Status.MaxSize))
+	if e.Added > 0 {
+		msg += ", push needs " + storage.FormatMB(e.Added)
+	}
+	return msg
+}
+
+// Checker measures repository usage against the configured quotas. Usage is
+// counted from the unique blobs referenced by each quota's manifests, so
+// layers shared between its repositories count once. With the metadata
+// index it is read from the index; otherwise the storage is walked.
+type Checker struct {
+	store storage.Storage
+	index *index.Index
+}
+
+// New returns a Checker reading store, and idx if it is not nil
+func New(store storage.Storage, idx *index.Index) *Checker {
+	return &Checker{store: store, index: idx}
+}
+
+// Status returns the quotas applying to repository with their usage
+func (c *Checker) Status(ctx context.Context, quotas []config.QuotaConfig, repository string) ([]Status, error) {
+	statuses := []Status{}
+	for _, quota := range quotas {
+		if !quota.Applies(repository) {
+			continue
+		}
+		status, _, err := c.status(ctx, quota)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// CheckUpload refuses a blob upload to repository when a size quota is
+// already full, or the blob, if its digest and size are known, would not
+// fit. A blob only counts once a manifest references it, so this is checked
+// again when the manifest is pushed.
+func (c *Checker) CheckUpload(ctx context.Context, quotas []config.QuotaConfig, repository, digest string, size int64) error {
+	for _, quota := range quotas {
+		if !quota.Applies(repository) || quota.MaxSizeMB == 0 {
+			continue
+		}
+		status, blobs, err := c.status(ctx, quota)
+		if err != nil {
+			return err
+		}
+		// blobs only holds stored blobs, so one referenced by a manifest
+		// ahead of its upload is still counted here
+		added := size
+		if _, counted := blobs[digest]; counted {
+			added = 0
+		}
+		if status.Size >= status.MaxSize || status.Size+added > status.MaxSize {
+			return &ExceededError{Status: status, Limit: "size", Added: added}
+		}
+	}
+	return nil
+}
+
+// CheckManifest refuses a manifest push to repository that would take a
+// quota over its limits: by the blobs it references that the quota does not
+// hold yet, or by its tag if that is new. The referenced blobs, other than
+// foreign layers, must already be stored; a missing one is reported as not
+// existing.
+func (c *Checker) CheckManifest(ctx context.Context, quotas []config.QuotaConfig, repository, tag string, data []byte) error {
+	var references []string
+	loaded, newTag := false, false
+	for _, quota := range quotas {
+		if !quota.Applies(repository) {
+			continue
+		}
+		if !loaded {
+			loaded = true
+			var err error
+			if references, err = storage.PushedBlobs(data); err != nil {
+				return err
+			}
+			if tag != "" {
+				if _, err := c.store.GetTagDigest(ctx, repository, tag); os.IsNotExist(err) {
+					newTag = true
+				} else if err != nil {
+					return err
+				}
+			}
+		}
+
+		status, blobs, err := c.status(ctx, quota)
+		if err != nil {
+			return err
+		}
+		var added int64
+		for _, digest := range references {
+			if _, counted := blobs[digest]; counted {
+				continue
+			}
+			size, err := c.store.GetBlobSize(ctx, digest)
+			if err != nil {
+				return err
+			}
+			blobs[digest] = size
+			added += size
+		}
+
+		if status.MaxSize > 0 && status.Size+added > status.MaxSize {
+			return &ExceededError{Status: status, Limit: "size", Added: added}
+		}
+		if status.MaxTags > 0 && newTag && status.Tags+1 > status.MaxTags {
+			return &ExceededError{Status: status, Limit: "tags"}
+		}
+	}
+	return nil
+}
+
+// status returns the quota's usage and the blobs counted in it
+func (c *Checker) status(ctx context.Context, quota config.QuotaConfig) (Status, map[string]int64, error) {
+	blobs, tags, err := c.usage(ctx, quota)
+	if err != nil {
+		return Status{}, nil, err
+	}
+	status := Status{
+		Scope:      quota.Scope(),
+		Repository: quota.Repository,
+		Namespace:  quota.Namespace,
+		MaxSize:    quota.MaxSize(),
+		MaxTags:    quota.MaxTags,
+		Tags:       tags,
+	}
+	for _, size := range blobs {
+		status.Size += size
+	}
+	return status, blobs, nil
+}
+
+// usage returns the unique stored blobs of the quota's repositories with
+// their sizes, and their number of tags
+func (c *Checker) usage(ctx context.Context, quota config.QuotaConfig) (map[string]int64, int, error) {
+	if c.index != nil {
+		blobs, tags, err := c.index.Usage(ctx, quota.Applies)
+		if !errors.Is(err, index.ErrStale) {
+			return blobs, tags, err
+		}
+	}
+
+	repositories, err := c.store.ListRepositories(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	blobs := make(map[string]int64)
+	var tags int
+	for _, repository := range repositories {
+		if !quota.Applies(repository) {
+			continue
+		}
+		repoTags, err := c.store.ListTags(ctx, repository)
+		if err != nil {
+			return nil, 0, err
+		}
+		tags += len(repoTags)
+
+		digests, err := c.store.ListManifests(ctx, repository)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, digest := range digests {
+			data, _, err := c.store.GetManifest(ctx, repository, digest)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, 0, err
+			}
+			references, _, _ := storage.References(data)
+			for _, blob := range references {
+				if _, counted := blobs[blob]; counted {
+					continue
+				}
+				size, err := c.store.GetBlobSize(ctx, blob)
+				if os.IsNotExist(err) {
+					continue
+				} else if err != nil {
+					return nil, 0, err
+				}
+				blobs[blob] = size
+			}
+		}
+	}
+	return blobs, tags, nil
+}
@@ -0,0 +1,190 @@
+package quota
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"docker-registry-manager/internal/config"
+	"docker-registry-manager/internal/index"
+	"docker-registry-manager/internal/storage"
+)
+
+// putBlob stores a blob of size bytes and returns its digest
+func putBlob(t *testing.T, store storage.Storage, seed string, size int) string {
+	t.Helper()
+	data := make([]byte, size)
+	copy(data, seed)
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if err := store.PutBlob(context.Background(), digest, data); err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+// manifest returns an image manifest referencing config and layers
+func manifest(config string, layers ...string) []byte {
+	data := fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":%q},"layers":[`, config)
+	for i, layer := range layers {
+		if i > 0 {
+			data += ","
+		}
+		data += fmt.Sprintf(`{"digest":%q}`, layer)
+	}
+	return []byte(data + "]}")
+}
+
+// putManifest stores data in repository, tagged with tag if it is set
+func putManifest(t *testing.T, store storage.Storage, repository, tag string, data []byte) {
+	t.Helper()
+	ctx := context.Background()
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if err := store.PutManifest(ctx, repository, digest, data, "application/vnd.oci.image.manifest.v1+json"); err != nil {
+		t.Fatal(err)
+	}
+	if tag != "" {
+		if err := store.PutTag(ctx, repository, tag, digest); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// exceeded returns the limit err reports as exceeded, or "" if it is not an
+// *ExceededError
+func exceeded(err error) string {
+	var exceededErr *ExceededError
+	if errors.As(err, &exceededErr) {
+		return exceededErr.Limit
+	}
+	return ""
+}
+
+func TestNamespaceSizeLimit(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	quotas := []config.QuotaConfig{{Namespace: "team", MaxSizeMB: 1}}
+	checker := New(store, nil)
+
+	cfg := putBlob(t, store, "config", 100)
+	shared := putBlob(t, store, "shared", 600*1024)
+	putManifest(t, store, "team/app", "v1", manifest(cfg, shared))
+
+	// A layer shared with another repository of the namespace counts once
+	if err := checker.CheckManifest(ctx, quotas, "team/web", "v1", manifest(cfg, shared)); err != nil {
+		t.Fatalf("shared layer: %v", err)
+	}
+	putManifest(t, store, "team/web", "v1", manifest(cfg, shared))
+
+	statuses, err := checker.Status(ctx, quotas, "team/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Size != 600*1024+100 {
+		t.Fatalf("status = %+v, want one quota using %d bytes", statuses, 600*1024+100)
+	}
+
+	big := putBlob(t, store, "big", 500*1024)
+	if err := checker.CheckManifest(ctx, quotas, "team/web", "v2", manifest(cfg, big)); exceeded(err) != "size" {
+		t.Fatalf("manifest over the namespace limit: got %v, want size exceeded", err)
+	}
+	if err := checker.CheckUpload(ctx, quotas, "team/db", "sha256:other", 500*1024); exceeded(err) != "size" {
+		t.Fatalf("upload over the namespace limit: got %v, want size exceeded", err)
+	}
+
+	// Repositories outside the namespace are not limited
+	if err := checker.CheckManifest(ctx, quotas, "teams/web", "v2", manifest(cfg, big)); err != nil {
+		t.Fatalf("other namespace: %v", err)
+	}
+	if err := checker.CheckUpload(ctx, quotas, "team/db", "sha256:small", 100*1024); err != nil {
+		t.Fatalf("upload within the limit: %v", err)
+	}
+}
+
+func TestTagLimit(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	quotas := []config.QuotaConfig{{Repository: "app", MaxTags: 2}}
+	checker := New(store, nil)
+
+	cfg := putBlob(t, store, "config", 10)
+	data := manifest(cfg)
+	for _, tag := range []string{"v1", "v2"} {
+		if err := checker.CheckManifest(ctx, quotas, "app", tag, data); err != nil {
+			t.Fatalf("tag %s: %v", tag, err)
+		}
+		putManifest(t, store, "app", tag, data)
+	}
+
+	if err := checker.CheckManifest(ctx, quotas, "app", "v3", data); exceeded(err) != "tags" {
+		t.Fatalf("third tag: got %v, want tags exceeded", err)
+	}
+	// Moving an existing tag or pushing by digest adds no tag
+	if err := checker.CheckManifest(ctx, quotas, "app", "v1", manifest(cfg, cfg)); err != nil {
+		t.Fatalf("existing tag: %v", err)
+	}
+	if err := checker.CheckManifest(ctx, quotas, "app", "", data); err != nil {
+		t.Fatalf("push by digest: %v", err)
+	}
+	// The limit is per repository
+	if err := checker.CheckManifest(ctx, quotas, "other", "v3", data); err != nil {
+		t.Fatalf("other repository: %v", err)
+	}
+}
+
+func TestManifestBeforeBlobs(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	quotas := []config.QuotaConfig{{Namespace: "team", MaxSizeMB: 1}}
+	checker := New(store, nil)
+
+	cfg := putBlob(t, store, "config", 100)
+	first := putBlob(t, store, "first", 512*1024)
+	putManifest(t, store, "team/app", "v1", manifest(cfg, first))
+
+	// A manifest naming a blob that has not been uploaded is refused
+	missing := "sha256:" + hex.EncodeToString(make([]byte, 32))
+	err := checker.CheckManifest(ctx, quotas, "team/app", "v2", manifest(cfg, missing))
+	if !os.IsNotExist(err) {
+		t.Fatalf("missing blob: got %v, want not exist", err)
+	}
+
+	// Even if such a manifest reached the storage, its missing blob must not
+	// count as already held, or the blob's upload would be let through
+	putManifest(t, store, "team/app", "v2", manifest(cfg, missing))
+
+	idx, err := index.Open(store, filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if err := idx.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, checker := range map[string]*Checker{"storage": checker, "index": New(idx, idx)} {
+		t.Run(name, func(t *testing.T) {
+			statuses, err := checker.Status(ctx, quotas, "team/app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if statuses[0].Size != 512*1024+100 {
+				t.Fatalf("size = %d, want only stored blobs (%d)", statuses[0].Size, 512*1024+100)
+			}
+			if err := checker.CheckUpload(ctx, quotas, "team/app", missing, 900*1024); exceeded(err) != "size" {
+				t.Fatalf("upload of the missing blob: got %v, want size exceeded", err)
+			}
+
+			// A blob the quota does hold adds nothing
+			if err := checker.CheckUpload(ctx, quotas, "team/app", first, 512*1024); err != nil {
+				t.Fatalf("upload of a held blob: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// idleBucketTTL is how long an untouched bucket is kept before being dropped
+const idleBucketTTL = 10 * time.Minute
+
+// bucket is a token bucket refilled lazily on each request
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// Limiter is a set of token buckets keyed by client or repository
+type Limiter struct {
+	rate  float64
+	burst float64
+	// now is the clock, replaced in tests
+	now func() time.Time
+
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+// NewLimiter creates a limiter allowing rate requests per second per key
+// with bursts of up to burst requests
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:      rate,
+		burst:     float64(burst),
+		now:       time.Now,
+		buckets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token for key. When the bucket is empty it returns false and
+// how long until a token becomes available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastPrune) > idleBucketTTL {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idleBucketTTL {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*l.rate)
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
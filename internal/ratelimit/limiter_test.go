@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		key     string
+		allowed bool
+		wait    time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst then empty", []step{
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", false, 500 * time.Millisecond},
+		}},
+		{"tokens refill at the rate", []step{
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{250 * time.Millisecond, "a", false, 250 * time.Millisecond},
+			{250 * time.Millisecond, "a", true, 0},
+			{0, "a", false, 500 * time.Millisecond},
+		}},
+		{"refill stops at the burst", []step{
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{10 * time.Second, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", false, 500 * time.Millisecond},
+		}},
+		{"keys have their own buckets", []step{
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", true, 0},
+			{0, "a", false, 500 * time.Millisecond},
+			{0, "b", true, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			limiter := NewLimiter(2, 3)
+			limiter.now = clock.now
+
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				allowed, wait := limiter.Allow(s.key)
+				if allowed != s.allowed || wait != s.wait {
+					t.Fatalf("step %d: got %v, %s; want %v, %s", i, allowed, wait, s.allowed, s.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterPrunesIdleBuckets(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewLimiter(1, 1)
+	limiter.now = clock.now
+	limiter.lastPrune = clock.now()
+
+	limiter.Allow("a")
+	clock.advance(idleBucketTTL + time.Second)
+	limiter.Allow("b")
+
+	if _, exists := limiter.buckets["a"]; exists || len(limiter.buckets) != 1 {
+		t.Fatalf("buckets after idle period = %v, want only b", limiter.buckets)
+	}
+}
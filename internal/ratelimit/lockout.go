@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// lockoutEntry tracks recent failures for one key
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Lockout counts authentication failures per key (client IP, username) and
+// locks a key out for exponentially growing periods once it exceeds the
+// allowed number of failures
+type Lockout struct {
+	maxFailures int
+	base        time.Duration
+	max         time.Duration
+	resetAfter  time.Duration
+	// now is the clock, replaced in tests
+	now func() time.Time
+
+	entries map[string]*lockoutEntry
+	mutex   sync.Mutex
+}
+
+// NewLockout creates a tracker. After maxFailures consecutive failures a key
+// is locked for base, doubling with every further failure up to max. Failures
+// older than resetAfter are forgotten.
+func NewLockout(maxFailures int, base, max, resetAfter time.Duration) *Lockout {
+	return &Lockout{
+		maxFailures: maxFailures,
+		base:        base,
+		max:         max,
+		resetAfter:  resetAfter,
+		now:         time.Now,
+		entries:     make(map[string]*lockoutEntry),
+	}
+}
+
+// Check returns how long key remains locked out, or zero if it may try
+func (l *Lockout) Check(key string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry, exists := l.entries[key]
+	if !exists {
+		return 0
+	}
+
+	if remaining := entry.lockedUntil.Sub(l.now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Fail records a failure for key and returns the resulting lockout, if any
+func (l *Lockout) Fail(key string) time.Duration {
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	entry, exists := l.entries[key]
+	if !exists {
+		entry = &lockoutEntry{}
+		l.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures < l.maxFailures {
+		return 0
+	}
+
+	lockout := l.base
+	for i := l.maxFailures; i < entry.failures && lockout < l.max; i++ {
+		lockout *= 2
+	}
+	if lockout > l.max {
+		lockout = l.max
+	}
+
+	entry.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// Succeed clears the failure history of key
+func (l *Lockout) Succeed(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.entries, key)
+}
+
+// prune forgets keys whose last failure is old and that are not locked.
+// Callers must hold the lock.
+func (l *Lockout) prune(now time.Time) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastFailure) > l.resetAfter && now.After(entry.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock moved only by the test
+type fakeClock struct {
+	t time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func TestLockout(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		action  string // fail, check or succeed
+		want    time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"backoff doubles up to the maximum", []step{
+			{0, "fail", 0},
+			{0, "fail", 0},
+			{0, "fail", 30 * time.Second},
+			{0, "fail", time.Minute},
+			{0, "fail", 2 * time.Minute},
+			{0, "fail", 4 * time.Minute},
+			{0, "fail", 4 * time.Minute},
+			{0, "check", 4 * time.Minute},
+		}},
+		{"lockout runs out", []step{
+			{0, "fail", 0},
+			{0, "fail", 0},
+			{0, "fail", 30 * time.Second},
+			{10 * time.Second, "check", 20 * time.Second},
+			{20 * time.Second, "check", 0},
+		}},
+		{"failures are forgotten after a quiet period", []step{
+			{0, "fail", 0},
+			{0, "fail", 0},
+			{16 * time.Minute, "fail", 0},
+			{0, "fail", 0},
+			{0, "fail", 30 * time.Second},
+		}},
+		{"failures within the reset period count", []step{
+			{0, "fail", 0},
+			{0, "fail", 0},
+			{14 * time.Minute, "fail", 30 * time.Second},
+		}},
+		{"success clears the count", []step{
+			{0, "fail", 0},
+			{0, "fail", 0},
+			{0, "succeed", 0},
+			{0, "fail", 0},
+			{0, "fail", 0},
+			{0, "check", 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			lockout := NewLockout(3, 30*time.Second, 4*time.Minute, 15*time.Minute)
+			lockout.now = clock.now
+
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				var got time.Duration
+				switch s.action {
+				case "fail":
+					got = lockout.Fail("ip:203.0.113.7")
+				case "check":
+					got = lockout.Check("ip:203.0.113.7")
+				case "succeed":
+					lockout.Succeed("ip:203.0.113.7")
+				}
+				if got != s.want {
+					t.Fatalf("step %d (%s): got %s, want %s", i, s.action, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLockoutKeysIndependent(t *testing.T) {
+	lockout := NewLockout(1, time.Minute, time.Hour, time.Hour)
+	lockout.now = newFakeClock().now
+
+	if got := lockout.Fail("user:alice"); got != time.Minute {
+		t.Fatalf("Fail = %s, want 1m", got)
+	}
+	if got := lockout.Check("user:bob"); got != 0 {
+		t.Fatalf("other key locked for %s", got)
+	}
+}
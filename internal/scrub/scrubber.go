@@ -0,0 +1,337 @@
+package scrub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"docker-registry-manager/internal/metrics"
+	"docker-registry-manager/internal/storage"
+)
+
+const (
+	// minWait is the shortest pause between passes, so a blob that keeps
+	// failing to read does not turn the scrubber into a busy loop
+	minWait = time.Minute
+	// saveInterval limits how often progress is written during a pass
+	saveInterval = 30 * time.Second
+	// chunkSize is how much is read between rate limit checks
+	chunkSize = 256 << 10
+)
+
+// Options configures a Scrubber
+type Options struct {
+	// Interval is how often each blob is re-verified
+	Interval time.Duration
+	// BytesPerSecond limits the read rate
+	BytesPerSecond int64
+	// StatePath is the file recording when each blob was last verified
+	StatePath string
+	Metrics   *metrics.Metrics
+	// OnQuarantine is called after a blob failing verification is quarantined
+	OnQuarantine func(digest string)
+}
+
+// Scrubber re-hashes stored blobs on a rolling schedule, oldest
+// verification first, and quarantines any whose content no longer matches
+// its digest
+type Scrubber struct {
+	store   storage.Storage
+	options Options
+
+	mutex    sync.Mutex
+	verified map[string]time.Time
+	saved    time.Time
+	lastErr  error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New loads the verification state and starts scrubbing in the background
+func New(store storage.Storage, options Options) (*Scrubber, error) {
+	if options.Interval <= 0 || options.BytesPerSecond <= 0 {
+		return nil, fmt.Errorf("scrub interval and rate must be positive")
+	}
+
+	s := &Scrubber{
+		store:    store,
+		options:  options,
+		verified: make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(s.done)
+		s.run(ctx)
+	}()
+
+	return s, nil
+}
+
+// Close stops scrubbing and saves progress
+func (s *Scrubber) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// LastVerified returns when a blob was last verified, or the zero time
+func (s *Scrubber) LastVerified(digest string) time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.verified[digest]
+}
+
+// CheckHealth reports a stopped scrubber or a failed last pass
+func (s *Scrubber) CheckHealth(ctx context.Context) error {
+	select {
+	case <-s.done:
+		return fmt.Errorf("scrubber is not running")
+	default:
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.lastErr != nil {
+		return fmt.Errorf("last scrub pass failed: %w", s.lastErr)
+	}
+	return nil
+}
+
+// run scrubs until ctx is cancelled, sleeping until the next blob is due
+func (s *Scrubber) run(ctx context.Context) {
+	for {
+		next, err := s.pass(ctx)
+		if saveErr := s.save(); saveErr != nil {
+			logrus.Errorf("Failed to save scrub state: %v", saveErr)
+			if err == nil {
+				err = fmt.Errorf("failed to save scrub state: %w", saveErr)
+			}
+		}
+		s.mutex.Lock()
+		s.lastErr = err
+		s.mutex.Unlock()
+
+		wait := time.Until(next)
+		if wait < minWait {
+			wait = minWait
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// pass verifies every blob that is due and returns when the next one will
+// be. Failing to read a single blob is not an error of the pass.
+func (s *Scrubber) pass(ctx context.Context) (time.Time, error) {
+	blobs, err := s.store.ListBlobs(ctx)
+	if err != nil {
+		logrus.Errorf("Scrub failed to list blobs: %v", err)
+		return time.Now(), fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	s.mutex.Lock()
+	present := make(map[string]bool, len(blobs))
+	for _, digest := range blobs {
+		present[digest] = true
+	}
+	// Forget blobs deleted since the last pass
+	for digest := range s.verified {
+		if !present[digest] {
+			delete(s.verified, digest)
+		}
+	}
+	last := make(map[string]time.Time, len(blobs))
+	for _, digest := range blobs {
+		last[digest] = s.verified[digest]
+	}
+	s.mutex.Unlock()
+
+	sort.SliceStable(blobs, func(i, j int) bool {
+		return last[blobs[i]].Before(last[blobs[j]])
+	})
+
+	throttle := newThrottle(s.options.BytesPerSecond)
+	for _, digest := range blobs {
+		if due := last[digest].Add(s.options.Interval); due.After(time.Now()) {
+			// Sorted oldest first, so nothing after this is due either
+			return due, nil
+		}
+
+		s.verify(ctx, digest, throttle)
+		if ctx.Err() != nil {
+			return time.Now(), nil
+		}
+		if time.Since(s.saved) >= saveInterval {
+			if err := s.save(); err != nil {
+				logrus.Errorf("Failed to save scrub state: %v", err)
+			}
+		}
+	}
+
+	return time.Now().Add(s.options.Interval), nil
+}
+
+// verify re-hashes one blob, quarantining it if the content does not match
+func (s *Scrubber) verify(ctx context.Context, digest string, throttle *throttle) {
+	actual, n, err := s.hash(ctx, digest, throttle)
+	if err != nil {
+		return
+	}
+
+	if actual != digest {
+		// A re-push may have replaced the blob while it was read, so only
+		// content that reads back the same is corrupt
+		again, _, err := s.hash(ctx, digest, throttle)
+		if err != nil {
+			return
+		}
+		if again != actual && again != digest {
+			logrus.Infof("Blob %s changed while it was scrubbed; checking it again next pass", digest)
+			return
+		}
+		actual = again
+	}
+	if actual == digest {
+		s.options.Metrics.BlobScrubbed(metrics.ScrubOK, n)
+		s.mutex.Lock()
+		s.verified[digest] = time.Now().UTC()
+		s.mutex.Unlock()
+		return
+	}
+
+	s.options.Metrics.BlobScrubbed(metrics.ScrubCorrupt, n)
+	if err := s.store.QuarantineBlob(ctx, digest); err != nil {
+		logrus.Errorf("Blob %s is corrupt (content digest %s) but could not be quarantined: %v", digest, actual, err)
+		return
+	}
+	logrus.Warnf("Quarantined corrupt blob %s (content digest %s)", digest, actual)
+	s.options.Metrics.BlobQuarantined()
+	if s.options.OnQuarantine != nil {
+		s.options.OnQuarantine(digest)
+	}
+
+	s.mutex.Lock()
+	delete(s.verified, digest)
+	s.mutex.Unlock()
+}
+
+// hash reads a blob and returns the digest of its content. Read errors
+// are logged and counted.
+func (s *Scrubber) hash(ctx context.Context, digest string, throttle *throttle) (string, int64, error) {
+	reader, _, err := s.store.GetBlob(ctx, digest)
+	if err != nil {
+		// Most likely deleted since it was listed
+		logrus.Debugf("Scrub skipped blob %s: %v", digest, err)
+		s.options.Metrics.BlobScrubbed(metrics.ScrubError, 0)
+		return "", 0, err
+	}
+
+	hash := sha256.New()
+	n, err := throttle.copy(ctx, hash, reader)
+	reader.Close()
+	if err != nil {
+		if ctx.Err() == nil {
+			logrus.Errorf("Scrub failed to read blob %s: %v", digest, err)
+			s.options.Metrics.BlobScrubbed(metrics.ScrubError, n)
+		}
+		return "", n, err
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), n, nil
+}
+
+// load reads the verification state; a missing file means nothing has been
+// verified yet
+func (s *Scrubber) load() error {
+	data, err := os.ReadFile(s.options.StatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read scrub state: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.verified); err != nil {
+		return fmt.Errorf("failed to parse scrub state: %w", err)
+	}
+	return nil
+}
+
+// save writes the verification state to disk
+func (s *Scrubber) save() error {
+	s.mutex.Lock()
+	data, err := json.MarshalIndent(s.verified, "", "  ")
+	s.saved = time.Now()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.options.StatePath), 0755); err != nil {
+		return err
+	}
+	tmpPath := s.options.StatePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.options.StatePath)
+}
+
+// throttle paces reads across a pass to an average rate
+type throttle struct {
+	rate  int64
+	start time.Time
+	bytes int64
+}
+
+func newThrottle(bytesPerSecond int64) *throttle {
+	return &throttle{rate: bytesPerSecond, start: time.Now()}
+}
+
+// copy copies src to dst, sleeping as needed to stay within the rate
+func (t *throttle) copy(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, chunkSize)
+	var written int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			dst.Write(buf[:n])
+			written += int64(n)
+			t.bytes += int64(n)
+
+			ahead := time.Duration(float64(t.bytes)/float64(t.rate)*float64(time.Second)) - time.Since(t.start)
+			if ahead > 0 {
+				select {
+				case <-ctx.Done():
+					return written, ctx.Err()
+				case <-time.After(ahead):
+				}
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
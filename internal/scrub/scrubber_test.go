@@ -0,0 +1,148 @@
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-registry-manager/internal/storage"
+)
+
+// unlistable is a store whose blobs cannot be listed
+type unlistable struct {
+	storage.Storage
+}
+
+func (unlistable) ListBlobs(context.Context) ([]string, error) {
+	return nil, errors.New("permission denied")
+}
+
+func newScrubber(t *testing.T, store storage.Storage) *Scrubber {
+	t.Helper()
+	s, err := New(store, Options{
+		Interval:       time.Hour,
+		BytesPerSecond: 1 << 20,
+		StatePath:      filepath.Join(t.TempDir(), "state.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// waitForPass waits until the first pass has been saved
+func waitForPass(t *testing.T, s *Scrubber) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mutex.Lock()
+		saved := !s.saved.IsZero()
+		s.mutex.Unlock()
+		if saved {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first scrub pass did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	s := newScrubber(t, storage.NewMemoryStorage())
+	waitForPass(t, s)
+	if err := s.CheckHealth(context.Background()); err != nil {
+		t.Fatalf("running scrubber: %v", err)
+	}
+
+	s.Close()
+	if err := s.CheckHealth(context.Background()); err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Fatalf("stopped scrubber: got %v, want not running", err)
+	}
+}
+
+func TestCheckHealthFailedPass(t *testing.T) {
+	s := newScrubber(t, unlistable{storage.NewMemoryStorage()})
+	defer s.Close()
+	waitForPass(t, s)
+
+	// The state is saved before the pass result is recorded
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := s.CheckHealth(context.Background())
+		if err != nil && strings.Contains(err.Error(), "permission denied") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v, want the list error", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// replacing is a store whose blob reads first return the given stale
+// contents, as when a re-push replaces a blob during a read
+type replacing struct {
+	storage.Storage
+	reads [][]byte
+}
+
+func (r *replacing) GetBlob(ctx context.Context, digest string) (io.ReadCloser, int64, error) {
+	if len(r.reads) == 0 {
+		return r.Storage.GetBlob(ctx, digest)
+	}
+	data := r.reads[0]
+	r.reads = r.reads[1:]
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func TestVerifyRereadsBeforeQuarantine(t *testing.T) {
+	good := []byte("layer")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(good))
+
+	tests := []struct {
+		name        string
+		stored      []byte
+		reads       [][]byte
+		quarantined bool
+		verified    bool
+	}{
+		{"intact", good, nil, false, true},
+		{"replaced during the read", good, [][]byte{[]byte("lay")}, false, true},
+		{"still changing", good, [][]byte{[]byte("l"), []byte("la")}, false, false},
+		{"corrupt", []byte("bit rot"), nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			memory := storage.NewMemoryStorage()
+			if err := memory.PutBlob(ctx, digest, tt.stored); err != nil {
+				t.Fatal(err)
+			}
+			s := &Scrubber{
+				store:    &replacing{Storage: memory, reads: tt.reads},
+				verified: make(map[string]time.Time),
+			}
+
+			s.verify(ctx, digest, newThrottle(1<<30))
+
+			quarantined, err := memory.IsBlobQuarantined(ctx, digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quarantined != tt.quarantined {
+				t.Errorf("quarantined = %v, want %v", quarantined, tt.quarantined)
+			}
+			if verified := !s.LastVerified(digest).IsZero(); verified != tt.verified {
+				t.Errorf("verified = %v, want %v", verified, tt.verified)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archive layout: blobs first so an import never stores a manifest before
+// its layers, then each repository's manifests followed by its index entry.
+//
+//	blobs/<digest>
+//	repositories/<name>/manifests/<digest>
+//	repositories/<name>/repository.json
+const (
+	archiveBlobs      = "blobs/"
+	archiveRepos      = "repositories/"
+	archiveManifests  = "/manifests/"
+	archiveRepository = "/repository.json"
+)
+
+// archiveIndex is the repository entry written after a repository's manifests
+type archiveIndex struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Tags        map[string]string `json:"tags"`
+	MediaTypes  map[string]string `json:"media_types"`
+}
+
+// ArchiveStats counts what an export or import transferred
+type ArchiveStats struct {
+	Repositories int
+	Manifests    int
+	Blobs        int
+	Bytes        int64
+}
+
+// Export writes the given repositories, or all of them if none are given,
+// to w as a gzipped tar archive that Import can read
+func Export(ctx context.Context, s Storage, w io.Writer, repositories []string) (*ArchiveStats, error) {
+	if len(repositories) == 0 {
+		all, err := s.ListRepositories(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories: %w", err)
+		}
+		repositories = all
+	}
+
+	// Collect everything first; the blobs must precede the manifests
+	stats := &ArchiveStats{}
+	indexes := make([]archiveIndex, 0, len(repositories))
+	manifests := make(map[string][]byte)
+	var blobs []string
+	seen := make(map[string]bool)
+
+	for _, repository := range repositories {
+		index := archiveIndex{
+			Name:       repository,
+			Tags:       make(map[string]string),
+			MediaTypes: make(map[string]string),
+		}
+
+		digests, err := s.ListManifests(ctx, repository)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list manifests of %s: %w", repository, err)
+		}
+		if len(digests) == 0 {
+			return nil, fmt.Errorf("repository %s not found", repository)
+		}
+		for _, digest := range digests {
+			data, mediaType, err := s.GetManifest(ctx, repository, digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read manifest %s@%s: %w", repository, digest, err)
+			}
+			manifests[repository+"@"+digest] = data
+			index.MediaTypes[digest] = mediaType
+
+			refs, _, err := References(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse manifest %s@%s: %w", repository, digest, err)
+			}
+			for _, blob := range refs {
+				if !seen[blob] {
+					seen[blob] = true
+					blobs = append(blobs, blob)
+				}
+			}
+		}
+
+		tags, err := s.ListTags(ctx, repository)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of %s: %w", repository, err)
+		}
+		for _, tag := range tags {
+			digest, err := s.GetTagDigest(ctx, repository, tag)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read tag %s:%s: %w", repository, tag, err)
+			}
+			index.Tags[tag] = digest
+		}
+
+		if index.Description, err = s.GetRepositoryDescription(ctx, repository); err != nil {
+			return nil, fmt.Errorf("failed to read description of %s: %w", repository, err)
+		}
+
+		indexes = append(indexes, index)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	for _, digest := range blobs {
+		size, err := exportBlob(ctx, s, tw, digest, now)
+		if err != nil {
+			return nil, err
+		}
+		stats.Blobs++
+		stats.Bytes += size
+	}
+
+	for _, index := range indexes {
+		digests := make([]string, 0, len(index.MediaTypes))
+		for digest := range index.MediaTypes {
+			digests = append(digests, digest)
+		}
+		sort.Strings(digests)
+
+		for _, digest := range digests {
+			data := manifests[index.Name+"@"+digest]
+			if err := writeEntry(tw, archiveRepos+index.Name+archiveManifests+digest, data, now); err != nil {
+				return nil, err
+			}
+			stats.Manifests++
+		}
+
+		data, err := json.MarshalIndent(index, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := writeEntry(tw, archiveRepos+index.Name+archiveRepository, data, now); err != nil {
+			return nil, err
+		}
+		stats.Repositories++
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// exportBlob streams one blob into the archive and returns its size
+func exportBlob(ctx context.Context, s Storage, tw *tar.Writer, digest string, modTime time.Time) (int64, error) {
+	reader, size, err := s.GetBlob(ctx, digest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	defer reader.Close()
+
+	header := &tar.Header{
+		Name:    archiveBlobs + digest,
+		Mode:    0644,
+		Size:    size,
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(tw, reader); err != nil {
+		return 0, fmt.Errorf("failed to archive blob %s: %w", digest, err)
+	}
+	return size, nil
+}
+
+// writeEntry adds a regular file to the archive
+func writeEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Import reads an archive written by Export into s. Blob and manifest
+// contents are verified against their digests; existing tags are overwritten.
+func Import(ctx context.Context, s Storage, r io.Reader) (*ArchiveStats, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not an export archive: %w", err)
+	}
+	defer gz.Close()
+
+	stats := &ArchiveStats{}
+	// Manifests are held until their repository's index names the media types
+	pending := make(map[string]map[string][]byte)
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return stats, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+		name := path.Clean(header.Name)
+
+		switch {
+		case strings.HasPrefix(name, archiveBlobs):
+			digest := strings.TrimPrefix(name, archiveBlobs)
+			if err := checkDigest(digest, data); err != nil {
+				return stats, fmt.Errorf("blob %s: %w", digest, err)
+			}
+			if _, err := s.GetBlobSize(ctx, digest); err != nil {
+				if err := s.PutBlob(ctx, digest, data); err != nil {
+					return stats, fmt.Errorf("failed to store blob %s: %w", digest, err)
+				}
+			}
+			stats.Blobs++
+			stats.Bytes += int64(len(data))
+
+		case strings.HasPrefix(name, archiveRepos) && strings.HasSuffix(name, archiveRepository):
+			var index archiveIndex
+			if err := json.Unmarshal(data, &index); err != nil {
+				return stats, fmt.Errorf("failed to parse %s: %w", name, err)
+			}
+			if !validArchiveName(index.Name) {
+				return stats, fmt.Errorf("invalid repository name %q in %s", index.Name, header.Name)
+			}
+			if err := importRepository(ctx, s, index, pending[index.Name]); err != nil {
+				return stats, err
+			}
+			stats.Manifests += len(pending[index.Name])
+			stats.Repositories++
+			delete(pending, index.Name)
+
+		case strings.HasPrefix(name, archiveRepos) && strings.Contains(name, archiveManifests):
+			// Repository names may contain "manifests" but digests have no slash
+			i := strings.LastIndex(name, archiveManifests)
+			repository, digest := name[len(archiveRepos):i], name[i+len(archiveManifests):]
+			if !validArchiveName(repository) {
+				return stats, fmt.Errorf("invalid repository name in %s", header.Name)
+			}
+			if err := checkDigest(digest, data); err != nil {
+				return stats, fmt.Errorf("manifest %s@%s: %w", repository, digest, err)
+			}
+			if pending[repository] == nil {
+				pending[repository] = make(map[string][]byte)
+			}
+			pending[repository][digest] = data
+
+		default:
+			return stats, fmt.Errorf("unexpected archive entry %s", header.Name)
+		}
+	}
+
+	if len(pending) > 0 {
+		var missing []string
+		for repository := range pending {
+			missing = append(missing, repository)
+		}
+		sort.Strings(missing)
+		return stats, fmt.Errorf("archive is truncated: no repository index for %s", strings.Join(missing, ", "))
+	}
+	return stats, nil
+}
+
+// importRepository stores a repository's manifests, then its tags and
+// description
+func importRepository(ctx context.Context, s Storage, index archiveIndex, manifests map[string][]byte) error {
+	for digest, data := range manifests {
+		if err := s.PutManifest(ctx, index.Name, digest, data, index.MediaTypes[digest]); err != nil {
+			return fmt.Errorf("failed to store manifest %s@%s: %w", index.Name, digest, err)
+		}
+	}
+	for tag, digest := range index.Tags {
+		if _, ok := manifests[digest]; !ok {
+			return fmt.Errorf("tag %s:%s points to manifest %s missing from the archive", index.Name, tag, digest)
+		}
+		if err := s.PutTag(ctx, index.Name, tag, digest); err != nil {
+			return fmt.Errorf("failed to store tag %s:%s: %w", index.Name, tag, err)
+		}
+	}
+	if index.Description != "" {
+		if err := s.PutRepositoryDescription(ctx, index.Name, index.Description); err != nil {
+			return fmt.Errorf("failed to store description of %s: %w", index.Name, err)
+		}
+	}
+	return nil
+}
+
+// validArchiveName rejects repository names that would escape the storage
+// directory
+func validArchiveName(name string) bool {
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDigest verifies that data hashes to digest
+func checkDigest(digest string, data []byte) error {
+	if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); actual != digest {
+		return fmt.Errorf("content digest is %s", actual)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// tempPrefix names files staged in the uploads directory before they are
+// renamed into place
+const tempPrefix = "tmp-"
+
+// staleTempAge is how old a staged file must be before it is treated as
+// left behind by an interrupted write. Another process, such as a running
+// server while an offline command opens the same store, may be writing
+// younger ones.
+const staleTempAge = 24 * time.Hour
+
+// pendingFile is a file to be written by writeFiles
+type pendingFile struct {
+	path string
+	data []byte
+}
+
+// writeFile atomically replaces path with data, so a crash leaves either
+// the old content or the new, never a truncated file
+func (fs *FilesystemStorage) writeFile(path string, data []byte) error {
+	return fs.writeFiles(pendingFile{path, data})
+}
+
+// writeFiles stages and syncs every file before renaming any into place,
+// then syncs the directories so the renames survive a crash. Files are
+// renamed in the order given, so the last one is the commit point: readers
+// that look for it never see it without the others.
+func (fs *FilesystemStorage) writeFiles(files ...pendingFile) error {
+	staged := make([]string, 0, len(files))
+	defer func() {
+		// Anything still staged was not renamed because of an error
+		for _, tmpPath := range staged {
+			if tmpPath != "" {
+				os.Remove(tmpPath)
+			}
+		}
+	}()
+
+	for _, file := range files {
+		if err := fs.ensureDir(filepath.Dir(file.path)); err != nil {
+			return err
+		}
+		tmpPath, err := fs.stage(file.data)
+		if err != nil {
+			return err
+		}
+		staged = append(staged, tmpPath)
+	}
+
+	dirs := make(map[string]bool)
+	for i, file := range files {
+		if err := os.Rename(staged[i], file.path); err != nil {
+			return err
+		}
+		staged[i] = ""
+		dirs[filepath.Dir(file.path)] = true
+	}
+
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stage writes data to a synced temporary file in the uploads directory,
+// which is on the same filesystem as everything else so it can be renamed
+func (fs *FilesystemStorage) stage(data []byte) (string, error) {
+	file, err := os.CreateTemp(filepath.Join(fs.basePath, "uploads"), tempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := file.Name()
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	// CreateTemp uses 0600; stored files have always been world-readable
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// ensureDir creates dir and any missing parents, syncing each parent so
+// the new entries are durable before files are renamed into them
+func (fs *FilesystemStorage) ensureDir(dir string) error {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return nil
+	}
+
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		if err := fs.ensureDir(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return syncDir(parent)
+}
+
+// removeStaleTemp deletes files staged by a write that was interrupted,
+// leaving those younger than staleTempAge to the writer that may own them
+func (fs *FilesystemStorage) removeStaleTemp() {
+	uploadsDir := filepath.Join(fs.basePath, "uploads")
+	entries, err := os.ReadDir(uploadsDir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-staleTempAge)
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(uploadsDir, entry.Name()))
+		}
+	}
+}
+
+// syncFile flushes a file's content to disk
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir flushes directory entries to disk. Windows cannot open
+// directories for syncing and does not need it for renames to be durable.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
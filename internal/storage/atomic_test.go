@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staged returns the temporary files left in the uploads directory
+func staged(t *testing.T, fs *FilesystemStorage) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(fs.basePath, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), tempPrefix) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+// blockPath puts a non-empty directory at path, so renaming a file onto it
+// fails whatever the permissions of the process
+func blockPath(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(path, "block"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveStaleTemp(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFilesystemStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A write interrupted after staging leaves its temporary file behind
+	stale := filepath.Join(dir, "uploads", tempPrefix+"12345")
+	if err := os.WriteFile(stale, []byte("half written"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-staleTempAge - time.Minute)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	// A recent one may belong to a server writing to the same store
+	inFlight := filepath.Join(dir, "uploads", tempPrefix+"67890")
+	if err := os.WriteFile(inFlight, []byte("being written"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Upload sessions share the directory and must survive
+	session := filepath.Join(dir, "uploads", "0b7e7c2e-upload")
+	if err := os.WriteFile(session, []byte("chunk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if names := staged(t, fs); len(names) != 2 {
+		t.Fatalf("staged files before restart = %v, want two", names)
+	}
+
+	fs, err = NewFilesystemStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := staged(t, fs); len(names) != 1 || names[0] != filepath.Base(inFlight) {
+		t.Fatalf("staged files after restart = %v, want only the recent one", names)
+	}
+	if _, err := os.Stat(session); err != nil {
+		t.Fatalf("upload session removed: %v", err)
+	}
+}
+
+func TestWriteFileReadOnlyDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("directory permissions do not apply to root")
+	}
+	ctx := context.Background()
+	fs, err := NewFilesystemStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.PutTag(ctx, "app", "latest", "sha256:old"); err != nil {
+		t.Fatal(err)
+	}
+
+	tagsDir := filepath.Join(fs.basePath, "repositories", "app", "tags")
+	if err := os.Chmod(tagsDir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(tagsDir, 0755)
+
+	if err := fs.PutTag(ctx, "app", "latest", "sha256:new"); err == nil {
+		t.Fatal("PutTag into a read-only directory succeeded")
+	}
+	if digest, err := fs.GetTagDigest(ctx, "app", "latest"); err != nil || digest != "sha256:old" {
+		t.Fatalf("tag after failed write = %q, %v; want the old digest", digest, err)
+	}
+	entries, err := os.ReadDir(tagsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("tags directory holds %d entries, want only the tag", len(entries))
+	}
+	if names := staged(t, fs); len(names) != 0 {
+		t.Fatalf("staged files after failed write = %v, want none", names)
+	}
+}
+
+func TestWriteFileRenameFails(t *testing.T) {
+	fs, err := NewFilesystemStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(fs.basePath, "descriptions", "app")
+	blockPath(t, path)
+
+	if err := fs.writeFile(path, []byte("new content")); err == nil {
+		t.Fatal("writeFile onto a directory succeeded")
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		t.Fatalf("target after failed write: %v, %v; want it untouched", info, err)
+	}
+	if names := staged(t, fs); len(names) != 0 {
+		t.Fatalf("staged files after failed write = %v, want none", names)
+	}
+}
+
+func TestPutManifestWritesMetadataFirst(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFilesystemStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`{"schemaVersion":2}`)
+	digest := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	manifestPath := filepath.Join(fs.basePath, "repositories", "app", "manifests", digest)
+
+	// Stop the write at the metadata, as a crash between the two renames
+	// would: the manifest must not be visible without it
+	blockPath(t, manifestPath+".meta")
+	if err := fs.PutManifest(ctx, "app", digest, data, "application/vnd.oci.image.manifest.v1+json"); err == nil {
+		t.Fatal("PutManifest with its metadata path blocked succeeded")
+	}
+	if _, err := os.Stat(manifestPath); !os.IsNotExist(err) {
+		t.Fatalf("manifest visible without metadata: %v", err)
+	}
+	if names := staged(t, fs); len(names) != 0 {
+		t.Fatalf("staged files after failed write = %v, want none", names)
+	}
+
+	if err := os.RemoveAll(manifestPath + ".meta"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.PutManifest(ctx, "app", digest, data, "application/vnd.oci.image.manifest.v1+json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, mediaType, err := fs.GetManifest(ctx, "app", digest); err != nil || mediaType != "application/vnd.oci.image.manifest.v1+json" {
+		t.Fatalf("GetManifest = %q, %v", mediaType, err)
+	}
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
@@ -35,14 +36,16 @@ func NewFilesystemStorage(basePath string) (*FilesystemStorage, error) {
 		}
 	}
 
-	return &FilesystemStorage{
+	fs := &FilesystemStorage{
 		basePath: basePath,
 		uploads:  make(map[string]*BlobUpload),
-	}, nil
+	}
+	fs.removeStaleTemp()
+	return fs, nil
 }
 
 // ListRepositories returns a list of all repositories
-func (fs *FilesystemStorage) ListRepositories() ([]string, error) {
+func (fs *FilesystemStorage) ListRepositories(ctx context.Context) ([]string, error) {
 	repoPath := filepath.Join(fs.basePath, "repositories")
 
 	var repositories []string
@@ -69,8 +72,32 @@ func (fs *FilesystemStorage) ListRepositories() ([]string, error) {
 	return repositories, err
 }
 
+// DeleteRepository removes a repository's tags, manifests and description.
+// Blobs are shared between repositories and are left for garbage collection.
+func (fs *FilesystemStorage) DeleteRepository(ctx context.Context, repository string) error {
+	repoPath := filepath.Join(fs.basePath, "repositories", repository)
+	if _, err := os.Stat(filepath.Join(repoPath, "manifests")); err != nil {
+		return err
+	}
+
+	// Nested repositories live below this one, so only remove what is ours
+	for _, dir := range []string{"tags", "manifests"} {
+		if err := os.RemoveAll(filepath.Join(repoPath, dir)); err != nil {
+			return err
+		}
+	}
+	os.Remove(repoPath) // Fails harmlessly if nested repositories remain
+
+	descPath := filepath.Join(fs.basePath, "descriptions", repository+".md")
+	if err := os.Remove(descPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
 // ListTags returns a list of tags for a repository
-func (fs *FilesystemStorage) ListTags(repository string) ([]string, error) {
+func (fs *FilesystemStorage) ListTags(ctx context.Context, repository string) ([]string, error) {
 	tagsPath := filepath.Join(fs.basePath, "repositories", repository, "tags")
 
 	if _, err := os.Stat(tagsPath); os.IsNotExist(err) {
@@ -93,7 +120,7 @@ func (fs *FilesystemStorage) ListTags(repository string) ([]string, error) {
 }
 
 // GetTagDigest returns the digest for a tag
-func (fs *FilesystemStorage) GetTagDigest(repository, tag string) (string, error) {
+func (fs *FilesystemStorage) GetTagDigest(ctx context.Context, repository, tag string) (string, error) {
 	tagPath := filepath.Join(fs.basePath, "repositories", repository, "tags", tag)
 
 	data, err := os.ReadFile(tagPath)
@@ -105,24 +132,19 @@ func (fs *FilesystemStorage) GetTagDigest(repository, tag string) (string, error
 }
 
 // PutTag creates or updates a tag
-func (fs *FilesystemStorage) PutTag(repository, tag, digest string) error {
+func (fs *FilesystemStorage) PutTag(ctx context.Context, repository, tag, digest string) error {
 	tagPath := filepath.Join(fs.basePath, "repositories", repository, "tags", tag)
-
-	if err := os.MkdirAll(filepath.Dir(tagPath), 0755); err != nil {
-		return err
-	}
-
-	return os.WriteFile(tagPath, []byte(digest), 0644)
+	return fs.writeFile(tagPath, []byte(digest))
 }
 
 // DeleteTag removes a tag
-func (fs *FilesystemStorage) DeleteTag(repository, tag string) error {
+func (fs *FilesystemStorage) DeleteTag(ctx context.Context, repository, tag string) error {
 	tagPath := filepath.Join(fs.basePath, "repositories", repository, "tags", tag)
 	return os.Remove(tagPath)
 }
 
 // GetManifest returns manifest data and media type
-func (fs *FilesystemStorage) GetManifest(repository, digest string) ([]byte, string, error) {
+func (fs *FilesystemStorage) GetManifest(ctx context.Context, repository, digest string) ([]byte, string, error) {
 	manifestPath := filepath.Join(fs.basePath, "repositories", repository, "manifests", digest)
 
 	data, err := os.ReadFile(manifestPath)
@@ -148,7 +170,7 @@ func (fs *FilesystemStorage) GetManifest(repository, digest string) ([]byte, str
 }
 
 // GetManifestInfo returns manifest size and media type
-func (fs *FilesystemStorage) GetManifestInfo(repository, digest string) (int64, string, error) {
+func (fs *FilesystemStorage) GetManifestInfo(ctx context.Context, repository, digest string) (int64, string, error) {
 	manifestPath := filepath.Join(fs.basePath, "repositories", repository, "manifests", digest)
 
 	info, err := os.Stat(manifestPath)
@@ -174,19 +196,9 @@ func (fs *FilesystemStorage) GetManifestInfo(repository, digest string) (int64,
 }
 
 // PutManifest stores a manifest
-func (fs *FilesystemStorage) PutManifest(repository, digest string, data []byte, mediaType string) error {
+func (fs *FilesystemStorage) PutManifest(ctx context.Context, repository, digest string, data []byte, mediaType string) error {
 	manifestPath := filepath.Join(fs.basePath, "repositories", repository, "manifests", digest)
 
-	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
-		return err
-	}
-
-	// Write manifest data
-	if err := os.WriteFile(manifestPath, data, 0644); err != nil {
-		return err
-	}
-
-	// Write metadata
 	metadata := struct {
 		MediaType string `json:"mediaType"`
 	}{
@@ -194,12 +206,17 @@ func (fs *FilesystemStorage) PutManifest(repository, digest string, data []byte,
 	}
 
 	metaData, _ := json.Marshal(metadata)
-	metaPath := manifestPath + ".meta"
-	return os.WriteFile(metaPath, metaData, 0644)
+
+	// Metadata lands first, so a manifest is never visible without its
+	// media type
+	return fs.writeFiles(
+		pendingFile{manifestPath + ".meta", metaData},
+		pendingFile{manifestPath, data},
+	)
 }
 
 // DeleteManifest removes a manifest
-func (fs *FilesystemStorage) DeleteManifest(repository, digest string) error {
+func (fs *FilesystemStorage) DeleteManifest(ctx context.Context, repository, digest string) error {
 	manifestPath := filepath.Join(fs.basePath, "repositories", repository, "manifests", digest)
 
 	// Remove manifest file
@@ -214,8 +231,31 @@ func (fs *FilesystemStorage) DeleteManifest(repository, digest string) error {
 	return nil
 }
 
+// ListManifests returns the digests of all manifests in a repository,
+// tagged or not
+func (fs *FilesystemStorage) ListManifests(ctx context.Context, repository string) ([]string, error) {
+	manifestsPath := filepath.Join(fs.basePath, "repositories", repository, "manifests")
+
+	files, err := os.ReadDir(manifestsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	var digests []string
+	for _, file := range files {
+		if !file.IsDir() && !strings.HasSuffix(file.Name(), ".meta") {
+			digests = append(digests, file.Name())
+		}
+	}
+
+	return digests, nil
+}
+
 // GetBlob returns a blob reader and size
-func (fs *FilesystemStorage) GetBlob(digest string) (io.ReadCloser, int64, error) {
+func (fs *FilesystemStorage) GetBlob(ctx context.Context, digest string) (io.ReadCloser, int64, error) {
 	blobPath := fs.getBlobPath(digest)
 
 	info, err := os.Stat(blobPath)
@@ -232,7 +272,7 @@ func (fs *FilesystemStorage) GetBlob(digest string) (io.ReadCloser, int64, error
 }
 
 // GetBlobSize returns the size of a blob
-func (fs *FilesystemStorage) GetBlobSize(digest string) (int64, error) {
+func (fs *FilesystemStorage) GetBlobSize(ctx context.Context, digest string) (int64, error) {
 	blobPath := fs.getBlobPath(digest)
 
 	info, err := os.Stat(blobPath)
@@ -244,24 +284,62 @@ func (fs *FilesystemStorage) GetBlobSize(digest string) (int64, error) {
 }
 
 // PutBlob stores a blob
-func (fs *FilesystemStorage) PutBlob(digest string, data []byte) error {
-	blobPath := fs.getBlobPath(digest)
-
-	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+func (fs *FilesystemStorage) PutBlob(ctx context.Context, digest string, data []byte) error {
+	if err := fs.writeFile(fs.getBlobPath(digest), data); err != nil {
 		return err
 	}
 
-	return os.WriteFile(blobPath, data, 0644)
+	// Fresh content replaces a quarantined copy
+	os.Remove(fs.getQuarantinePath(digest))
+	return nil
 }
 
 // DeleteBlob removes a blob
-func (fs *FilesystemStorage) DeleteBlob(digest string) error {
+func (fs *FilesystemStorage) DeleteBlob(ctx context.Context, digest string) error {
 	blobPath := fs.getBlobPath(digest)
 	return os.Remove(blobPath)
 }
 
+// ListBlobs returns the digests of all stored blobs
+func (fs *FilesystemStorage) ListBlobs(ctx context.Context) ([]string, error) {
+	blobsPath := filepath.Join(fs.basePath, "blobs")
+
+	var digests []string
+	err := filepath.WalkDir(blobsPath, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			digests = append(digests, "sha256:"+entry.Name())
+		}
+		return nil
+	})
+
+	return digests, err
+}
+
+// QuarantineBlob moves a blob out of the blob store into the quarantine
+// directory, where it is kept for inspection but no longer served
+func (fs *FilesystemStorage) QuarantineBlob(ctx context.Context, digest string) error {
+	quarantinePath := fs.getQuarantinePath(digest)
+	if err := os.MkdirAll(filepath.Dir(quarantinePath), 0755); err != nil {
+		return err
+	}
+	return os.Rename(fs.getBlobPath(digest), quarantinePath)
+}
+
+// IsBlobQuarantined reports whether a blob was quarantined and has not been
+// pushed again since
+func (fs *FilesystemStorage) IsBlobQuarantined(ctx context.Context, digest string) (bool, error) {
+	_, err := os.Stat(fs.getQuarantinePath(digest))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // StartBlobUpload initiates a new blob upload
-func (fs *FilesystemStorage) StartBlobUpload() (string, error) {
+func (fs *FilesystemStorage) StartBlobUpload(ctx context.Context) (string, error) {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
 
@@ -285,7 +363,7 @@ func (fs *FilesystemStorage) StartBlobUpload() (string, error) {
 }
 
 // AppendBlobUpload appends data to an ongoing upload
-func (fs *FilesystemStorage) AppendBlobUpload(uploadID string, data []byte) (int64, error) {
+func (fs *FilesystemStorage) AppendBlobUpload(ctx context.Context, uploadID string, data []byte) (int64, error) {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
 
@@ -310,7 +388,7 @@ func (fs *FilesystemStorage) AppendBlobUpload(uploadID string, data []byte) (int
 }
 
 // GetBlobUploadStatus returns the current size of an upload
-func (fs *FilesystemStorage) GetBlobUploadStatus(uploadID string) (int64, error) {
+func (fs *FilesystemStorage) GetBlobUploadStatus(ctx context.Context, uploadID string) (int64, error) {
 	fs.mutex.RLock()
 	defer fs.mutex.RUnlock()
 
@@ -323,7 +401,7 @@ func (fs *FilesystemStorage) GetBlobUploadStatus(uploadID string) (int64, error)
 }
 
 // CompleteBlobUpload finalizes an upload and moves it to blob storage
-func (fs *FilesystemStorage) CompleteBlobUpload(uploadID, digest string, finalChunk []byte) error {
+func (fs *FilesystemStorage) CompleteBlobUpload(ctx context.Context, uploadID, digest string, finalChunk []byte) error {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
 
@@ -338,8 +416,11 @@ func (fs *FilesystemStorage) CompleteBlobUpload(uploadID, digest string, finalCh
 		if err != nil {
 			return err
 		}
-		file.Write(finalChunk)
+		_, err = file.Write(finalChunk)
 		file.Close()
+		if err != nil {
+			return err
+		}
 	}
 
 	// Verify digest
@@ -354,15 +435,23 @@ func (fs *FilesystemStorage) CompleteBlobUpload(uploadID, digest string, finalCh
 		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
 	}
 
-	// Move to blob storage
+	// Move to blob storage, flushing the content before it becomes visible
+	if err := syncFile(upload.FilePath); err != nil {
+		return err
+	}
 	blobPath := fs.getBlobPath(digest)
-	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+	if err := fs.ensureDir(filepath.Dir(blobPath)); err != nil {
 		return err
 	}
-
 	if err := os.Rename(upload.FilePath, blobPath); err != nil {
 		return err
 	}
+	if err := syncDir(filepath.Dir(blobPath)); err != nil {
+		return err
+	}
+
+	// The verified upload replaces a quarantined copy
+	os.Remove(fs.getQuarantinePath(digest))
 
 	// Clean up upload
 	delete(fs.uploads, uploadID)
@@ -371,7 +460,7 @@ func (fs *FilesystemStorage) CompleteBlobUpload(uploadID, digest string, finalCh
 }
 
 // CancelBlobUpload cancels an ongoing upload
-func (fs *FilesystemStorage) CancelBlobUpload(uploadID string) error {
+func (fs *FilesystemStorage) CancelBlobUpload(ctx context.Context, uploadID string) error {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
 
@@ -398,8 +487,13 @@ func (fs *FilesystemStorage) getBlobPath(digest string) string {
 	return filepath.Join(fs.basePath, "blobs", hash[:2], hash[2:4], hash)
 }
 
+// getQuarantinePath returns the filesystem path for a quarantined blob
+func (fs *FilesystemStorage) getQuarantinePath(digest string) string {
+	return filepath.Join(fs.basePath, "quarantine", strings.TrimPrefix(digest, "sha256:"))
+}
+
 // GetRepositoryDescription returns the description for a repository
-func (fs *FilesystemStorage) GetRepositoryDescription(repository string) (string, error) {
+func (fs *FilesystemStorage) GetRepositoryDescription(ctx context.Context, repository string) (string, error) {
 	descPath := filepath.Join(fs.basePath, "descriptions", repository+".md")
 
 	data, err := os.ReadFile(descPath)
@@ -414,18 +508,13 @@ func (fs *FilesystemStorage) GetRepositoryDescription(repository string) (string
 }
 
 // PutRepositoryDescription saves the description for a repository
-func (fs *FilesystemStorage) PutRepositoryDescription(repository string, description string) error {
+func (fs *FilesystemStorage) PutRepositoryDescription(ctx context.Context, repository string, description string) error {
 	descPath := filepath.Join(fs.basePath, "descriptions", repository+".md")
-
-	if err := os.MkdirAll(filepath.Dir(descPath), 0755); err != nil {
-		return err
-	}
-
-	return os.WriteFile(descPath, []byte(description), 0644)
+	return fs.writeFile(descPath, []byte(description))
 }
 
 // GetTotalStorageSize calculates the total size of the storage directory
-func (fs *FilesystemStorage) GetTotalStorageSize() (int64, error) {
+func (fs *FilesystemStorage) GetTotalStorageSize(ctx context.Context) (int64, error) {
 	var totalSize int64
 	err := filepath.Walk(fs.basePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -0,0 +1,18 @@
+package storage_test
+
+import (
+	"testing"
+
+	"docker-registry-manager/internal/storage"
+	"docker-registry-manager/internal/storage/storagetest"
+)
+
+func TestFilesystemStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		store, err := storage.NewFilesystemStorage(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
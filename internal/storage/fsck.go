@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ProblemKind classifies an inconsistency found by Fsck
+type ProblemKind string
+
+const (
+	// ProblemDanglingTag is a tag pointing to a manifest that is not stored
+	ProblemDanglingTag ProblemKind = "dangling-tag"
+	// ProblemUnreadableTag is a tag file that cannot be read
+	ProblemUnreadableTag ProblemKind = "unreadable-tag"
+	// ProblemCorruptManifest is a manifest that does not match its digest or does not parse
+	ProblemCorruptManifest ProblemKind = "corrupt-manifest"
+	// ProblemMissingMeta is a manifest without its .meta file
+	ProblemMissingMeta ProblemKind = "missing-meta"
+	// ProblemInvalidMeta is a .meta file that does not parse or names no media type
+	ProblemInvalidMeta ProblemKind = "invalid-meta"
+	// ProblemMissingManifest is an image index entry whose manifest is not stored
+	ProblemMissingManifest ProblemKind = "missing-manifest"
+	// ProblemMissingBlob is a manifest layer or config that is not stored
+	ProblemMissingBlob ProblemKind = "missing-blob"
+	// ProblemCorruptBlob is a blob whose content does not match its digest
+	ProblemCorruptBlob ProblemKind = "corrupt-blob"
+)
+
+// Problem is an inconsistency found by Fsck
+type Problem struct {
+	Kind ProblemKind
+	// Subject is the tag or digest concerned
+	Subject string
+	Detail  string
+	// Repaired describes the repair made, empty if there was none
+	Repaired string
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("%s %s: %s", p.Kind, p.Subject, p.Detail)
+	if p.Repaired != "" {
+		s += " [" + p.Repaired + "]"
+	}
+	return s
+}
+
+// RepositoryReport lists the problems found in one repository
+type RepositoryReport struct {
+	Name      string
+	Tags      int
+	Manifests int
+	Problems  []Problem
+}
+
+// FsckReport is the result of Fsck
+type FsckReport struct {
+	Repositories []RepositoryReport
+	// Blobs lists problems with blob content; blobs are shared between
+	// repositories, which report the references to them
+	Blobs        []Problem
+	BlobsChecked int
+}
+
+// Count returns the number of problems found and how many were repaired
+func (r *FsckReport) Count() (problems, repaired int) {
+	count := func(list []Problem) {
+		for _, p := range list {
+			problems++
+			if p.Repaired != "" {
+				repaired++
+			}
+		}
+	}
+	for _, repo := range r.Repositories {
+		count(repo.Problems)
+	}
+	count(r.Blobs)
+	return problems, repaired
+}
+
+// FsckOptions controls a consistency check
+type FsckOptions struct {
+	// Repair fixes what is safe to fix: corrupt blobs are quarantined,
+	// dangling tags deleted and .meta files rewritten from the manifest's own
+	// mediaType. Other problems are only reported.
+	Repair bool
+}
+
+// Fsck verifies blob content hashes, that every tag leads to a stored
+// manifest and every manifest to its blobs and child manifests, and that
+// every manifest has a valid .meta file. It must not run while clients
+// push, or half-finished pushes will be reported.
+func (fs *FilesystemStorage) Fsck(ctx context.Context, opts FsckOptions) (*FsckReport, error) {
+	report := &FsckReport{}
+
+	// Blobs first, so manifests referencing a quarantined blob report it
+	blobs, err := fs.ListBlobs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	for _, digest := range blobs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.BlobsChecked++
+		if err := verifyBlob(ctx, fs, digest); err != nil {
+			problem := Problem{Kind: ProblemCorruptBlob, Subject: digest, Detail: err.Error()}
+			if opts.Repair {
+				if err := fs.QuarantineBlob(ctx, digest); err != nil {
+					problem.Detail += fmt.Sprintf("; quarantine failed: %v", err)
+				} else {
+					problem.Repaired = "quarantined"
+				}
+			}
+			report.Blobs = append(report.Blobs, problem)
+		}
+	}
+
+	repositories, err := fs.ListRepositories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+	sort.Strings(repositories)
+	for _, repository := range repositories {
+		repoReport, err := fs.fsckRepository(ctx, repository, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Repositories = append(report.Repositories, *repoReport)
+	}
+
+	return report, nil
+}
+
+// fsckRepository checks the tags and manifests of one repository
+func (fs *FilesystemStorage) fsckRepository(ctx context.Context, repository string, opts FsckOptions) (*RepositoryReport, error) {
+	report := &RepositoryReport{Name: repository}
+	add := func(kind ProblemKind, subject, format string, args ...interface{}) *Problem {
+		report.Problems = append(report.Problems, Problem{Kind: kind, Subject: subject, Detail: fmt.Sprintf(format, args...)})
+		return &report.Problems[len(report.Problems)-1]
+	}
+
+	manifests, err := fs.ListManifests(ctx, repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list manifests of %s: %w", repository, err)
+	}
+	report.Manifests = len(manifests)
+	stored := make(map[string]bool)
+	for _, digest := range manifests {
+		stored[digest] = true
+	}
+
+	tags, err := fs.ListTags(ctx, repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of %s: %w", repository, err)
+	}
+	sort.Strings(tags)
+	report.Tags = len(tags)
+	for _, tag := range tags {
+		digest, err := fs.GetTagDigest(ctx, repository, tag)
+		if err != nil {
+			add(ProblemUnreadableTag, tag, "%v", err)
+			continue
+		}
+		if stored[digest] {
+			continue
+		}
+		problem := add(ProblemDanglingTag, tag, "points to missing manifest %s", digest)
+		if opts.Repair {
+			if err := fs.DeleteTag(ctx, repository, tag); err != nil {
+				problem.Detail += fmt.Sprintf("; delete failed: %v", err)
+			} else {
+				problem.Repaired = "tag deleted"
+			}
+		}
+	}
+
+	for _, digest := range manifests {
+		manifestPath := filepath.Join(fs.basePath, "repositories", repository, "manifests", digest)
+		data, err := os.ReadFile(manifestPath)
+		if err != nil {
+			add(ProblemCorruptManifest, digest, "%v", err)
+			continue
+		}
+		if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); actual != digest {
+			add(ProblemCorruptManifest, digest, "content digest is %s", actual)
+			continue
+		}
+
+		if kind, detail := checkMeta(manifestPath + ".meta"); kind != "" {
+			problem := add(kind, digest, "%s", detail)
+			if opts.Repair {
+				problem.Repaired = fs.repairMeta(manifestPath, data)
+			}
+		}
+
+		_, children, err := References(data)
+		if err != nil {
+			add(ProblemCorruptManifest, digest, "does not parse: %v", err)
+			continue
+		}
+		blobs, _ := PushedBlobs(data)
+		for _, blob := range blobs {
+			if _, err := os.Stat(fs.getBlobPath(blob)); err != nil {
+				add(ProblemMissingBlob, digest, "references missing blob %s", blob)
+			}
+		}
+		for _, child := range children {
+			if !stored[child] {
+				add(ProblemMissingManifest, digest, "references missing manifest %s", child)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// checkMeta returns the problem with a manifest's .meta file, if any
+func checkMeta(metaPath string) (ProblemKind, string) {
+	data, err := os.ReadFile(metaPath)
+	if os.IsNotExist(err) {
+		return ProblemMissingMeta, "no media type recorded"
+	}
+	if err != nil {
+		return ProblemInvalidMeta, err.Error()
+	}
+
+	var metadata struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return ProblemInvalidMeta, fmt.Sprintf("does not parse: %v", err)
+	}
+	if metadata.MediaType == "" {
+		return ProblemInvalidMeta, "no media type recorded"
+	}
+	return "", ""
+}
+
+// repairMeta rewrites a .meta file from the mediaType field of the manifest
+// itself. Manifests without one are left alone rather than guessed at.
+func (fs *FilesystemStorage) repairMeta(manifestPath string, data []byte) string {
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(data, &manifest) != nil || manifest.MediaType == "" {
+		return ""
+	}
+
+	metaData, _ := json.Marshal(struct {
+		MediaType string `json:"mediaType"`
+	}{manifest.MediaType})
+	if err := fs.writeFile(manifestPath+".meta", metaData); err != nil {
+		return ""
+	}
+	return "metadata rewritten"
+}
+
+// verifyBlob re-hashes a blob and compares the result with its digest
+func verifyBlob(ctx context.Context, s Storage, digest string) error {
+	reader, _, err := s.GetBlob(ctx, digest)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return err
+	}
+	if actual := fmt.Sprintf("sha256:%x", hash.Sum(nil)); actual != digest {
+		return fmt.Errorf("content digest is %s", actual)
+	}
+	return nil
+}
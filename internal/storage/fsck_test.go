@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func sha256Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// fsckFixture is a store with one problem of each repairable kind
+type fsckFixture struct {
+	store *FilesystemStorage
+	// Subjects of the problems, by kind
+	problems map[ProblemKind]string
+}
+
+func newFsckFixture(t *testing.T) *fsckFixture {
+	t.Helper()
+	ctx := context.Background()
+	store, err := NewFilesystemStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fsckFixture{store: store, problems: make(map[ProblemKind]string)}
+
+	config, layer := []byte(`{"architecture":"amd64"}`), []byte("layer")
+	for _, blob := range [][]byte{config, layer} {
+		if err := store.PutBlob(ctx, sha256Digest(blob), blob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stored under the digest of other content, as after a disk error
+	corrupt := sha256Digest([]byte("original"))
+	if err := store.PutBlob(ctx, corrupt, []byte("bit rot")); err != nil {
+		t.Fatal(err)
+	}
+	f.problems[ProblemCorruptBlob] = corrupt
+
+	const mediaType = "application/vnd.oci.image.manifest.v1+json"
+	manifest := func(annotation string) (string, []byte) {
+		data := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,`+
+			`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q,"size":%d},`+
+			`"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar","digest":%q,"size":%d}],`+
+			`"annotations":{"variant":%q}}`,
+			mediaType, sha256Digest(config), len(config), sha256Digest(layer), len(layer), annotation))
+		digest := sha256Digest(data)
+		if err := store.PutManifest(ctx, "library/app", digest, data, mediaType); err != nil {
+			t.Fatal(err)
+		}
+		return digest, data
+	}
+	metaPath := func(digest string) string {
+		return filepath.Join(store.basePath, "repositories", "library/app", "manifests", digest) + ".meta"
+	}
+
+	good, _ := manifest("good")
+	if err := store.PutTag(ctx, "library/app", "latest", good); err != nil {
+		t.Fatal(err)
+	}
+
+	missingMeta, _ := manifest("missing meta")
+	if err := os.Remove(metaPath(missingMeta)); err != nil {
+		t.Fatal(err)
+	}
+	f.problems[ProblemMissingMeta] = missingMeta
+
+	invalidMeta, _ := manifest("invalid meta")
+	if err := os.WriteFile(metaPath(invalidMeta), []byte(`{"mediaType":""}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.problems[ProblemInvalidMeta] = invalidMeta
+
+	if err := store.PutTag(ctx, "library/app", "deleted", sha256Digest([]byte("never pushed"))); err != nil {
+		t.Fatal(err)
+	}
+	f.problems[ProblemDanglingTag] = "deleted"
+
+	return f
+}
+
+// found returns the subjects of the problems in report, by kind
+func found(report *FsckReport) map[ProblemKind]string {
+	problems := make(map[ProblemKind]string)
+	for _, repo := range report.Repositories {
+		for _, p := range repo.Problems {
+			problems[p.Kind] = p.Subject
+		}
+	}
+	for _, p := range report.Blobs {
+		problems[p.Kind] = p.Subject
+	}
+	return problems
+}
+
+// snapshot returns the content of every file under dir
+func snapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		files[path] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestFsckCheckOnly(t *testing.T) {
+	f := newFsckFixture(t)
+	before := snapshot(t, f.store.basePath)
+
+	report, err := f.store.Fsck(context.Background(), FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := found(report); !reflect.DeepEqual(got, f.problems) {
+		t.Fatalf("problems = %v, want %v", got, f.problems)
+	}
+	if problems, repaired := report.Count(); problems != len(f.problems) || repaired != 0 {
+		t.Fatalf("count = %d problems, %d repaired; want %d, 0", problems, repaired, len(f.problems))
+	}
+
+	if after := snapshot(t, f.store.basePath); !reflect.DeepEqual(after, before) {
+		var changed []string
+		for path := range before {
+			if after[path] != before[path] {
+				changed = append(changed, path)
+			}
+		}
+		for path := range after {
+			if _, ok := before[path]; !ok {
+				changed = append(changed, path)
+			}
+		}
+		sort.Strings(changed)
+		t.Fatalf("check-only run changed %v", changed)
+	}
+}
+
+func TestFsckRepair(t *testing.T) {
+	ctx := context.Background()
+	f := newFsckFixture(t)
+
+	report, err := f.store.Fsck(ctx, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := found(report); !reflect.DeepEqual(got, f.problems) {
+		t.Fatalf("problems = %v, want %v", got, f.problems)
+	}
+	for _, repo := range report.Repositories {
+		for _, p := range repo.Problems {
+			if p.Repaired == "" {
+				t.Errorf("not repaired: %s", p)
+			}
+		}
+	}
+	for _, p := range report.Blobs {
+		if p.Repaired == "" {
+			t.Errorf("not repaired: %s", p)
+		}
+	}
+
+	if quarantined, err := f.store.IsBlobQuarantined(ctx, f.problems[ProblemCorruptBlob]); err != nil || !quarantined {
+		t.Fatalf("corrupt blob quarantined = %v, %v", quarantined, err)
+	}
+	if _, err := f.store.GetTagDigest(ctx, "library/app", "deleted"); !os.IsNotExist(err) {
+		t.Fatalf("dangling tag: got %v, want it deleted", err)
+	}
+	if _, err := f.store.GetTagDigest(ctx, "library/app", "latest"); err != nil {
+		t.Fatalf("good tag: %v", err)
+	}
+	for _, digest := range []string{f.problems[ProblemMissingMeta], f.problems[ProblemInvalidMeta]} {
+		if _, mediaType, err := f.store.GetManifest(ctx, "library/app", digest); err != nil || mediaType != "application/vnd.oci.image.manifest.v1+json" {
+			t.Fatalf("manifest %s: media type %q, %v", digest, mediaType, err)
+		}
+	}
+
+	report, err = f.store.Fsck(ctx, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, _ := report.Count(); problems != 0 {
+		t.Fatalf("second run found %v", found(report))
+	}
+}
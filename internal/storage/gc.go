@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GCOptions controls a garbage collection run
+type GCOptions struct {
+	// DryRun reports what would be deleted without deleting anything
+	DryRun bool
+	// RemoveUntagged also deletes manifests no tag refers to, directly or
+	// through an image index
+	RemoveUntagged bool
+}
+
+// GCResult lists what a garbage collection run deleted, or would delete
+type GCResult struct {
+	// Manifests are "repository@digest" references
+	Manifests []string
+	Blobs     []string
+	// Reclaimed is the total size of the deleted blobs in bytes
+	Reclaimed int64
+}
+
+// GarbageCollect deletes blobs that no manifest references, and with
+// RemoveUntagged, manifests that no tag references. It must not run while
+// clients push: a blob uploaded before its manifest would be collected.
+func GarbageCollect(ctx context.Context, s Storage, opts GCOptions) (*GCResult, error) {
+	result := &GCResult{}
+
+	repositories, err := s.ListRepositories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	// Mark
+	referenced := make(map[string]bool)
+	for _, repository := range repositories {
+		manifests, err := s.ListManifests(ctx, repository)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list manifests of %s: %w", repository, err)
+		}
+
+		keep := make(map[string]bool)
+		if opts.RemoveUntagged {
+			tagged, err := taggedManifests(ctx, s, repository)
+			if err != nil {
+				return nil, err
+			}
+			keep = tagged
+		} else {
+			for _, digest := range manifests {
+				keep[digest] = true
+			}
+		}
+
+		for _, digest := range manifests {
+			if !keep[digest] {
+				result.Manifests = append(result.Manifests, repository+"@"+digest)
+				continue
+			}
+
+			data, _, err := s.GetManifest(ctx, repository, digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read manifest %s@%s: %w", repository, digest, err)
+			}
+			blobs, _, err := References(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse manifest %s@%s: %w", repository, digest, err)
+			}
+			for _, blob := range blobs {
+				referenced[blob] = true
+			}
+		}
+	}
+
+	blobs, err := s.ListBlobs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	for _, digest := range blobs {
+		if !referenced[digest] {
+			result.Blobs = append(result.Blobs, digest)
+		}
+	}
+
+	if opts.DryRun {
+		for _, digest := range result.Blobs {
+			if size, err := s.GetBlobSize(ctx, digest); err == nil {
+				result.Reclaimed += size
+			}
+		}
+		return result, nil
+	}
+
+	// Sweep manifests first, so an interrupted run never leaves a manifest
+	// whose blobs are gone
+	for _, ref := range result.Manifests {
+		repository, digest := splitReference(ref)
+		if err := s.DeleteManifest(ctx, repository, digest); err != nil && !os.IsNotExist(err) {
+			return result, fmt.Errorf("failed to delete manifest %s: %w", ref, err)
+		}
+	}
+	for _, digest := range result.Blobs {
+		size, err := s.GetBlobSize(ctx, digest)
+		if err != nil {
+			continue
+		}
+		if err := s.DeleteBlob(ctx, digest); err != nil && !os.IsNotExist(err) {
+			return result, fmt.Errorf("failed to delete blob %s: %w", digest, err)
+		}
+		result.Reclaimed += size
+	}
+
+	return result, nil
+}
+
+// taggedManifests returns the manifests of a repository reachable from its
+// tags, following image indexes to their children
+func taggedManifests(ctx context.Context, s Storage, repository string) (map[string]bool, error) {
+	tags, err := s.ListTags(ctx, repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of %s: %w", repository, err)
+	}
+
+	var pending []string
+	for _, tag := range tags {
+		digest, err := s.GetTagDigest(ctx, repository, tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tag %s:%s: %w", repository, tag, err)
+		}
+		pending = append(pending, digest)
+	}
+
+	reachable := make(map[string]bool)
+	for len(pending) > 0 {
+		digest := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if reachable[digest] {
+			continue
+		}
+		reachable[digest] = true
+
+		data, _, err := s.GetManifest(ctx, repository, digest)
+		if err != nil {
+			// A dangling tag keeps nothing alive
+			continue
+		}
+		if _, children, err := References(data); err == nil {
+			pending = append(pending, children...)
+		}
+	}
+
+	return reachable, nil
+}
+
+// splitReference splits "repository@digest"
+func splitReference(ref string) (string, string) {
+	i := strings.LastIndex(ref, "@")
+	return ref[:i], ref[i+1:]
+}
@@ -0,0 +1,382 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorage implements Storage in memory with the same semantics as
+// FilesystemStorage. Everything is lost when the process exits, so it suits
+// tests and throwaway registries.
+type MemoryStorage struct {
+	// repositories maps a repository name to its manifests by digest. A
+	// repository exists once a manifest has been stored in it.
+	repositories map[string]map[string]memoryManifest
+	tags         map[string]map[string]string
+	blobs        map[string][]byte
+	quarantine   map[string][]byte
+	descriptions map[string]string
+	uploads      map[string]*bytes.Buffer
+	mutex        sync.RWMutex
+}
+
+// memoryManifest is a stored manifest with its media type
+type memoryManifest struct {
+	data      []byte
+	mediaType string
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		repositories: make(map[string]map[string]memoryManifest),
+		tags:         make(map[string]map[string]string),
+		blobs:        make(map[string][]byte),
+		quarantine:   make(map[string][]byte),
+		descriptions: make(map[string]string),
+		uploads:      make(map[string]*bytes.Buffer),
+	}
+}
+
+// ListRepositories returns a list of all repositories
+func (ms *MemoryStorage) ListRepositories(ctx context.Context) ([]string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return sortedKeys(ms.repositories), nil
+}
+
+// DeleteRepository removes a repository's tags, manifests and description.
+// Blobs are shared between repositories and are left for garbage collection.
+func (ms *MemoryStorage) DeleteRepository(ctx context.Context, repository string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.repositories[repository]; !exists {
+		return notExist("delete", repository)
+	}
+	delete(ms.repositories, repository)
+	delete(ms.tags, repository)
+	delete(ms.descriptions, repository)
+	return nil
+}
+
+// ListTags returns a list of tags for a repository
+func (ms *MemoryStorage) ListTags(ctx context.Context, repository string) ([]string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return sortedKeys(ms.tags[repository]), nil
+}
+
+// GetTagDigest returns the digest for a tag
+func (ms *MemoryStorage) GetTagDigest(ctx context.Context, repository, tag string) (string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	digest, exists := ms.tags[repository][tag]
+	if !exists {
+		return "", notExist("get", repository+":"+tag)
+	}
+	return digest, nil
+}
+
+// PutTag creates or updates a tag
+func (ms *MemoryStorage) PutTag(ctx context.Context, repository, tag, digest string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if ms.tags[repository] == nil {
+		ms.tags[repository] = make(map[string]string)
+	}
+	ms.tags[repository][tag] = digest
+	return nil
+}
+
+// DeleteTag removes a tag
+func (ms *MemoryStorage) DeleteTag(ctx context.Context, repository, tag string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.tags[repository][tag]; !exists {
+		return notExist("delete", repository+":"+tag)
+	}
+	delete(ms.tags[repository], tag)
+	return nil
+}
+
+// GetManifest returns manifest data and media type
+func (ms *MemoryStorage) GetManifest(ctx context.Context, repository, digest string) ([]byte, string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	manifest, exists := ms.repositories[repository][digest]
+	if !exists {
+		return nil, "", notExist("get", repository+"@"+digest)
+	}
+	return bytes.Clone(manifest.data), manifestMediaType(manifest.mediaType), nil
+}
+
+// GetManifestInfo returns manifest size and media type
+func (ms *MemoryStorage) GetManifestInfo(ctx context.Context, repository, digest string) (int64, string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	manifest, exists := ms.repositories[repository][digest]
+	if !exists {
+		return 0, "", notExist("stat", repository+"@"+digest)
+	}
+	return int64(len(manifest.data)), manifestMediaType(manifest.mediaType), nil
+}
+
+// PutManifest stores a manifest
+func (ms *MemoryStorage) PutManifest(ctx context.Context, repository, digest string, data []byte, mediaType string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if ms.repositories[repository] == nil {
+		ms.repositories[repository] = make(map[string]memoryManifest)
+	}
+	ms.repositories[repository][digest] = memoryManifest{data: bytes.Clone(data), mediaType: mediaType}
+	return nil
+}
+
+// DeleteManifest removes a manifest
+func (ms *MemoryStorage) DeleteManifest(ctx context.Context, repository, digest string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.repositories[repository][digest]; !exists {
+		return notExist("delete", repository+"@"+digest)
+	}
+	// The repository stays listed, as its manifests directory would
+	delete(ms.repositories[repository], digest)
+	return nil
+}
+
+// ListManifests returns the digests of the manifests stored in a repository
+func (ms *MemoryStorage) ListManifests(ctx context.Context, repository string) ([]string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return sortedKeys(ms.repositories[repository]), nil
+}
+
+// GetBlob returns a blob reader and size
+func (ms *MemoryStorage) GetBlob(ctx context.Context, digest string) (io.ReadCloser, int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	data, exists := ms.blobs[digest]
+	if !exists {
+		return nil, 0, notExist("get", digest)
+	}
+	// Stored blobs are never modified in place, so readers can share them
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+// GetBlobSize returns the size of a blob
+func (ms *MemoryStorage) GetBlobSize(ctx context.Context, digest string) (int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	data, exists := ms.blobs[digest]
+	if !exists {
+		return 0, notExist("stat", digest)
+	}
+	return int64(len(data)), nil
+}
+
+// PutBlob stores a blob
+func (ms *MemoryStorage) PutBlob(ctx context.Context, digest string, data []byte) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.blobs[digest] = bytes.Clone(data)
+
+	// Fresh content replaces a quarantined copy
+	delete(ms.quarantine, digest)
+	return nil
+}
+
+// DeleteBlob removes a blob
+func (ms *MemoryStorage) DeleteBlob(ctx context.Context, digest string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.blobs[digest]; !exists {
+		return notExist("delete", digest)
+	}
+	delete(ms.blobs, digest)
+	return nil
+}
+
+// ListBlobs returns the digests of every stored blob
+func (ms *MemoryStorage) ListBlobs(ctx context.Context) ([]string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return sortedKeys(ms.blobs), nil
+}
+
+// QuarantineBlob sets a blob aside, where it is kept but no longer served
+func (ms *MemoryStorage) QuarantineBlob(ctx context.Context, digest string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	data, exists := ms.blobs[digest]
+	if !exists {
+		return notExist("quarantine", digest)
+	}
+	ms.quarantine[digest] = data
+	delete(ms.blobs, digest)
+	return nil
+}
+
+// IsBlobQuarantined reports whether a blob was quarantined and has not been
+// pushed again since
+func (ms *MemoryStorage) IsBlobQuarantined(ctx context.Context, digest string) (bool, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	_, quarantined := ms.quarantine[digest]
+	return quarantined, nil
+}
+
+// StartBlobUpload initiates a new blob upload
+func (ms *MemoryStorage) StartBlobUpload(ctx context.Context) (string, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	uploadID := fmt.Sprintf("%d", time.Now().UnixNano())
+	for ms.uploads[uploadID] != nil {
+		// Two uploads started within the clock resolution
+		uploadID = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	ms.uploads[uploadID] = &bytes.Buffer{}
+	return uploadID, nil
+}
+
+// AppendBlobUpload appends data to an ongoing upload
+func (ms *MemoryStorage) AppendBlobUpload(ctx context.Context, uploadID string, data []byte) (int64, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	upload, exists := ms.uploads[uploadID]
+	if !exists {
+		return 0, fmt.Errorf("upload not found")
+	}
+	upload.Write(data)
+	return int64(upload.Len()), nil
+}
+
+// GetBlobUploadStatus returns the current size of an upload
+func (ms *MemoryStorage) GetBlobUploadStatus(ctx context.Context, uploadID string) (int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	upload, exists := ms.uploads[uploadID]
+	if !exists {
+		return 0, fmt.Errorf("upload not found")
+	}
+	return int64(upload.Len()), nil
+}
+
+// CompleteBlobUpload finalizes an upload and moves it to blob storage
+func (ms *MemoryStorage) CompleteBlobUpload(ctx context.Context, uploadID, digest string, finalChunk []byte) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	upload, exists := ms.uploads[uploadID]
+	if !exists {
+		return fmt.Errorf("upload not found")
+	}
+	upload.Write(finalChunk)
+
+	// Verify digest
+	calculatedDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(upload.Bytes()))
+	if calculatedDigest != digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
+	}
+
+	ms.blobs[digest] = upload.Bytes()
+	delete(ms.quarantine, digest)
+	delete(ms.uploads, uploadID)
+	return nil
+}
+
+// CancelBlobUpload cancels an ongoing upload
+func (ms *MemoryStorage) CancelBlobUpload(ctx context.Context, uploadID string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.uploads[uploadID]; !exists {
+		return fmt.Errorf("upload not found")
+	}
+	delete(ms.uploads, uploadID)
+	return nil
+}
+
+// GetRepositoryDescription returns the description for a repository
+func (ms *MemoryStorage) GetRepositoryDescription(ctx context.Context, repository string) (string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return ms.descriptions[repository], nil
+}
+
+// PutRepositoryDescription saves the description for a repository
+func (ms *MemoryStorage) PutRepositoryDescription(ctx context.Context, repository string, description string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.descriptions[repository] = description
+	return nil
+}
+
+// GetTotalStorageSize sums the size of everything stored
+func (ms *MemoryStorage) GetTotalStorageSize(ctx context.Context) (int64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	var totalSize int64
+	for _, data := range ms.blobs {
+		totalSize += int64(len(data))
+	}
+	for _, data := range ms.quarantine {
+		totalSize += int64(len(data))
+	}
+	for _, manifests := range ms.repositories {
+		for _, manifest := range manifests {
+			totalSize += int64(len(manifest.data))
+		}
+	}
+	for _, tags := range ms.tags {
+		for _, digest := range tags {
+			totalSize += int64(len(digest))
+		}
+	}
+	for _, description := range ms.descriptions {
+		totalSize += int64(len(description))
+	}
+	for _, upload := range ms.uploads {
+		totalSize += int64(upload.Len())
+	}
+	return totalSize, nil
+}
+
+// sortedKeys returns the keys of m in order, never nil
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"testing"
+
+	"docker-registry-manager/internal/storage"
+	"docker-registry-manager/internal/storage/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemoryStorage()
+	})
+}
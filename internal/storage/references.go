@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// descriptor is the part of an OCI/Docker content descriptor needed to
+// follow references
+type descriptor struct {
+	MediaType string   `json:"mediaType"`
+	Digest    string   `json:"digest"`
+	URLs      []string `json:"urls"`
+}
+
+// foreign reports whether the blob a descriptor names is fetched from
+// elsewhere rather than pushed to the registry, as with Windows base layers
+func (d descriptor) foreign() bool {
+	return len(d.URLs) > 0 ||
+		d.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" ||
+		strings.HasPrefix(d.MediaType, "application/vnd.oci.image.layer.nondistributable.")
+}
+
+// References returns the digests a manifest points to: its config and layer
+// blobs, and for an image index or manifest list, the child manifests.
+// Schema 1 manifests reference their layers through fsLayers.
+func References(data []byte) (blobs []string, manifests []string, err error) {
+	var manifest struct {
+		Config    *descriptor  `json:"config"`
+		Layers    []descriptor `json:"layers"`
+		Manifests []descriptor `json:"manifests"`
+		FSLayers  []struct {
+			BlobSum string `json:"blobSum"`
+		} `json:"fsLayers"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, err
+	}
+
+	if manifest.Config != nil && manifest.Config.Digest != "" {
+		blobs = append(blobs, manifest.Config.Digest)
+	}
+	for _, layer := range manifest.Layers {
+		blobs = append(blobs, layer.Digest)
+	}
+	for _, layer := range manifest.FSLayers {
+		blobs = append(blobs, layer.BlobSum)
+	}
+	for _, child := range manifest.Manifests {
+		manifests = append(manifests, child.Digest)
+	}
+
+	return blobs, manifests, nil
+}
+
+// PushedBlobs returns the blobs a manifest references that clients push to
+// the registry, leaving out foreign and non-distributable layers
+func PushedBlobs(data []byte) ([]string, error) {
+	var manifest struct {
+		Config   *descriptor  `json:"config"`
+		Layers   []descriptor `json:"layers"`
+		FSLayers []struct {
+			BlobSum string `json:"blobSum"`
+		} `json:"fsLayers"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+
+	var blobs []string
+	if manifest.Config != nil && manifest.Config.Digest != "" {
+		blobs = append(blobs, manifest.Config.Digest)
+	}
+	for _, layer := range manifest.Layers {
+		if !layer.foreign() {
+			blobs = append(blobs, layer.Digest)
+		}
+	}
+	for _, layer := range manifest.FSLayers {
+		blobs = append(blobs, layer.BlobSum)
+	}
+	return blobs, nil
+}
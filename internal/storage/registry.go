@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Parameters are a driver's settings: its section of the storage
+// configuration, keyed by the driver's type, e.g. storage.s3. Values are
+// as decoded from YAML, or strings when set from the environment or the
+// -set flag. Every driver receives "path", the local state directory.
+type Parameters map[string]interface{}
+
+// Get returns a parameter as a string, or def if it is not set
+func (p Parameters) Get(key, def string) string {
+	value, ok := p[key]
+	if !ok || value == nil {
+		return def
+	}
+	if s := fmt.Sprint(value); s != "" {
+		return s
+	}
+	return def
+}
+
+// Bool returns a boolean parameter; unset means false
+func (p Parameters) Bool(key string) (bool, error) {
+	switch value := p[key].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return value, nil
+	case string:
+		if value == "" {
+			return false, nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("%s: invalid boolean %q", key, value)
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("%s: invalid boolean %v", key, value)
+	}
+}
+
+// Check returns an error naming the first parameter not in known, which is
+// usually a typo. "path" is always accepted.
+func (p Parameters) Check(known ...string) error {
+	for _, key := range sortedKeys(p) {
+		ok := key == "path"
+		for _, k := range known {
+			ok = ok || key == k
+		}
+		if !ok {
+			return fmt.Errorf("unknown parameter %q", key)
+		}
+	}
+	return nil
+}
+
+// Factory opens a storage driver with its parameters
+type Factory func(ctx context.Context, params Parameters) (Storage, error)
+
+var (
+	driversMutex sync.RWMutex
+	drivers      = make(map[string]Factory)
+)
+
+// Register makes a driver available under name, the value of storage.type
+// that selects it. Drivers register from an init function, so importing a
+// driver's package is enough to use it. Register panics if name is taken.
+func Register(name string, factory Factory) {
+	driversMutex.Lock()
+	defer driversMutex.Unlock()
+
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, taken := drivers[name]; taken {
+		panic("storage: Register called twice for driver " + name)
+	}
+	drivers[name] = factory
+}
+
+// Drivers returns the names of the registered drivers in order
+func Drivers() []string {
+	driversMutex.RLock()
+	defer driversMutex.RUnlock()
+
+	return sortedKeys(drivers)
+}
+
+// Open opens the driver registered under name
+func Open(ctx context.Context, name string, params Parameters) (Storage, error) {
+	driversMutex.RLock()
+	factory, ok := drivers[name]
+	driversMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage type %q (available: %s)", name, strings.Join(Drivers(), ", "))
+	}
+
+	store, err := factory(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("%s storage: %w", name, err)
+	}
+	return store, nil
+}
+
+func init() {
+	Register("filesystem", func(ctx context.Context, params Parameters) (Storage, error) {
+		if err := params.Check(); err != nil {
+			return nil, err
+		}
+		return NewFilesystemStorage(params.Get("path", "./data"))
+	})
+	Register("memory", func(ctx context.Context, params Parameters) (Storage, error) {
+		if err := params.Check(); err != nil {
+			return nil, err
+		}
+		return NewMemoryStorage(), nil
+	})
+	Register("s3", func(ctx context.Context, params Parameters) (Storage, error) {
+		if err := params.Check("endpoint", "region", "bucket", "access_key", "secret_key", "root_directory", "insecure", "path_style"); err != nil {
+			return nil, err
+		}
+		insecure, err := params.Bool("insecure")
+		if err != nil {
+			return nil, err
+		}
+		pathStyle, err := params.Bool("path_style")
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Storage(ctx, S3Options{
+			Endpoint:      params.Get("endpoint", ""),
+			Region:        params.Get("region", ""),
+			Bucket:        params.Get("bucket", ""),
+			AccessKey:     params.Get("access_key", ""),
+			SecretKey:     params.Get("secret_key", ""),
+			RootDirectory: params.Get("root_directory", ""),
+			Insecure:      insecure,
+			PathStyle:     pathStyle,
+		})
+	})
+}
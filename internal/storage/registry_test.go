@@ -0,0 +1,98 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"docker-registry-manager/internal/storage"
+)
+
+func TestOpenUnknownDriver(t *testing.T) {
+	_, err := storage.Open(context.Background(), "ftp", storage.Parameters{})
+	if err == nil {
+		t.Fatal("opened an unknown driver")
+	}
+	want := `unknown storage type "ftp" (available: filesystem, memory, s3)`
+	if err.Error() != want {
+		t.Fatalf("got %q, want %q", err, want)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	factory := func(ctx context.Context, params storage.Parameters) (storage.Storage, error) {
+		return storage.NewMemoryStorage(), nil
+	}
+	tests := []struct {
+		name    string
+		factory storage.Factory
+		want    string
+	}{
+		{"filesystem", factory, "storage: Register called twice for driver filesystem"},
+		{"test-nil", nil, "storage: Register factory is nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if got := fmt.Sprint(recover()); got != tt.want {
+					t.Fatalf("panic %q, want %q", got, tt.want)
+				}
+			}()
+			storage.Register(tt.name, tt.factory)
+		})
+	}
+
+	for _, name := range storage.Drivers() {
+		if name == "test-nil" {
+			t.Fatal("nil factory registered")
+		}
+	}
+}
+
+func TestOpenParameters(t *testing.T) {
+	fake := newFakeS3("registry")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	ctx := context.Background()
+
+	// Values from the file are typed; from the environment they are strings
+	store, err := storage.Open(ctx, "s3", storage.Parameters{
+		"path":       t.TempDir(),
+		"endpoint":   strings.TrimPrefix(server.URL, "http://"),
+		"bucket":     "registry",
+		"access_key": "test",
+		"secret_key": "test-secret",
+		"insecure":   true,
+		"path_style": "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutTag(ctx, "app", "latest", "sha256:abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		driver string
+		params storage.Parameters
+		want   string
+	}{
+		{"typo", "s3", storage.Parameters{"bucket": "registry", "path_stlye": true}, `s3 storage: unknown parameter "path_stlye"`},
+		{"invalid boolean", "s3", storage.Parameters{"bucket": "registry", "insecure": "maybe"}, `s3 storage: insecure: invalid boolean "maybe"`},
+		{"scheme in endpoint", "s3", storage.Parameters{"bucket": "registry", "endpoint": "https://s3.example.com"}, "s3 storage: endpoint must be host[:port] without a scheme"},
+		{"half the keys", "s3", storage.Parameters{"bucket": "registry", "access_key": "test"}, "s3 storage: access_key and secret_key must be set together"},
+		{"no bucket", "s3", storage.Parameters{}, "s3 storage: bucket is required"},
+		{"memory takes none", "memory", storage.Parameters{"size": 10}, `memory storage: unknown parameter "size"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := storage.Open(ctx, tt.driver, tt.params)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
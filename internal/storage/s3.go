@@ -0,0 +1,662 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// minPartSize is the smallest part S3 accepts other than the last one, so
+// chunks are buffered until this much has arrived
+const minPartSize = 5 << 20
+
+// maxCopySize is the largest object S3 copies in a single request
+const maxCopySize = 5 << 30
+
+// S3Options configures S3Storage
+type S3Options struct {
+	// Endpoint is host[:port] without a scheme, e.g. s3.amazonaws.com
+	Endpoint string
+	Region   string
+	Bucket   string
+	// AccessKey and SecretKey are optional; without them credentials come
+	// from the AWS_* or MINIO_* environment variables or the instance role
+	AccessKey string
+	SecretKey string
+	// RootDirectory prefixes every key, so registries can share a bucket
+	RootDirectory string
+	// Insecure connects over plain HTTP
+	Insecure bool
+	// PathStyle puts the bucket in the URL path rather than the host name,
+	// as most S3 stand-ins require
+	PathStyle bool
+}
+
+// S3Storage implements Storage on an S3-compatible object store, so several
+// registry instances can share one bucket. Keys follow the filesystem
+// layout. Manifests store their media type as the object content type, and
+// upload sessions keep their state in the bucket so any instance can
+// continue them.
+type S3Storage struct {
+	client *minio.Client
+	core   minio.Core
+	bucket string
+	root   string
+}
+
+// s3Upload is the state of an upload session, stored next to its data
+type s3Upload struct {
+	// MultipartID is empty until the first part is written
+	MultipartID string               `json:"multipartId,omitempty"`
+	Parts       []minio.CompletePart `json:"parts,omitempty"`
+	Size        int64                `json:"size"`
+	// Hash is the marshalled sha256 state of everything received so far,
+	// so completion does not have to read the data back
+	Hash []byte `json:"hash"`
+}
+
+// NewS3Storage connects to the bucket, which must already exist
+func NewS3Storage(ctx context.Context, opts S3Options) (*S3Storage, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	if strings.Contains(opts.Endpoint, "://") {
+		return nil, fmt.Errorf("endpoint must be host[:port] without a scheme; set insecure for plain HTTP")
+	}
+	if (opts.AccessKey == "") != (opts.SecretKey == "") {
+		return nil, fmt.Errorf("access_key and secret_key must be set together")
+	}
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+	})
+	if opts.AccessKey != "" {
+		creds = credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, "")
+	}
+
+	lookup := minio.BucketLookupAuto
+	if opts.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       !opts.Insecure,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access bucket %s: %w", opts.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", opts.Bucket)
+	}
+
+	return &S3Storage{
+		client: client,
+		core:   minio.Core{Client: client},
+		bucket: opts.Bucket,
+		root:   strings.Trim(opts.RootDirectory, "/"),
+	}, nil
+}
+
+// CheckHealth reports whether the bucket is reachable and still exists
+func (s *S3Storage) CheckHealth(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", s.bucket)
+	}
+	return nil
+}
+
+// ListRepositories returns every repository with stored manifests
+func (s *S3Storage) ListRepositories(ctx context.Context) ([]string, error) {
+	prefix := s.key("repositories") + "/"
+	seen := make(map[string]bool)
+	repositories := []string{}
+
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		name := strings.TrimPrefix(object.Key, prefix)
+		i := strings.LastIndex(name, "/manifests/")
+		if i <= 0 || seen[name[:i]] {
+			continue
+		}
+		seen[name[:i]] = true
+		repositories = append(repositories, name[:i])
+	}
+
+	return repositories, nil
+}
+
+// DeleteRepository removes a repository's tags, manifests and description.
+// Blobs are shared between repositories and are left for garbage collection.
+func (s *S3Storage) DeleteRepository(ctx context.Context, repository string) error {
+	manifests, err := s.ListManifests(ctx, repository)
+	if err != nil {
+		return err
+	}
+	if len(manifests) == 0 {
+		return notExist("delete", repository)
+	}
+
+	for _, dir := range []string{"tags", "manifests"} {
+		if err := s.removePrefix(ctx, s.key("repositories", repository, dir)+"/"); err != nil {
+			return err
+		}
+	}
+	return s.client.RemoveObject(ctx, s.bucket, s.descriptionKey(repository), minio.RemoveObjectOptions{})
+}
+
+// ListTags returns a list of tags for a repository
+func (s *S3Storage) ListTags(ctx context.Context, repository string) ([]string, error) {
+	return s.listNames(ctx, s.key("repositories", repository, "tags")+"/")
+}
+
+// GetTagDigest returns the digest for a tag
+func (s *S3Storage) GetTagDigest(ctx context.Context, repository, tag string) (string, error) {
+	data, _, err := s.get(ctx, s.key("repositories", repository, "tags", tag))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// PutTag creates or updates a tag
+func (s *S3Storage) PutTag(ctx context.Context, repository, tag, digest string) error {
+	return s.put(ctx, s.key("repositories", repository, "tags", tag), []byte(digest), "text/plain")
+}
+
+// DeleteTag removes a tag
+func (s *S3Storage) DeleteTag(ctx context.Context, repository, tag string) error {
+	return s.remove(ctx, s.key("repositories", repository, "tags", tag))
+}
+
+// GetManifest returns manifest data and media type
+func (s *S3Storage) GetManifest(ctx context.Context, repository, digest string) ([]byte, string, error) {
+	data, info, err := s.get(ctx, s.key("repositories", repository, "manifests", digest))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, manifestMediaType(info.ContentType), nil
+}
+
+// GetManifestInfo returns manifest size and media type
+func (s *S3Storage) GetManifestInfo(ctx context.Context, repository, digest string) (int64, string, error) {
+	info, err := s.stat(ctx, s.key("repositories", repository, "manifests", digest))
+	if err != nil {
+		return 0, "", err
+	}
+	return info.Size, manifestMediaType(info.ContentType), nil
+}
+
+// PutManifest stores a manifest, with its media type as the content type so
+// both are written in one request
+func (s *S3Storage) PutManifest(ctx context.Context, repository, digest string, data []byte, mediaType string) error {
+	return s.put(ctx, s.key("repositories", repository, "manifests", digest), data, mediaType)
+}
+
+// DeleteManifest removes a manifest
+func (s *S3Storage) DeleteManifest(ctx context.Context, repository, digest string) error {
+	return s.remove(ctx, s.key("repositories", repository, "manifests", digest))
+}
+
+// ListManifests returns the digests of the manifests stored in a repository
+func (s *S3Storage) ListManifests(ctx context.Context, repository string) ([]string, error) {
+	return s.listNames(ctx, s.key("repositories", repository, "manifests")+"/")
+}
+
+// GetBlob returns a blob reader and size
+func (s *S3Storage) GetBlob(ctx context.Context, digest string) (io.ReadCloser, int64, error) {
+	key := s.blobKey(digest)
+	reader, info, _, err := s.core.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, 0, s.notFound(err, "get", key)
+	}
+	return reader, info.Size, nil
+}
+
+// GetBlobSize returns the size of a blob
+func (s *S3Storage) GetBlobSize(ctx context.Context, digest string) (int64, error) {
+	info, err := s.stat(ctx, s.blobKey(digest))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// PutBlob stores a blob
+func (s *S3Storage) PutBlob(ctx context.Context, digest string, data []byte) error {
+	if err := s.put(ctx, s.blobKey(digest), data, "application/octet-stream"); err != nil {
+		return err
+	}
+
+	// Fresh content replaces a quarantined copy
+	return s.client.RemoveObject(ctx, s.bucket, s.quarantineKey(digest), minio.RemoveObjectOptions{})
+}
+
+// DeleteBlob removes a blob
+func (s *S3Storage) DeleteBlob(ctx context.Context, digest string) error {
+	return s.remove(ctx, s.blobKey(digest))
+}
+
+// ListBlobs returns the digests of every stored blob
+func (s *S3Storage) ListBlobs(ctx context.Context) ([]string, error) {
+	digests := []string{}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.key("blobs") + "/", Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		digests = append(digests, "sha256:"+path.Base(object.Key))
+	}
+	return digests, nil
+}
+
+// QuarantineBlob moves a blob under quarantine/, where it is kept for
+// inspection but no longer served
+func (s *S3Storage) QuarantineBlob(ctx context.Context, digest string) error {
+	info, err := s.stat(ctx, s.blobKey(digest))
+	if err != nil {
+		return err
+	}
+	if err := s.copy(ctx, s.blobKey(digest), s.quarantineKey(digest), info.Size); err != nil {
+		return err
+	}
+	return s.client.RemoveObject(ctx, s.bucket, s.blobKey(digest), minio.RemoveObjectOptions{})
+}
+
+// IsBlobQuarantined reports whether a blob was quarantined and has not been
+// pushed again since
+func (s *S3Storage) IsBlobQuarantined(ctx context.Context, digest string) (bool, error) {
+	_, err := s.stat(ctx, s.quarantineKey(digest))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// StartBlobUpload initiates a new blob upload
+func (s *S3Storage) StartBlobUpload(ctx context.Context) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	hash, err := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	if err := s.saveUpload(ctx, uploadID, &s3Upload{Hash: hash}); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// AppendBlobUpload appends data to an ongoing upload. Data is buffered in
+// the bucket until a full part can be written.
+func (s *S3Storage) AppendBlobUpload(ctx context.Context, uploadID string, data []byte) (int64, error) {
+	upload, err := s.loadUpload(ctx, uploadID)
+	if err != nil {
+		return 0, err
+	}
+
+	hash := sha256.New()
+	if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.Hash); err != nil {
+		return 0, fmt.Errorf("corrupt upload state: %w", err)
+	}
+	hash.Write(data)
+	if upload.Hash, err = hash.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return 0, err
+	}
+
+	pending, err := s.uploadTail(ctx, uploadID)
+	if err != nil {
+		return 0, err
+	}
+	pending = append(pending, data...)
+
+	if len(pending) >= minPartSize {
+		if err := s.writePart(ctx, uploadID, upload, pending); err != nil {
+			return 0, err
+		}
+		pending = nil
+	}
+	if err := s.put(ctx, s.uploadKey(uploadID, "tail"), pending, "application/octet-stream"); err != nil {
+		return 0, err
+	}
+
+	upload.Size += int64(len(data))
+	if err := s.saveUpload(ctx, uploadID, upload); err != nil {
+		return 0, err
+	}
+	return upload.Size, nil
+}
+
+// GetBlobUploadStatus returns the current size of an upload
+func (s *S3Storage) GetBlobUploadStatus(ctx context.Context, uploadID string) (int64, error) {
+	upload, err := s.loadUpload(ctx, uploadID)
+	if err != nil {
+		return 0, err
+	}
+	return upload.Size, nil
+}
+
+// CompleteBlobUpload finalizes an upload and copies it to blob storage
+func (s *S3Storage) CompleteBlobUpload(ctx context.Context, uploadID, digest string, finalChunk []byte) error {
+	// Append final chunk if provided
+	if len(finalChunk) > 0 {
+		if _, err := s.AppendBlobUpload(ctx, uploadID, finalChunk); err != nil {
+			return err
+		}
+	}
+
+	upload, err := s.loadUpload(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+
+	// Verify digest
+	hash := sha256.New()
+	if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.Hash); err != nil {
+		return fmt.Errorf("corrupt upload state: %w", err)
+	}
+	calculatedDigest := fmt.Sprintf("sha256:%x", hash.Sum(nil))
+	if calculatedDigest != digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
+	}
+
+	pending, err := s.uploadTail(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+
+	if upload.MultipartID == "" {
+		// Small uploads never started a multipart upload
+		if err := s.put(ctx, s.blobKey(digest), pending, "application/octet-stream"); err != nil {
+			return err
+		}
+	} else {
+		if len(pending) > 0 {
+			if err := s.writePart(ctx, uploadID, upload, pending); err != nil {
+				return err
+			}
+		}
+		dataKey := s.uploadKey(uploadID, "data")
+		if _, err := s.core.CompleteMultipartUpload(ctx, s.bucket, dataKey, upload.MultipartID, upload.Parts, minio.PutObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to complete multipart upload: %w", err)
+		}
+		if err := s.copy(ctx, dataKey, s.blobKey(digest), upload.Size); err != nil {
+			return err
+		}
+	}
+
+	// The verified upload replaces a quarantined copy
+	s.client.RemoveObject(ctx, s.bucket, s.quarantineKey(digest), minio.RemoveObjectOptions{})
+
+	// Clean up upload
+	return s.removePrefix(ctx, s.uploadKey(uploadID, ""))
+}
+
+// CancelBlobUpload cancels an ongoing upload
+func (s *S3Storage) CancelBlobUpload(ctx context.Context, uploadID string) error {
+	upload, err := s.loadUpload(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+
+	if upload.MultipartID != "" {
+		if err := s.core.AbortMultipartUpload(ctx, s.bucket, s.uploadKey(uploadID, "data"), upload.MultipartID); err != nil {
+			return err
+		}
+	}
+	return s.removePrefix(ctx, s.uploadKey(uploadID, ""))
+}
+
+// GetRepositoryDescription returns the description for a repository
+func (s *S3Storage) GetRepositoryDescription(ctx context.Context, repository string) (string, error) {
+	data, _, err := s.get(ctx, s.descriptionKey(repository))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil // Return empty string if description does not exist
+		}
+		return "", err
+	}
+	return string(data), nil
+}
+
+// PutRepositoryDescription saves the description for a repository
+func (s *S3Storage) PutRepositoryDescription(ctx context.Context, repository string, description string) error {
+	return s.put(ctx, s.descriptionKey(repository), []byte(description), "text/markdown")
+}
+
+// GetTotalStorageSize sums the size of every object under the root
+func (s *S3Storage) GetTotalStorageSize(ctx context.Context) (int64, error) {
+	prefix := ""
+	if s.root != "" {
+		prefix = s.root + "/"
+	}
+
+	var totalSize int64
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return 0, fmt.Errorf("failed to calculate total storage size: %w", object.Err)
+		}
+		totalSize += object.Size
+	}
+	return totalSize, nil
+}
+
+// key joins parts below the root directory
+func (s *S3Storage) key(parts ...string) string {
+	return path.Join(append([]string{s.root}, parts...)...)
+}
+
+// blobKey returns the key of a blob, laid out like the filesystem: blobs/ab/cd/abcd...
+func (s *S3Storage) blobKey(digest string) string {
+	hash := strings.TrimPrefix(digest, "sha256:")
+	return s.key("blobs", hash[:2], hash[2:4], hash)
+}
+
+// quarantineKey returns the key of a quarantined blob
+func (s *S3Storage) quarantineKey(digest string) string {
+	return s.key("quarantine", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// descriptionKey returns the key of a repository description
+func (s *S3Storage) descriptionKey(repository string) string {
+	return s.key("descriptions", repository+".md")
+}
+
+// uploadKey returns the key of an object belonging to an upload session;
+// an empty name gives the session prefix
+func (s *S3Storage) uploadKey(uploadID, name string) string {
+	return s.key("uploads", uploadID) + "/" + name
+}
+
+// loadUpload reads the state of an upload session
+func (s *S3Storage) loadUpload(ctx context.Context, uploadID string) (*s3Upload, error) {
+	data, _, err := s.get(ctx, s.uploadKey(uploadID, "state.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("upload not found")
+		}
+		return nil, err
+	}
+
+	var upload s3Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("corrupt upload state: %w", err)
+	}
+	return &upload, nil
+}
+
+// saveUpload writes the state of an upload session
+func (s *S3Storage) saveUpload(ctx context.Context, uploadID string, upload *s3Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, s.uploadKey(uploadID, "state.json"), data, "application/json")
+}
+
+// uploadTail returns the data received but not yet written as a part
+func (s *S3Storage) uploadTail(ctx context.Context, uploadID string) ([]byte, error) {
+	data, _, err := s.get(ctx, s.uploadKey(uploadID, "tail"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// writePart uploads data as the next part, starting the multipart upload if
+// this is the first
+func (s *S3Storage) writePart(ctx context.Context, uploadID string, upload *s3Upload, data []byte) error {
+	dataKey := s.uploadKey(uploadID, "data")
+	if upload.MultipartID == "" {
+		multipartID, err := s.core.NewMultipartUpload(ctx, s.bucket, dataKey, minio.PutObjectOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to start multipart upload: %w", err)
+		}
+		upload.MultipartID = multipartID
+	}
+
+	number := len(upload.Parts) + 1
+	part, err := s.core.PutObjectPart(ctx, s.bucket, dataKey, upload.MultipartID, number,
+		bytes.NewReader(data), int64(len(data)), minio.PutObjectPartOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", number, err)
+	}
+	upload.Parts = append(upload.Parts, minio.CompletePart{PartNumber: number, ETag: part.ETag})
+	return nil
+}
+
+// get reads a whole object
+func (s *S3Storage) get(ctx context.Context, key string) ([]byte, minio.ObjectInfo, error) {
+	reader, info, _, err := s.core.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, info, s.notFound(err, "get", key)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	return data, info, err
+}
+
+// put writes a whole object
+func (s *S3Storage) put(ctx context.Context, key string, data []byte, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// stat returns an object's size and content type
+func (s *S3Storage) stat(ctx context.Context, key string) (minio.ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return info, s.notFound(err, "stat", key)
+	}
+	return info, nil
+}
+
+// remove deletes an object, failing like os.Remove if it does not exist;
+// S3 itself reports success either way
+func (s *S3Storage) remove(ctx context.Context, key string) error {
+	if _, err := s.stat(ctx, key); err != nil {
+		return err
+	}
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// removePrefix deletes every object under prefix
+func (s *S3Storage) removePrefix(ctx context.Context, prefix string) error {
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	for result := range s.client.RemoveObjects(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+	return nil
+}
+
+// copy copies an object of the given size server-side, as a multipart copy
+// above the single request limit
+func (s *S3Storage) copy(ctx context.Context, srcKey, dstKey string, size int64) error {
+	dst := minio.CopyDestOptions{Bucket: s.bucket, Object: dstKey}
+	src := minio.CopySrcOptions{Bucket: s.bucket, Object: srcKey}
+
+	var err error
+	if size > maxCopySize {
+		_, err = s.client.ComposeObject(ctx, dst, src)
+	} else {
+		_, err = s.client.CopyObject(ctx, dst, src)
+	}
+	return s.notFound(err, "copy", srcKey)
+}
+
+// listNames returns the last path element of each object directly under prefix
+func (s *S3Storage) listNames(ctx context.Context, prefix string) ([]string, error) {
+	names := []string{}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		// Keys ending in / are nested repositories, not entries
+		if name := strings.TrimPrefix(object.Key, prefix); name != "" && !strings.HasSuffix(name, "/") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// notFound converts S3's missing key and bucket errors to ones os.IsNotExist
+// recognises, as callers written against the filesystem expect
+func (s *S3Storage) notFound(err error, op, key string) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket", "NotFound":
+		return notExist(op, key)
+	}
+	return err
+}
+
+// manifestMediaType returns the stored media type, defaulting like the
+// filesystem does for manifests written without one
+func manifestMediaType(contentType string) string {
+	switch contentType {
+	case "", "application/octet-stream", "binary/octet-stream":
+		return "application/vnd.docker.distribution.manifest.v2+json"
+	}
+	return contentType
+}
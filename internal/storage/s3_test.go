@@ -0,0 +1,433 @@
+package storage_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"docker-registry-manager/internal/storage"
+	"docker-registry-manager/internal/storage/storagetest"
+)
+
+// fakeS3 serves the subset of the S3 API the driver uses from memory, with
+// path-style addressing and no signature checks
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]fakeObject
+	// uploads holds the parts of each multipart upload by ID
+	uploads map[string]map[int][]byte
+	nextID  int
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(buckets ...string) *fakeS3 {
+	f := &fakeS3{
+		buckets: make(map[string]map[string]fakeObject),
+		uploads: make(map[string]map[int][]byte),
+	}
+	for _, bucket := range buckets {
+		f.buckets[bucket] = make(map[string]fakeObject)
+	}
+	return f
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	objects, ok := f.buckets[bucket]
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, objects, query)
+	case key == "" && r.Method == http.MethodPost && query.Has("delete"):
+		f.deleteObjects(w, r, objects)
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = make(map[int][]byte)
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadID string `xml:"UploadId"`
+		}{Bucket: bucket, Key: key, UploadID: id})
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = readPayload(r)
+		w.Header().Set("ETag", etag(parts[number]))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.completeUpload(w, r, bucket, key, objects)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		_, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+		object, ok := objects[srcKey]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		objects[key] = object
+		writeXML(w, struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			ETag         string
+			LastModified string
+		}{ETag: etag(object.data), LastModified: time.Now().UTC().Format(time.RFC3339)})
+	case r.Method == http.MethodPut:
+		objects[key] = fakeObject{readPayload(r), r.Header.Get("Content-Type")}
+		w.Header().Set("ETag", etag(objects[key].data))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("ETag", etag(object.data))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// list answers ListObjectsV2 in one page
+func (f *fakeS3) list(w http.ResponseWriter, objects map[string]fakeObject, query url.Values) {
+	type content struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Prefix         string
+		KeyCount       int
+		IsTruncated    bool
+		Contents       []content
+		CommonPrefixes []commonPrefix
+	}{Prefix: query.Get("prefix")}
+
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			common := key[:len(prefix)+i+len(delimiter)]
+			if !seen[common] {
+				seen[common] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{common})
+			}
+			continue
+		}
+		data := objects[key].data
+		result.Contents = append(result.Contents, content{key, int64(len(data)), etag(data), time.Now().UTC().Format(time.RFC3339)})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	writeXML(w, result)
+}
+
+// deleteObjects answers a multi-object delete
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, r *http.Request, objects map[string]fakeObject) {
+	var request struct {
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if err := xml.Unmarshal(readPayload(r), &request); err != nil {
+		s3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	for _, object := range request.Objects {
+		delete(objects, object.Key)
+	}
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+	}{})
+}
+
+// completeUpload joins the parts named in the request into the object
+func (f *fakeS3) completeUpload(w http.ResponseWriter, r *http.Request, bucket, key string, objects map[string]fakeObject) {
+	id := r.URL.Query().Get("uploadId")
+	parts, ok := f.uploads[id]
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	var request struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(readPayload(r), &request); err != nil {
+		s3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var data []byte
+	for i, part := range request.Parts {
+		content, ok := parts[part.PartNumber]
+		if !ok || part.PartNumber != i+1 || strings.Trim(part.ETag, `"`) != strings.Trim(etag(content), `"`) {
+			s3Error(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data = append(data, content...)
+	}
+	objects[key] = fakeObject{data: data}
+	delete(f.uploads, id)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: bucket, Key: key, ETag: etag(data)})
+}
+
+// readPayload returns a request body, decoding the aws-chunked encoding
+// used for signed uploads over plain HTTP
+func readPayload(r *http.Request) []byte {
+	body, _ := io.ReadAll(r.Body)
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return body
+	}
+
+	var data []byte
+	reader := bufio.NewReader(bytes.NewReader(body))
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return data
+		}
+		sizeField, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeField, 16, 64)
+		if err != nil || size == 0 {
+			return data
+		}
+		chunk := make([]byte, size)
+		io.ReadFull(reader, chunk)
+		data = append(data, chunk...)
+		reader.ReadString('\n')
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// newS3Storage returns a driver for bucket on server, under root
+func newS3Storage(t *testing.T, server *httptest.Server, bucket, root string) *storage.S3Storage {
+	t.Helper()
+	store, err := storage.NewS3Storage(context.Background(), storage.S3Options{
+		Endpoint:      strings.TrimPrefix(server.URL, "http://"),
+		Region:        "us-east-1",
+		Bucket:        bucket,
+		AccessKey:     "test",
+		SecretKey:     "test-secret",
+		RootDirectory: root,
+		Insecure:      true,
+		PathStyle:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var buckets int
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		fake.mu.Lock()
+		buckets++
+		bucket := fmt.Sprintf("registry-%d", buckets)
+		fake.buckets[bucket] = make(map[string]fakeObject)
+		fake.mu.Unlock()
+		return newS3Storage(t, server, bucket, "prod")
+	})
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3("registry")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	store := newS3Storage(t, server, "registry", "")
+
+	// Two chunks large enough to be written as parts, and a short final one
+	chunks := [][]byte{
+		bytes.Repeat([]byte("a"), 5<<20),
+		bytes.Repeat([]byte("b"), 3<<20),
+		bytes.Repeat([]byte("c"), 3<<20),
+	}
+	final := []byte("the end")
+	data := bytes.Join(append(chunks, final), nil)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	uploadID, err := store.StartBlobUpload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, chunk := range chunks {
+		if size, err = store.AppendBlobUpload(ctx, uploadID, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size != int64(len(data)-len(final)) {
+		t.Fatalf("upload size = %d, want %d", size, len(data)-len(final))
+	}
+
+	fake.mu.Lock()
+	inProgress := len(fake.uploads)
+	fake.mu.Unlock()
+	if inProgress != 1 {
+		t.Fatalf("multipart uploads in progress = %d, want 1", inProgress)
+	}
+
+	if err := store.CompleteBlobUpload(ctx, uploadID, digest, final); err != nil {
+		t.Fatal(err)
+	}
+	reader, blobSize, err := store.GetBlob(ctx, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(reader)
+	reader.Close()
+	if blobSize != int64(len(data)) || !bytes.Equal(got, data) {
+		t.Fatalf("blob after multipart upload: %d bytes, want %d", len(got), len(data))
+	}
+
+	// Nothing of the session is left behind
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.uploads) != 0 {
+		t.Fatalf("multipart uploads left = %d, want 0", len(fake.uploads))
+	}
+	for key := range fake.buckets["registry"] {
+		if strings.HasPrefix(key, "uploads/") {
+			t.Fatalf("upload object %s left after completion", key)
+		}
+	}
+}
+
+func TestS3CancelMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3("registry")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	store := newS3Storage(t, server, "registry", "")
+
+	uploadID, err := store.StartBlobUpload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AppendBlobUpload(ctx, uploadID, bytes.Repeat([]byte("a"), 5<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CancelBlobUpload(ctx, uploadID); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.uploads) != 0 {
+		t.Fatalf("multipart uploads left after cancel = %d, want 0", len(fake.uploads))
+	}
+	if len(fake.buckets["registry"]) != 0 {
+		t.Fatalf("objects left after cancel = %d, want 0", len(fake.buckets["registry"]))
+	}
+}
+
+func TestS3CheckHealth(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3("registry")
+	server := httptest.NewServer(fake)
+	store := newS3Storage(t, server, "registry", "")
+
+	if err := store.CheckHealth(ctx); err != nil {
+		t.Fatalf("CheckHealth: %v", err)
+	}
+
+	fake.mu.Lock()
+	delete(fake.buckets, "registry")
+	fake.mu.Unlock()
+	if err := store.CheckHealth(ctx); err == nil {
+		t.Fatal("CheckHealth succeeded with the bucket gone")
+	}
+
+	// The client retries unreachable endpoints; the health checker bounds
+	// each check with a timeout the same way
+	server.Close()
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := store.CheckHealth(ctx); err == nil {
+		t.Fatal("CheckHealth succeeded with the endpoint down")
+	}
+}
@@ -1,45 +1,57 @@
 package storage
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"os"
+	"time"
 )
 
 // Storage defines the interface for registry storage backend
 type Storage interface {
 	// Repository operations
-	ListRepositories() ([]string, error)
+	ListRepositories(ctx context.Context) ([]string, error)
+	DeleteRepository(ctx context.Context, repository string) error
 
 	// Tag operations
-	ListTags(repository string) ([]string, error)
-	GetTagDigest(repository, tag string) (string, error)
-	PutTag(repository, tag, digest string) error
-	DeleteTag(repository, tag string) error
+	ListTags(ctx context.Context, repository string) ([]string, error)
+	GetTagDigest(ctx context.Context, repository, tag string) (string, error)
+	PutTag(ctx context.Context, repository, tag, digest string) error
+	DeleteTag(ctx context.Context, repository, tag string) error
 
 	// Manifest operations
-	GetManifest(repository, digest string) ([]byte, string, error)
-	GetManifestInfo(repository, digest string) (int64, string, error)
-	PutManifest(repository, digest string, data []byte, mediaType string) error
-	DeleteManifest(repository, digest string) error
+	GetManifest(ctx context.Context, repository, digest string) ([]byte, string, error)
+	GetManifestInfo(ctx context.Context, repository, digest string) (int64, string, error)
+	PutManifest(ctx context.Context, repository, digest string, data []byte, mediaType string) error
+	DeleteManifest(ctx context.Context, repository, digest string) error
+	ListManifests(ctx context.Context, repository string) ([]string, error)
 
 	// Blob operations
-	GetBlob(digest string) (io.ReadCloser, int64, error)
-	GetBlobSize(digest string) (int64, error)
-	PutBlob(digest string, data []byte) error
-	DeleteBlob(digest string) error
+	GetBlob(ctx context.Context, digest string) (io.ReadCloser, int64, error)
+	GetBlobSize(ctx context.Context, digest string) (int64, error)
+	PutBlob(ctx context.Context, digest string, data []byte) error
+	DeleteBlob(ctx context.Context, digest string) error
+	ListBlobs(ctx context.Context) ([]string, error)
+
+	// Integrity operations. A quarantined blob failed verification; it is
+	// kept aside for inspection and not served until pushed again.
+	QuarantineBlob(ctx context.Context, digest string) error
+	IsBlobQuarantined(ctx context.Context, digest string) (bool, error)
 
 	// Blob upload operations
-	StartBlobUpload() (string, error)
-	AppendBlobUpload(uploadID string, data []byte) (int64, error)
-	GetBlobUploadStatus(uploadID string) (int64, error)
-	CompleteBlobUpload(uploadID, digest string, finalChunk []byte) error
-	CancelBlobUpload(uploadID string) error
+	StartBlobUpload(ctx context.Context) (string, error)
+	AppendBlobUpload(ctx context.Context, uploadID string, data []byte) (int64, error)
+	GetBlobUploadStatus(ctx context.Context, uploadID string) (int64, error)
+	CompleteBlobUpload(ctx context.Context, uploadID, digest string, finalChunk []byte) error
+	CancelBlobUpload(ctx context.Context, uploadID string) error
 
 	// Description operations
-	GetRepositoryDescription(repository string) (string, error)
-	PutRepositoryDescription(repository string, description string) error
+	GetRepositoryDescription(ctx context.Context, repository string) (string, error)
+	PutRepositoryDescription(ctx context.Context, repository string, description string) error
 
 	// Storage size operations
-	GetTotalStorageSize() (int64, error)
+	GetTotalStorageSize(ctx context.Context) (int64, error)
 }
 
 // BlobUpload represents an ongoing blob upload
@@ -54,11 +66,25 @@ type RepositoryInfo struct {
 	Name     string
 	TagCount int
 	Size     int64
+	Updated  time.Time
 }
 
 // TagInfo represents tag information
 type TagInfo struct {
-	Name   string
-	Digest string
-	Size   int64
+	Name    string
+	Digest  string
+	Size    int64
+	Updated time.Time
+}
+
+// FormatMB returns n bytes in megabytes, as the web interface and quota
+// messages show sizes
+func FormatMB(n int64) string {
+	return fmt.Sprintf("%.2f MB", float64(n)/(1024*1024))
+}
+
+// notExist returns an error satisfying os.IsNotExist, which callers check
+// for missing tags, manifests and blobs whatever the driver
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
 }
@@ -0,0 +1,460 @@
+// Package storagetest checks that a storage driver behaves like
+// FilesystemStorage, which the API handlers, garbage collection and archive
+// code are written against. Every driver must pass it.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"docker-registry-manager/internal/storage"
+)
+
+const (
+	manifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	defaultMediaType  = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// check is one conformance check, run against an empty store
+type check struct {
+	name string
+	run  func(ctx context.Context, s storage.Storage) error
+}
+
+var checks = []check{
+	{"empty", checkEmpty},
+	{"blobs", checkBlobs},
+	{"quarantine", checkQuarantine},
+	{"manifests", checkManifests},
+	{"tags", checkTags},
+	{"repositories", checkRepositories},
+	{"delete repository", checkDeleteRepository},
+	{"uploads", checkUploads},
+	{"upload digest mismatch", checkUploadMismatch},
+	{"cancel upload", checkCancelUpload},
+	{"descriptions", checkDescriptions},
+	{"total size", checkTotalSize},
+}
+
+// Run runs every check as a subtest of t, each against a fresh store from
+// newStore. A driver's test calls it with a constructor for its store.
+func Run(t *testing.T, newStore func(t *testing.T) storage.Storage) {
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(context.Background(), newStore(t)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func checkEmpty(ctx context.Context, s storage.Storage) error {
+	if err := expectList(ctx, "repositories", s.ListRepositories); err != nil {
+		return err
+	}
+	if err := expectList(ctx, "blobs", s.ListBlobs); err != nil {
+		return err
+	}
+	tags, err := s.ListTags(ctx, "missing")
+	if err != nil || len(tags) != 0 {
+		return fmt.Errorf("tags of a missing repository: got %v, %v; want none", tags, err)
+	}
+	manifests, err := s.ListManifests(ctx, "missing")
+	if err != nil || len(manifests) != 0 {
+		return fmt.Errorf("manifests of a missing repository: got %v, %v; want none", manifests, err)
+	}
+	return nil
+}
+
+func checkBlobs(ctx context.Context, s storage.Storage) error {
+	data := []byte("layer content")
+	digest := digestOf(data)
+
+	if _, _, err := s.GetBlob(ctx, digest); !os.IsNotExist(err) {
+		return fmt.Errorf("GetBlob of a missing blob: got %v, want a not-exist error", err)
+	}
+	if _, err := s.GetBlobSize(ctx, digest); !os.IsNotExist(err) {
+		return fmt.Errorf("GetBlobSize of a missing blob: got %v, want a not-exist error", err)
+	}
+	if err := s.DeleteBlob(ctx, digest); !os.IsNotExist(err) {
+		return fmt.Errorf("DeleteBlob of a missing blob: got %v, want a not-exist error", err)
+	}
+
+	if err := s.PutBlob(ctx, digest, data); err != nil {
+		return fmt.Errorf("PutBlob: %w", err)
+	}
+	// The store must not keep the caller's buffer
+	data[0] = 'X'
+	if err := expectBlob(ctx, s, digest, []byte("layer content")); err != nil {
+		return err
+	}
+	if err := expectList(ctx, "blobs", s.ListBlobs, digest); err != nil {
+		return err
+	}
+
+	if err := s.DeleteBlob(ctx, digest); err != nil {
+		return fmt.Errorf("DeleteBlob: %w", err)
+	}
+	if _, _, err := s.GetBlob(ctx, digest); !os.IsNotExist(err) {
+		return fmt.Errorf("GetBlob after delete: got %v, want a not-exist error", err)
+	}
+	return expectList(ctx, "blobs", s.ListBlobs)
+}
+
+func checkQuarantine(ctx context.Context, s storage.Storage) error {
+	data := []byte("rotting layer")
+	digest := digestOf(data)
+	if err := s.PutBlob(ctx, digest, data); err != nil {
+		return fmt.Errorf("PutBlob: %w", err)
+	}
+	if quarantined, err := s.IsBlobQuarantined(ctx, digest); err != nil || quarantined {
+		return fmt.Errorf("IsBlobQuarantined before quarantine: got %v, %v; want false", quarantined, err)
+	}
+
+	if err := s.QuarantineBlob(ctx, digest); err != nil {
+		return fmt.Errorf("QuarantineBlob: %w", err)
+	}
+	if quarantined, err := s.IsBlobQuarantined(ctx, digest); err != nil || !quarantined {
+		return fmt.Errorf("IsBlobQuarantined after quarantine: got %v, %v; want true", quarantined, err)
+	}
+	if _, _, err := s.GetBlob(ctx, digest); !os.IsNotExist(err) {
+		return fmt.Errorf("GetBlob of a quarantined blob: got %v, want a not-exist error", err)
+	}
+	if err := expectList(ctx, "blobs", s.ListBlobs); err != nil {
+		return err
+	}
+
+	// Pushing the blob again lifts the quarantine
+	if err := s.PutBlob(ctx, digest, data); err != nil {
+		return fmt.Errorf("PutBlob after quarantine: %w", err)
+	}
+	if quarantined, err := s.IsBlobQuarantined(ctx, digest); err != nil || quarantined {
+		return fmt.Errorf("IsBlobQuarantined after push: got %v, %v; want false", quarantined, err)
+	}
+	return expectBlob(ctx, s, digest, data)
+}
+
+func checkManifests(ctx context.Context, s storage.Storage) error {
+	data := []byte(`{"schemaVersion":2}`)
+	digest := digestOf(data)
+
+	if _, _, err := s.GetManifest(ctx, "app", digest); !os.IsNotExist(err) {
+		return fmt.Errorf("GetManifest of a missing manifest: got %v, want a not-exist error", err)
+	}
+	if err := s.DeleteManifest(ctx, "app", digest); !os.IsNotExist(err) {
+		return fmt.Errorf("DeleteManifest of a missing manifest: got %v, want a not-exist error", err)
+	}
+
+	if err := s.PutManifest(ctx, "app", digest, data, manifestMediaType); err != nil {
+		return fmt.Errorf("PutManifest: %w", err)
+	}
+	got, mediaType, err := s.GetManifest(ctx, "app", digest)
+	if err != nil || !bytes.Equal(got, data) || mediaType != manifestMediaType {
+		return fmt.Errorf("GetManifest: got %q, %q, %v; want %q, %q", got, mediaType, err, data, manifestMediaType)
+	}
+	size, mediaType, err := s.GetManifestInfo(ctx, "app", digest)
+	if err != nil || size != int64(len(data)) || mediaType != manifestMediaType {
+		return fmt.Errorf("GetManifestInfo: got %d, %q, %v; want %d, %q", size, mediaType, err, len(data), manifestMediaType)
+	}
+	if err := expectList(ctx, "manifests", listManifests(s, "app"), digest); err != nil {
+		return err
+	}
+	// Manifests are per repository
+	if _, _, err := s.GetManifest(ctx, "other", digest); !os.IsNotExist(err) {
+		return fmt.Errorf("GetManifest from another repository: got %v, want a not-exist error", err)
+	}
+
+	// Manifests stored without a media type read back as Docker v2
+	untyped := []byte(`{"schemaVersion":2,"config":{}}`)
+	if err := s.PutManifest(ctx, "app", digestOf(untyped), untyped, ""); err != nil {
+		return fmt.Errorf("PutManifest without media type: %w", err)
+	}
+	if _, mediaType, err := s.GetManifest(ctx, "app", digestOf(untyped)); err != nil || mediaType != defaultMediaType {
+		return fmt.Errorf("GetManifest without media type: got %q, %v; want %q", mediaType, err, defaultMediaType)
+	}
+
+	if err := s.DeleteManifest(ctx, "app", digest); err != nil {
+		return fmt.Errorf("DeleteManifest: %w", err)
+	}
+	if _, _, err := s.GetManifest(ctx, "app", digest); !os.IsNotExist(err) {
+		return fmt.Errorf("GetManifest after delete: got %v, want a not-exist error", err)
+	}
+	return expectList(ctx, "manifests", listManifests(s, "app"), digestOf(untyped))
+}
+
+func checkTags(ctx context.Context, s storage.Storage) error {
+	first, second := digestOf([]byte("first")), digestOf([]byte("second"))
+
+	if _, err := s.GetTagDigest(ctx, "app", "latest"); !os.IsNotExist(err) {
+		return fmt.Errorf("GetTagDigest of a missing tag: got %v, want a not-exist error", err)
+	}
+	if err := s.DeleteTag(ctx, "app", "latest"); !os.IsNotExist(err) {
+		return fmt.Errorf("DeleteTag of a missing tag: got %v, want a not-exist error", err)
+	}
+
+	for tag, digest := range map[string]string{"latest": first, "v1": first} {
+		if err := s.PutTag(ctx, "app", tag, digest); err != nil {
+			return fmt.Errorf("PutTag %s: %w", tag, err)
+		}
+	}
+	if err := expectList(ctx, "tags", listTags(s, "app"), "latest", "v1"); err != nil {
+		return err
+	}
+
+	// Tags move
+	if err := s.PutTag(ctx, "app", "latest", second); err != nil {
+		return fmt.Errorf("PutTag over an existing tag: %w", err)
+	}
+	if digest, err := s.GetTagDigest(ctx, "app", "latest"); err != nil || digest != second {
+		return fmt.Errorf("GetTagDigest after move: got %q, %v; want %q", digest, err, second)
+	}
+
+	if err := s.DeleteTag(ctx, "app", "v1"); err != nil {
+		return fmt.Errorf("DeleteTag: %w", err)
+	}
+	return expectList(ctx, "tags", listTags(s, "app"), "latest")
+}
+
+func checkRepositories(ctx context.Context, s storage.Storage) error {
+	data := []byte(`{"schemaVersion":2}`)
+	digest := digestOf(data)
+
+	// A tag alone does not make a repository
+	if err := s.PutTag(ctx, "tagged", "latest", digest); err != nil {
+		return fmt.Errorf("PutTag: %w", err)
+	}
+	for _, repository := range []string{"app", "team/app", "team/app/sub"} {
+		if err := s.PutManifest(ctx, repository, digest, data, manifestMediaType); err != nil {
+			return fmt.Errorf("PutManifest %s: %w", repository, err)
+		}
+		if err := s.PutTag(ctx, repository, "latest", digest); err != nil {
+			return fmt.Errorf("PutTag %s: %w", repository, err)
+		}
+	}
+	if err := expectList(ctx, "repositories", s.ListRepositories, "app", "team/app", "team/app/sub"); err != nil {
+		return err
+	}
+
+	// Nested repositories do not show up as tags or manifests of their parent
+	if err := expectList(ctx, "tags", listTags(s, "team/app"), "latest"); err != nil {
+		return err
+	}
+	return expectList(ctx, "manifests", listManifests(s, "team/app"), digest)
+}
+
+func checkDeleteRepository(ctx context.Context, s storage.Storage) error {
+	manifest := []byte(`{"schemaVersion":2}`)
+	blob := []byte("shared layer")
+
+	if err := s.DeleteRepository(ctx, "app"); !os.IsNotExist(err) {
+		return fmt.Errorf("DeleteRepository of a missing repository: got %v, want a not-exist error", err)
+	}
+
+	if err := s.PutBlob(ctx, digestOf(blob), blob); err != nil {
+		return fmt.Errorf("PutBlob: %w", err)
+	}
+	for _, repository := range []string{"app", "app/nested"} {
+		if err := s.PutManifest(ctx, repository, digestOf(manifest), manifest, manifestMediaType); err != nil {
+			return fmt.Errorf("PutManifest %s: %w", repository, err)
+		}
+		if err := s.PutTag(ctx, repository, "latest", digestOf(manifest)); err != nil {
+			return fmt.Errorf("PutTag %s: %w", repository, err)
+		}
+		if err := s.PutRepositoryDescription(ctx, repository, "about "+repository); err != nil {
+			return fmt.Errorf("PutRepositoryDescription %s: %w", repository, err)
+		}
+	}
+
+	if err := s.DeleteRepository(ctx, "app"); err != nil {
+		return fmt.Errorf("DeleteRepository: %w", err)
+	}
+	if err := expectList(ctx, "repositories", s.ListRepositories, "app/nested"); err != nil {
+		return err
+	}
+	if err := expectList(ctx, "tags", listTags(s, "app")); err != nil {
+		return err
+	}
+	if description, err := s.GetRepositoryDescription(ctx, "app"); err != nil || description != "" {
+		return fmt.Errorf("description after delete: got %q, %v; want none", description, err)
+	}
+
+	// Nested repositories and blobs are left alone
+	if err := expectList(ctx, "tags", listTags(s, "app/nested"), "latest"); err != nil {
+		return err
+	}
+	if description, err := s.GetRepositoryDescription(ctx, "app/nested"); err != nil || description != "about app/nested" {
+		return fmt.Errorf("nested description after delete: got %q, %v", description, err)
+	}
+	return expectBlob(ctx, s, digestOf(blob), blob)
+}
+
+func checkUploads(ctx context.Context, s storage.Storage) error {
+	chunks := [][]byte{[]byte("first chunk, "), []byte("second chunk, ")}
+	final := []byte("final chunk")
+	data := bytes.Join(append(chunks, final), nil)
+
+	uploadID, err := s.StartBlobUpload(ctx)
+	if err != nil {
+		return fmt.Errorf("StartBlobUpload: %w", err)
+	}
+	if other, err := s.StartBlobUpload(ctx); err != nil || other == uploadID {
+		return fmt.Errorf("second StartBlobUpload: got %q, %v; want a distinct ID", other, err)
+	}
+
+	var want int64
+	for _, chunk := range chunks {
+		want += int64(len(chunk))
+		size, err := s.AppendBlobUpload(ctx, uploadID, chunk)
+		if err != nil || size != want {
+			return fmt.Errorf("AppendBlobUpload: got %d, %v; want %d", size, err, want)
+		}
+	}
+	if size, err := s.GetBlobUploadStatus(ctx, uploadID); err != nil || size != want {
+		return fmt.Errorf("GetBlobUploadStatus: got %d, %v; want %d", size, err, want)
+	}
+
+	if err := s.CompleteBlobUpload(ctx, uploadID, digestOf(data), final); err != nil {
+		return fmt.Errorf("CompleteBlobUpload: %w", err)
+	}
+	if err := expectBlob(ctx, s, digestOf(data), data); err != nil {
+		return err
+	}
+	if _, err := s.GetBlobUploadStatus(ctx, uploadID); err == nil {
+		return fmt.Errorf("GetBlobUploadStatus after completion succeeded, want an error")
+	}
+
+	// An empty upload completed without a final chunk is a valid blob
+	uploadID, err = s.StartBlobUpload(ctx)
+	if err != nil {
+		return fmt.Errorf("StartBlobUpload: %w", err)
+	}
+	if err := s.CompleteBlobUpload(ctx, uploadID, digestOf(nil), nil); err != nil {
+		return fmt.Errorf("CompleteBlobUpload of an empty blob: %w", err)
+	}
+	return expectBlob(ctx, s, digestOf(nil), nil)
+}
+
+func checkUploadMismatch(ctx context.Context, s storage.Storage) error {
+	uploadID, err := s.StartBlobUpload(ctx)
+	if err != nil {
+		return fmt.Errorf("StartBlobUpload: %w", err)
+	}
+	wrong := digestOf([]byte("something else"))
+	if err := s.CompleteBlobUpload(ctx, uploadID, wrong, []byte("content")); err == nil {
+		return fmt.Errorf("CompleteBlobUpload with the wrong digest succeeded")
+	}
+	if _, _, err := s.GetBlob(ctx, wrong); !os.IsNotExist(err) {
+		return fmt.Errorf("GetBlob after a rejected upload: got %v, want a not-exist error", err)
+	}
+	return nil
+}
+
+func checkCancelUpload(ctx context.Context, s storage.Storage) error {
+	uploadID, err := s.StartBlobUpload(ctx)
+	if err != nil {
+		return fmt.Errorf("StartBlobUpload: %w", err)
+	}
+	if _, err := s.AppendBlobUpload(ctx, uploadID, []byte("abandoned")); err != nil {
+		return fmt.Errorf("AppendBlobUpload: %w", err)
+	}
+	if err := s.CancelBlobUpload(ctx, uploadID); err != nil {
+		return fmt.Errorf("CancelBlobUpload: %w", err)
+	}
+	if _, err := s.AppendBlobUpload(ctx, uploadID, []byte("more")); err == nil {
+		return fmt.Errorf("AppendBlobUpload after cancel succeeded, want an error")
+	}
+	if err := s.CancelBlobUpload(ctx, uploadID); err == nil {
+		return fmt.Errorf("second CancelBlobUpload succeeded, want an error")
+	}
+	return expectList(ctx, "blobs", s.ListBlobs)
+}
+
+func checkDescriptions(ctx context.Context, s storage.Storage) error {
+	if description, err := s.GetRepositoryDescription(ctx, "app"); err != nil || description != "" {
+		return fmt.Errorf("missing description: got %q, %v; want none", description, err)
+	}
+	for _, want := range []string{"# App\n\nFirst version", "Second version"} {
+		if err := s.PutRepositoryDescription(ctx, "team/app", want); err != nil {
+			return fmt.Errorf("PutRepositoryDescription: %w", err)
+		}
+		if description, err := s.GetRepositoryDescription(ctx, "team/app"); err != nil || description != want {
+			return fmt.Errorf("GetRepositoryDescription: got %q, %v; want %q", description, err, want)
+		}
+	}
+	return nil
+}
+
+func checkTotalSize(ctx context.Context, s storage.Storage) error {
+	before, err := s.GetTotalStorageSize(ctx)
+	if err != nil {
+		return fmt.Errorf("GetTotalStorageSize: %w", err)
+	}
+	data := bytes.Repeat([]byte("x"), 4096)
+	if err := s.PutBlob(ctx, digestOf(data), data); err != nil {
+		return fmt.Errorf("PutBlob: %w", err)
+	}
+	after, err := s.GetTotalStorageSize(ctx)
+	if err != nil {
+		return fmt.Errorf("GetTotalStorageSize: %w", err)
+	}
+	if after < before+int64(len(data)) {
+		return fmt.Errorf("GetTotalStorageSize grew from %d to %d after storing %d bytes", before, after, len(data))
+	}
+	return nil
+}
+
+// expectBlob checks a blob's content and size
+func expectBlob(ctx context.Context, s storage.Storage, digest string, want []byte) error {
+	reader, size, err := s.GetBlob(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("GetBlob %s: %w", digest, err)
+	}
+	defer reader.Close()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("reading blob %s: %w", digest, err)
+	}
+	if !bytes.Equal(got, want) || size != int64(len(want)) {
+		return fmt.Errorf("GetBlob %s: got %q (size %d), want %q", digest, got, size, want)
+	}
+	if size, err := s.GetBlobSize(ctx, digest); err != nil || size != int64(len(want)) {
+		return fmt.Errorf("GetBlobSize %s: got %d, %v; want %d", digest, size, err, len(want))
+	}
+	return nil
+}
+
+// expectList checks that list returns want in any order
+func expectList(ctx context.Context, what string, list func(context.Context) ([]string, error), want ...string) error {
+	got, err := list(ctx)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", what, err)
+	}
+	got = append([]string{}, got...)
+	want = append([]string{}, want...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("listing %s: got %v, want %v", what, got, want)
+	}
+	return nil
+}
+
+func listTags(s storage.Storage, repository string) func(context.Context) ([]string, error) {
+	return func(ctx context.Context) ([]string, error) { return s.ListTags(ctx, repository) }
+}
+
+func listManifests(s storage.Storage, repository string) func(context.Context) ([]string, error) {
+	return func(ctx context.Context) ([]string, error) { return s.ListManifests(ctx, repository) }
+}
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
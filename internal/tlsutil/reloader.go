@@ -0,0 +1,159 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Reloader serves a certificate and key pair from disk and reloads it when
+// either file changes, so renewed certificates take effect without a restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+	lastErr error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReloader loads the key pair and starts polling it every interval
+func NewReloader(certFile, keyFile string, interval time.Duration) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	go r.watch(interval)
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// CheckHealth reports a failed reload, an expired certificate or a stopped
+// watcher
+func (r *Reloader) CheckHealth(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return fmt.Errorf("certificate watcher is not running")
+	default:
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.lastErr != nil {
+		return fmt.Errorf("certificate reload failed: %w", r.lastErr)
+	}
+	if leaf := r.cert.Leaf; leaf != nil && time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Close stops watching the files
+func (r *Reloader) Close() {
+	close(r.stop)
+	<-r.done
+}
+
+// watch reloads the key pair whenever a file's modification time advances
+func (r *Reloader) watch(interval time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+
+		modTime, err := r.latestModTime()
+		if err != nil {
+			r.setError(err)
+			continue
+		}
+
+		r.mutex.RLock()
+		changed := modTime.After(r.modTime)
+		r.mutex.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := r.load(); err != nil {
+			// Keep serving the previous certificate; a renewal may be half written
+			logrus.Errorf("Failed to reload TLS certificate: %v", err)
+			r.setError(err)
+			continue
+		}
+		logrus.Infof("Reloaded TLS certificate from %s", r.certFile)
+	}
+}
+
+// load reads and parses the key pair and makes it current
+func (r *Reloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+
+	r.mutex.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.lastErr = nil
+	r.mutex.Unlock()
+	return nil
+}
+
+func (r *Reloader) setError(err error) {
+	r.mutex.Lock()
+	r.lastErr = err
+	r.mutex.Unlock()
+}
+
+// latestModTime returns the newer modification time of the two files
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,109 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"docker-registry-manager/internal/config"
+)
+
+// http2CipherSuites lists the suites of which HTTP/2 (RFC 7540, section
+// 9.2.2) requires at least one when TLS 1.2 is negotiated
+var http2CipherSuites = map[uint16]bool{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: true,
+}
+
+// ServerConfig builds the TLS configuration for the registry listener from
+// cfg, serving certificates from reloader and offering HTTP/2
+func ServerConfig(cfg config.TLSConfig, reloader *Reloader) (*tls.Config, error) {
+	minVersion, err := parseVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	if len(cfg.CipherSuites) > 0 {
+		suites, err := parseCipherSuites(cfg.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = suites
+
+		if minVersion < tls.VersionTLS13 && !hasHTTP2CipherSuite(suites) {
+			return nil, fmt.Errorf("cipher_suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 for HTTP/2")
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// parseVersion maps "1.2" or "1.3" to the tls constant, defaulting to TLS 1.2
+func parseVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS min_version %q (use 1.2 or 1.3)", version)
+	}
+}
+
+// parseCipherSuites resolves Go cipher suite names such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, rejecting insecure ones
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func hasHTTP2CipherSuite(suites []uint16) bool {
+	for _, id := range suites {
+		if http2CipherSuites[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// RedirectHandler redirects plain HTTP requests to the same host and path
+// over HTTPS on httpsPort
+func RedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if httpsPort != 443 {
+			host += ":" + strconv.Itoa(httpsPort)
+		}
+
+		target := "https://" + host + req.URL.RequestURI()
+		// 308 keeps the method and body, so pushes are not turned into GETs
+		http.Redirect(w, req, target, http.StatusPermanentRedirect)
+	})
+}
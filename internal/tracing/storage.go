@@ -0,0 +1,233 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"docker-registry-manager/internal/storage"
+)
+
+// Span attribute keys
+const (
+	attrRepository = attribute.Key("registry.repository")
+	attrTag        = attribute.Key("registry.tag")
+	attrDigest     = attribute.Key("registry.digest")
+	attrUpload     = attribute.Key("registry.upload_id")
+	attrSize       = attribute.Key("registry.size")
+)
+
+// tracedStorage records a span around every call to the wrapped storage
+type tracedStorage struct {
+	next storage.Storage
+}
+
+// WrapStorage returns s with each method traced as "storage.<Method>"
+func WrapStorage(s storage.Storage) storage.Storage {
+	return &tracedStorage{next: s}
+}
+
+// start opens a storage span as a child of ctx
+func start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// finish records err on span and ends it
+func finish(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *tracedStorage) ListRepositories(ctx context.Context) ([]string, error) {
+	ctx, span := start(ctx, "ListRepositories")
+	repositories, err := t.next.ListRepositories(ctx)
+	finish(span, err)
+	return repositories, err
+}
+
+func (t *tracedStorage) DeleteRepository(ctx context.Context, repository string) error {
+	ctx, span := start(ctx, "DeleteRepository", attrRepository.String(repository))
+	err := t.next.DeleteRepository(ctx, repository)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) ListTags(ctx context.Context, repository string) ([]string, error) {
+	ctx, span := start(ctx, "ListTags", attrRepository.String(repository))
+	tags, err := t.next.ListTags(ctx, repository)
+	finish(span, err)
+	return tags, err
+}
+
+func (t *tracedStorage) GetTagDigest(ctx context.Context, repository, tag string) (string, error) {
+	ctx, span := start(ctx, "GetTagDigest", attrRepository.String(repository), attrTag.String(tag))
+	digest, err := t.next.GetTagDigest(ctx, repository, tag)
+	finish(span, err)
+	return digest, err
+}
+
+func (t *tracedStorage) PutTag(ctx context.Context, repository, tag, digest string) error {
+	ctx, span := start(ctx, "PutTag", attrRepository.String(repository), attrTag.String(tag), attrDigest.String(digest))
+	err := t.next.PutTag(ctx, repository, tag, digest)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) DeleteTag(ctx context.Context, repository, tag string) error {
+	ctx, span := start(ctx, "DeleteTag", attrRepository.String(repository), attrTag.String(tag))
+	err := t.next.DeleteTag(ctx, repository, tag)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) GetManifest(ctx context.Context, repository, digest string) ([]byte, string, error) {
+	ctx, span := start(ctx, "GetManifest", attrRepository.String(repository), attrDigest.String(digest))
+	data, mediaType, err := t.next.GetManifest(ctx, repository, digest)
+	span.SetAttributes(attrSize.Int(len(data)))
+	finish(span, err)
+	return data, mediaType, err
+}
+
+func (t *tracedStorage) GetManifestInfo(ctx context.Context, repository, digest string) (int64, string, error) {
+	ctx, span := start(ctx, "GetManifestInfo", attrRepository.String(repository), attrDigest.String(digest))
+	size, mediaType, err := t.next.GetManifestInfo(ctx, repository, digest)
+	finish(span, err)
+	return size, mediaType, err
+}
+
+func (t *tracedStorage) PutManifest(ctx context.Context, repository, digest string, data []byte, mediaType string) error {
+	ctx, span := start(ctx, "PutManifest", attrRepository.String(repository), attrDigest.String(digest), attrSize.Int(len(data)))
+	err := t.next.PutManifest(ctx, repository, digest, data, mediaType)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) DeleteManifest(ctx context.Context, repository, digest string) error {
+	ctx, span := start(ctx, "DeleteManifest", attrRepository.String(repository), attrDigest.String(digest))
+	err := t.next.DeleteManifest(ctx, repository, digest)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) ListManifests(ctx context.Context, repository string) ([]string, error) {
+	ctx, span := start(ctx, "ListManifests", attrRepository.String(repository))
+	digests, err := t.next.ListManifests(ctx, repository)
+	finish(span, err)
+	return digests, err
+}
+
+func (t *tracedStorage) GetBlob(ctx context.Context, digest string) (io.ReadCloser, int64, error) {
+	ctx, span := start(ctx, "GetBlob", attrDigest.String(digest))
+	reader, size, err := t.next.GetBlob(ctx, digest)
+	span.SetAttributes(attrSize.Int64(size))
+	finish(span, err)
+	return reader, size, err
+}
+
+func (t *tracedStorage) GetBlobSize(ctx context.Context, digest string) (int64, error) {
+	ctx, span := start(ctx, "GetBlobSize", attrDigest.String(digest))
+	size, err := t.next.GetBlobSize(ctx, digest)
+	finish(span, err)
+	return size, err
+}
+
+func (t *tracedStorage) PutBlob(ctx context.Context, digest string, data []byte) error {
+	ctx, span := start(ctx, "PutBlob", attrDigest.String(digest), attrSize.Int(len(data)))
+	err := t.next.PutBlob(ctx, digest, data)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) DeleteBlob(ctx context.Context, digest string) error {
+	ctx, span := start(ctx, "DeleteBlob", attrDigest.String(digest))
+	err := t.next.DeleteBlob(ctx, digest)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) ListBlobs(ctx context.Context) ([]string, error) {
+	ctx, span := start(ctx, "ListBlobs")
+	digests, err := t.next.ListBlobs(ctx)
+	finish(span, err)
+	return digests, err
+}
+
+func (t *tracedStorage) QuarantineBlob(ctx context.Context, digest string) error {
+	ctx, span := start(ctx, "QuarantineBlob", attrDigest.String(digest))
+	err := t.next.QuarantineBlob(ctx, digest)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) IsBlobQuarantined(ctx context.Context, digest string) (bool, error) {
+	ctx, span := start(ctx, "IsBlobQuarantined", attrDigest.String(digest))
+	quarantined, err := t.next.IsBlobQuarantined(ctx, digest)
+	finish(span, err)
+	return quarantined, err
+}
+
+func (t *tracedStorage) StartBlobUpload(ctx context.Context) (string, error) {
+	ctx, span := start(ctx, "StartBlobUpload")
+	uploadID, err := t.next.StartBlobUpload(ctx)
+	span.SetAttributes(attrUpload.String(uploadID))
+	finish(span, err)
+	return uploadID, err
+}
+
+func (t *tracedStorage) AppendBlobUpload(ctx context.Context, uploadID string, data []byte) (int64, error) {
+	ctx, span := start(ctx, "AppendBlobUpload", attrUpload.String(uploadID), attrSize.Int(len(data)))
+	offset, err := t.next.AppendBlobUpload(ctx, uploadID, data)
+	finish(span, err)
+	return offset, err
+}
+
+func (t *tracedStorage) GetBlobUploadStatus(ctx context.Context, uploadID string) (int64, error) {
+	ctx, span := start(ctx, "GetBlobUploadStatus", attrUpload.String(uploadID))
+	offset, err := t.next.GetBlobUploadStatus(ctx, uploadID)
+	finish(span, err)
+	return offset, err
+}
+
+func (t *tracedStorage) CompleteBlobUpload(ctx context.Context, uploadID, digest string, finalChunk []byte) error {
+	ctx, span := start(ctx, "CompleteBlobUpload", attrUpload.String(uploadID), attrDigest.String(digest), attrSize.Int(len(finalChunk)))
+	err := t.next.CompleteBlobUpload(ctx, uploadID, digest, finalChunk)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) CancelBlobUpload(ctx context.Context, uploadID string) error {
+	ctx, span := start(ctx, "CancelBlobUpload", attrUpload.String(uploadID))
+	err := t.next.CancelBlobUpload(ctx, uploadID)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) GetRepositoryDescription(ctx context.Context, repository string) (string, error) {
+	ctx, span := start(ctx, "GetRepositoryDescription", attrRepository.String(repository))
+	description, err := t.next.GetRepositoryDescription(ctx, repository)
+	finish(span, err)
+	return description, err
+}
+
+func (t *tracedStorage) PutRepositoryDescription(ctx context.Context, repository string, description string) error {
+	ctx, span := start(ctx, "PutRepositoryDescription", attrRepository.String(repository))
+	err := t.next.PutRepositoryDescription(ctx, repository, description)
+	finish(span, err)
+	return err
+}
+
+func (t *tracedStorage) GetTotalStorageSize(ctx context.Context) (int64, error) {
+	ctx, span := start(ctx, "GetTotalStorageSize")
+	size, err := t.next.GetTotalStorageSize(ctx)
+	finish(span, err)
+	return size, err
+}
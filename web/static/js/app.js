@@ -15,6 +15,10 @@ const App = {
         this.setupEventListeners();
         this.startAutoRefresh();
         this.initDescriptionEditor();
+        this.initTokenManager();
+        this.initTagManager();
+        this.initActivityStream();
+        this.restoreSelectValues();
         console.log('Docker Registry Manager initialized');
     },
 
@@ -292,6 +296,195 @@ const App = {
                 renderAndDisplay(currentDescription); // Revert to original and display
             });
         }
+    },
+
+    // Restore <select> values rendered into data-value attributes
+    restoreSelectValues() {
+        document.querySelectorAll('select[data-value]').forEach(select => {
+            select.value = select.dataset.value;
+        });
+    },
+
+    // Stream registry activity into the index page
+    initActivityStream() {
+        const list = document.getElementById('activity-list');
+        if (!list || !window.EventSource) return;
+
+        const status = document.getElementById('activity-status');
+        const empty = document.getElementById('activity-empty');
+        const labels = {
+            manifest_push: '推送镜像',
+            tag_update: '标签移动',
+            tag_delete: '删除标签',
+            manifest_delete: '删除清单',
+            blob_push: '上传层',
+            blob_delete: '删除层',
+            blob_quarantine: '隔离层',
+            upload_progress: '上传中'
+        };
+        const maxItems = 50;
+        let statsTimer = null;
+
+        const setStatus = (connected) => {
+            if (!status) return;
+            status.classList.toggle('connected', connected);
+            status.querySelector('span').textContent = connected ? '实时' : '重新连接中';
+        };
+
+        const escape = (text) => {
+            const div = document.createElement('div');
+            div.textContent = text;
+            return div.innerHTML;
+        };
+
+        const formatSize = (bytes) => {
+            if (bytes >= 1024 * 1024) return (bytes / 1024 / 1024).toFixed(2) + ' MB';
+            if (bytes >= 1024) return (bytes / 1024).toFixed(1) + ' KB';
+            return bytes + ' B';
+        };
+
+        const render = (item, event) => {
+            const time = new Date(event.time).toLocaleTimeString();
+            let target = event.repository ? `<a href="/repositories/${escape(event.repository)}">${escape(event.repository)}</a>` : '';
+            if (event.tag) target += ':' + escape(event.tag);
+            if (event.type === 'upload_progress') {
+                target += ` <code>${formatSize(event.size)}</code>`;
+            } else if (event.digest) {
+                target += ` <code title="${escape(event.digest)}">${escape(event.digest)}</code>`;
+            }
+
+            item.className = `activity-item activity-${event.type}`;
+            item.innerHTML = `
+                <span class="activity-time">${time}</span>
+                <span class="activity-type">${labels[event.type] || escape(event.type)}</span>
+                <span class="activity-target">${target}</span>
+                ${event.actor ? `<span class="activity-actor">${escape(event.actor)}</span>` : ''}
+            `;
+        };
+
+        const refreshStatsSoon = () => {
+            clearTimeout(statsTimer);
+            statsTimer = setTimeout(() => this.refreshStats(), 500);
+        };
+
+        const handle = (e) => {
+            const event = JSON.parse(e.data);
+
+            // Upload progress updates a single row per upload
+            let item = event.upload ? list.querySelector(`[data-upload="${CSS.escape(event.upload)}"]`) : null;
+            if (item && event.type !== 'upload_progress') {
+                item.remove();
+                item = null;
+            }
+            if (!item) {
+                item = document.createElement('li');
+                if (event.type === 'upload_progress') item.dataset.upload = event.upload;
+                list.prepend(item);
+            }
+            render(item, event);
+
+            while (list.children.length > maxItems) {
+                list.lastElementChild.remove();
+            }
+            if (empty) empty.style.display = 'none';
+            if (event.type !== 'upload_progress') refreshStatsSoon();
+        };
+
+        const source = new EventSource(`${this.config.apiBase}/events`);
+        Object.keys(labels).forEach(type => source.addEventListener(type, handle));
+        source.onopen = () => setStatus(true);
+        source.onerror = () => setStatus(false);
+    },
+
+    // Initialize tag deletion buttons on the repository page
+    initTagManager() {
+        document.querySelectorAll('.delete-tag-btn').forEach(button => {
+            button.addEventListener('click', async () => {
+                const { repository, tag } = button.dataset;
+                if (!confirm(`确定要删除标签 ${repository}:${tag} 吗？`)) return;
+
+                try {
+                    const response = await fetch(`${this.config.apiBase}/repositories/${repository}/tags/${encodeURIComponent(tag)}`, {
+                        method: 'DELETE'
+                    });
+                    if (response.ok) {
+                        button.closest('.table-row').remove();
+                        this.showToast(`标签已删除: ${tag}`, 'success');
+                    } else if (response.status === 403) {
+                        this.showToast('没有删除该标签的权限', 'error');
+                    } else {
+                        this.showToast('删除失败', 'error');
+                    }
+                } catch (error) {
+                    this.showToast(`网络错误，删除失败: ${error.message}`, 'error');
+                }
+            });
+        });
+    },
+
+    // Initialize robot account / access token management
+    initTokenManager() {
+        const form = document.getElementById('token-form');
+        if (!form) return; // Not on the tokens page
+
+        form.addEventListener('submit', async (e) => {
+            e.preventDefault();
+
+            const actions = [];
+            if (document.getElementById('token-pull').checked) actions.push('pull');
+            if (document.getElementById('token-push').checked) actions.push('push');
+
+            const repository = document.getElementById('token-repository').value.trim();
+            const body = {
+                name: document.getElementById('token-name').value.trim(),
+                robot: document.getElementById('token-type').value === 'robot',
+                scopes: repository ? [{ repository, actions }] : [],
+                expires_in_days: parseInt(document.getElementById('token-expires').value, 10) || 0
+            };
+
+            try {
+                const response = await fetch(`${this.config.apiBase}/admin/tokens`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(body)
+                });
+                const data = await response.json();
+                if (!response.ok) {
+                    const message = data.errors && data.errors[0] ? data.errors[0].message : response.statusText;
+                    this.showToast(`创建失败: ${message}`, 'error');
+                    return;
+                }
+
+                const secretBox = document.getElementById('token-secret');
+                const command = document.getElementById('token-login-command');
+                command.textContent = `docker login ${window.location.host} -u '${data.username}' -p ${data.secret}`;
+                secretBox.style.display = 'block';
+                document.getElementById('copy-token-btn').onclick = () => this.copyToClipboard(data.secret);
+                this.showToast('令牌已创建', 'success');
+            } catch (error) {
+                this.showToast(`网络错误，创建失败: ${error.message}`, 'error');
+            }
+        });
+
+        document.querySelectorAll('.revoke-token-btn').forEach(button => {
+            button.addEventListener('click', async () => {
+                if (!confirm(`确定要吊销 ${button.dataset.name} 吗？`)) return;
+
+                try {
+                    const response = await fetch(`${this.config.apiBase}/admin/tokens/${button.dataset.id}`, {
+                        method: 'DELETE'
+                    });
+                    if (response.ok) {
+                        button.closest('.table-row').remove();
+                        this.showToast('令牌已吊销', 'success');
+                    } else {
+                        this.showToast('吊销失败', 'error');
+                    }
+                } catch (error) {
+                    this.showToast(`网络错误，吊销失败: ${error.message}`, 'error');
+                }
+            });
+        });
     }
 };
 
@@ -365,7 +558,9 @@ document.addEventListener('DOMContentLoaded', () => {
                 if (resp.ok) {
                     window.location.href = '/';
                 } else {
-                    const msg = resp.status === 401 ? '用户名或密码错误' : '登录失败';
+                    let msg = '登录失败';
+                    if (resp.status === 401) msg = '用户名或密码错误';
+                    if (resp.status === 429) msg = '失败次数过多，请稍后再试';
                     errorDiv.textContent = msg;
                 }
             } catch (err) {